@@ -0,0 +1,65 @@
+// apikey creates and revokes the API keys other services use for the gRPC API:
+//
+//	go run ./cmd/apikey -name billing -roles service
+//	go run ./cmd/apikey -name billing -revoke
+//
+// The key is printed once, only its hash is stored.
+package main
+
+import (
+	"AuthDB/cmd/app/repository"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	name := flag.String("name", "", "name of the service the key is for")
+	roles := flag.String("roles", "service", "comma separated roles of the key")
+	revoke := flag.Bool("revoke", false, "revoke the key instead of creating one")
+	flag.Parse()
+
+	if *name == "" {
+		log.Fatalf("-name is required")
+	}
+
+	// the env file is optional, DATABASE_URL may come from the environment
+	_ = godotenv.Load("configs/db.env")
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		log.Fatalf("DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	dbpool, err := repository.InitDBConn(ctx, dbURL)
+	if err != nil {
+		log.Fatalf("Error initializing DB connection: %v", err)
+	}
+	defer dbpool.Close()
+	repo := repository.NewRepository(dbpool)
+
+	if *revoke {
+		if err := repo.RevokeAPIKey(ctx, nil, *name); err != nil {
+			log.Fatalf("Failed to revoke api key: %v", err)
+		}
+		fmt.Printf("API key %s revoked\n", *name)
+		return
+	}
+
+	var roleList []string
+	for _, r := range strings.Split(*roles, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roleList = append(roleList, r)
+		}
+	}
+	key, _, err := repo.CreateAPIKey(ctx, nil, *name, roleList)
+	if err != nil {
+		log.Fatalf("Failed to create api key: %v", err)
+	}
+	fmt.Printf("API key for %s (keep it, it is shown only once):\n%s\n", *name, key)
+}
@@ -0,0 +1,343 @@
+// Package auth contains the credential checks shared by every transport.
+// The HTTP handlers and the gRPC AuthService both go through Service,
+// so lockout, hashing, sessions and events behave the same everywhere.
+package auth
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/cmd/internal/kafka"
+	"AuthDB/utils"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("incorrect password")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrAccountLocked      = errors.New("account is temporarily locked")
+	ErrAccountDisabled    = errors.New("account is disabled")
+	ErrAccountDeleted     = errors.New("account is deleted") // by an admin, or the grace period is over
+	ErrInvalidToken       = errors.New("invalid token")
+)
+
+const (
+	// after MaxFailedAttempts wrong passwords in a row
+	// the username is locked for LockoutDuration
+	MaxFailedAttempts = 5
+	LockoutDuration   = 15 * time.Minute
+
+	// token lifetime, with and without "remember me"
+	DefaultTTL    = 1 * time.Hour
+	RememberMeTTL = 24 * time.Hour * 15
+
+	// how long an account stays pending deletion by default, see SetDeletionGrace
+	DefaultDeletionGrace = 30 * 24 * time.Hour
+)
+
+// Session is what a successful login returns
+type Session struct {
+	Token     string
+	User      *repository.User
+	ExpiresAt time.Time
+}
+
+// Claims are the verified contents of a token
+type Claims struct {
+	UserID    int
+	Username  string
+	Roles     []string
+	ExpiresAt time.Time
+}
+
+type attempts struct {
+	failed      int
+	lockedUntil time.Time
+}
+
+type Service struct {
+	users repository.UserStore
+	// the login history, see RecordEvents
+	events repository.EventLog
+	// logging in keeps an account the user asked to delete this long
+	deletionGrace time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	failures map[string]*attempts
+}
+
+func NewService(users repository.UserStore) *Service {
+	return &Service{
+		users:         users,
+		deletionGrace: DefaultDeletionGrace,
+		sessions:      make(map[string]*Session),
+		failures:      make(map[string]*attempts),
+	}
+}
+
+// RecordEvents keeps the events of the users in events too, besides Kafka
+func (s *Service) RecordEvents(events repository.EventLog) {
+	s.events = events
+}
+
+// SetDeletionGrace is the grace period of the Purger, logging in keeps the account only until it's over
+func (s *Service) SetDeletionGrace(grace time.Duration) {
+	s.deletionGrace = grace
+}
+
+// UserSessions are the open sessions of the user
+func (s *Service) UserSessions(userID int) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := []Session{}
+	for _, session := range s.sessions {
+		if session.User.ID == userID && time.Now().Before(session.ExpiresAt) {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ExpiresAt.Before(sessions[j].ExpiresAt) })
+	return sessions
+}
+
+// Login checks username and password and opens a new session.
+// Wrong passwords are counted per username, see MaxFailedAttempts.
+func (s *Service) Login(ctx context.Context, username, password string, rememberMe bool) (*Session, error) {
+	if s.isLocked(username) {
+		publish("login_locked", map[string]interface{}{"username": username})
+		return nil, ErrAccountLocked
+	}
+
+	found, err := s.users.GetUserByUsername(ctx, username)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error querying user: %w", err)
+	}
+	user := &found
+
+	// Compare user password and login password using byte
+	if !utils.CompareHashPassword(password, user.Password) {
+		s.registerFailure(username)
+		s.publishUser(ctx, user.ID, "login_failed", nil)
+		return nil, ErrInvalidCredentials
+	}
+	s.resetFailures(username)
+	// only after the password, so a disabled account doesn't reveal itself to guessing
+	if user.DisabledAt != nil {
+		s.publishUser(ctx, user.ID, "login_disabled", nil)
+		return nil, ErrAccountDisabled
+	}
+	// logging in during the grace period keeps the account the user asked to delete,
+	// the deletion by an admin stays until RestoreUser and the purge may just not have run yet
+	if user.Status == repository.StatusPendingDeletion {
+		if !user.SelfDeletion() || time.Since(*user.DeletionRequestedAt) >= s.deletionGrace {
+			s.publishUser(ctx, user.ID, "login_deleted", nil)
+			return nil, ErrAccountDeleted
+		}
+		if err := s.users.CancelDeletion(ctx, user.ID); err != nil {
+			return nil, fmt.Errorf("error cancelling the deletion: %w", err)
+		}
+		user.Status, user.DeletionRequestedAt, user.DeletionRequestedBy = repository.StatusActive, nil, nil
+		s.publishUser(ctx, user.ID, "deletion_cancelled", nil)
+	}
+
+	ttl := DefaultTTL
+	if rememberMe {
+		ttl = RememberMeTTL
+	}
+	expiresAt := time.Now().Add(ttl)
+	token, err := generateToken(user, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("error generate token: %w", err)
+	}
+
+	session := &Session{Token: token, User: user, ExpiresAt: expiresAt}
+	s.mu.Lock()
+	s.sessions[token] = session
+	s.mu.Unlock()
+
+	s.publishUser(ctx, user.ID, "login", nil)
+	return session, nil
+}
+
+// Validate verifies the token signature and expiry
+// and makes sure the session was not closed by Logout.
+func (s *Service) Validate(token string) (*Claims, error) {
+	session, ok := s.Session(token)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	claims, err := parseToken(token)
+	if err != nil {
+		s.forget(token)
+		return nil, err
+	}
+	// roles can change while the session is alive, see SetRoles
+	s.mu.Lock()
+	claims.Roles = session.User.Roles
+	s.mu.Unlock()
+	return claims, nil
+}
+
+// Session returns the open session for the token
+func (s *Service) Session(token string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[token]
+	if ok && time.Now().After(session.ExpiresAt) {
+		delete(s.sessions, token)
+		return nil, false
+	}
+	return session, ok
+}
+
+// Logout closes the session, the token can't be used after that
+func (s *Service) Logout(token string) error {
+	session, ok := s.Session(token)
+	if !ok {
+		return ErrInvalidToken
+	}
+	s.forget(token)
+	s.publishUser(context.Background(), session.User.ID, "logout", nil)
+	return nil
+}
+
+// LogoutUser closes every session of the user (e.g. after account deletion)
+func (s *Service) LogoutUser(userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, session := range s.sessions {
+		if session.User.ID == userID {
+			delete(s.sessions, token)
+		}
+	}
+}
+
+// SetRoles updates the roles of the user's open sessions
+// after they were changed by an admin
+func (s *Service) SetRoles(userID int, roles []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, session := range s.sessions {
+		if session.User.ID == userID {
+			session.User.Roles = roles
+		}
+	}
+}
+
+func (s *Service) forget(token string) {
+	s.mu.Lock()
+	delete(s.sessions, token)
+	s.mu.Unlock()
+}
+
+func (s *Service) isLocked(username string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.failures[username]
+	return ok && time.Now().Before(a.lockedUntil)
+}
+
+func (s *Service) registerFailure(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.failures[username]
+	if !ok {
+		a = &attempts{}
+		s.failures[username] = a
+	}
+	a.failed++
+	if a.failed >= MaxFailedAttempts {
+		a.failed = 0
+		a.lockedUntil = time.Now().Add(LockoutDuration)
+	}
+}
+
+func (s *Service) resetFailures(username string) {
+	s.mu.Lock()
+	delete(s.failures, username)
+	s.mu.Unlock()
+}
+
+func generateToken(user *repository.User, expiresAt time.Time) (string, error) {
+	// jti makes every token unique, even two logins in the same second
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"roles":    user.Roles,
+		"exp":      expiresAt.Unix(),
+		"iat":      time.Now().Unix(),
+		"jti":      hex.EncodeToString(jti),
+	})
+	return token.SignedString([]byte(os.Getenv("JWT_KEY")))
+}
+
+func parseToken(token string) (*Claims, error) {
+	parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return []byte(os.Getenv("JWT_KEY")), nil
+	})
+	if err != nil || !parsed.Valid {
+		return nil, ErrInvalidToken
+	}
+	mc, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	userID, _ := mc["user_id"].(float64)
+	username, _ := mc["username"].(string)
+	var roles []string
+	if list, ok := mc["roles"].([]interface{}); ok {
+		for _, r := range list {
+			if name, ok := r.(string); ok {
+				roles = append(roles, name)
+			}
+		}
+	}
+	exp, _ := mc["exp"].(float64)
+	return &Claims{
+		UserID:    int(userID),
+		Username:  username,
+		Roles:     roles,
+		ExpiresAt: time.Unix(int64(exp), 0),
+	}, nil
+}
+
+// publish writes an auth event to Kafka, failures are only logged
+func publish(event string, fields map[string]interface{}) {
+	if err := kafka.ProduceEvent(event, fields); err != nil {
+		log.Println("Failed to produce Kafka message:", err)
+	}
+}
+
+// publishUser publishes the event of the user and adds it to the login history
+func (s *Service) publishUser(ctx context.Context, userID int, event string, details map[string]interface{}) {
+	fields := map[string]interface{}{"user_id": userID}
+	for k, v := range details {
+		fields[k] = v
+	}
+	publish(event, fields)
+	if s.events == nil {
+		return
+	}
+	if err := s.events.RecordEvent(ctx, &repository.UserEvent{UserID: userID, Event: event, Details: details}); err != nil {
+		log.Printf("Failed to record %s of user %d: %v", event, userID, err)
+	}
+}
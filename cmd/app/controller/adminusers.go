@@ -0,0 +1,440 @@
+// Admin JSON API for user accounts
+package controller
+
+import (
+	"AuthDB/cmd/app/controller/helper"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"AuthDB/utils"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// every route needs users:manage, the changes are published as admin_user_* events
+func (a *App) adminUserRoutes(admin *mux.Router) {
+	manage := func(h http.HandlerFunc) http.HandlerFunc {
+		return a.wrapHandler(a.apiAuthorized(a.permitted("users", "manage", h)))
+	}
+
+	admin.HandleFunc("/users", manage(a.AdminListUsers)).Methods("GET")
+	admin.HandleFunc("/users", manage(a.AdminCreateUser)).Methods("POST")
+	admin.HandleFunc("/users/search", manage(a.AdminSearchUsers)).Methods("GET")
+	admin.HandleFunc("/users/{userID:[0-9]+}", manage(a.AdminGetUser)).Methods("GET")
+	admin.HandleFunc("/users/{userID:[0-9]+}", manage(a.AdminUpdateUser)).Methods("PATCH")
+	admin.HandleFunc("/users/{userID:[0-9]+}", manage(a.AdminDeleteUser)).Methods("DELETE")
+	admin.HandleFunc("/users/{userID:[0-9]+}/disable", manage(a.AdminDisableUser)).Methods("POST")
+	admin.HandleFunc("/users/{userID:[0-9]+}/enable", manage(a.AdminEnableUser)).Methods("POST")
+	admin.HandleFunc("/users/{userID:[0-9]+}/logout", manage(a.AdminLogoutUser)).Methods("POST")
+	admin.HandleFunc("/users/{userID:[0-9]+}/restore", manage(a.AdminRestoreUser)).Methods("POST")
+	admin.HandleFunc("/users/{userID:[0-9]+}/roles", manage(a.AdminSetUserRoles)).Methods("PUT")
+	admin.HandleFunc("/users/{userID:[0-9]+}/export", manage(a.AdminExportUser)).Methods("GET")
+	admin.HandleFunc("/users/{userID:[0-9]+}/erase", manage(a.AdminEraseUser)).Methods("POST")
+}
+
+// AdminUserResponse is UserResponse with what only admins see
+type AdminUserResponse struct {
+	UserResponse
+	Disabled            bool                     `json:"disabled"`
+	DisabledAt          *time.Time               `json:"disabled_at,omitempty"`
+	Status              repository.AccountStatus `json:"status"`
+	DeletionRequestedAt *time.Time               `json:"deletion_requested_at,omitempty"`
+	DeletionRequestedBy *int                     `json:"deletion_requested_by,omitempty"`
+}
+
+func adminUserResponse(u *repository.User) AdminUserResponse {
+	return AdminUserResponse{UserResponse: userResponse(u), Disabled: u.DisabledAt != nil, DisabledAt: u.DisabledAt,
+		Status: u.Status, DeletionRequestedAt: u.DeletionRequestedAt, DeletionRequestedBy: u.DeletionRequestedBy}
+}
+
+type userPage struct {
+	Users []AdminUserResponse `json:"users"`
+	Limit int                 `json:"limit"`
+	// the cursor parameter of the next page, none on the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+	// with total=true only
+	Total *int `json:"total,omitempty"`
+}
+
+// GET /api/v1/admin/users?q=&role=&disabled=&status=&created_from=&created_to=&email_domain=
+// &sort=-created_at&limit=&cursor=&total=
+func (a *App) AdminListUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := userFilterFromQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+		return
+	}
+	users, err := a.users.ListUsers(r.Context(), filter)
+	if err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	page := userPage{Users: make([]AdminUserResponse, len(users.Users)), Limit: filter.Limit,
+		NextCursor: users.Next, Total: users.Total}
+	if page.Limit == 0 {
+		page.Limit = access.DefaultUserPageSize
+	}
+	for i := range users.Users {
+		page.Users[i] = adminUserResponse(&users.Users[i])
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// userFilterFromQuery reads the listing parameters of the admin API and the users page,
+// a "-" before the sort column sorts descending
+func userFilterFromQuery(query url.Values) (repository.UserFilter, error) {
+	filter := repository.UserFilter{
+		Search:      query.Get("q"),
+		Role:        query.Get("role"),
+		Status:      repository.AccountStatus(query.Get("status")),
+		EmailDomain: query.Get("email_domain"),
+		Sort:        strings.TrimPrefix(query.Get("sort"), "-"),
+		Desc:        strings.HasPrefix(query.Get("sort"), "-"),
+		Cursor:      query.Get("cursor"),
+	}
+	if v := query.Get("disabled"); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, errors.New("disabled must be true or false")
+		}
+		filter.Disabled = &disabled
+	}
+	if v := query.Get("total"); v != "" {
+		var err error
+		if filter.CountTotal, err = strconv.ParseBool(v); err != nil {
+			return filter, errors.New("total must be true or false")
+		}
+	}
+	for name, field := range map[string]**time.Time{"created_from": &filter.CreatedFrom, "created_to": &filter.CreatedTo} {
+		if v := query.Get(name); v != "" {
+			t, err := queryTime(v)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be a date (2006-01-02) or a RFC 3339 time", name)
+			}
+			*field = &t
+		}
+	}
+	var err error
+	if filter.Limit, err = queryInt(query.Get("limit")); err != nil {
+		return filter, errors.New("limit must be a number")
+	}
+	return filter, nil
+}
+
+func queryInt(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(v)
+}
+
+func queryTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+type highlight struct {
+	Field string `json:"field"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+type userMatch struct {
+	User AdminUserResponse `json:"user"`
+	Rank float64           `json:"rank"`
+	// byte offsets in the username or the email
+	Highlights []highlight `json:"highlights"`
+}
+
+type searchResult struct {
+	Matches []userMatch `json:"matches"`
+}
+
+// GET /api/v1/admin/users/search?q=&limit=
+func (a *App) AdminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r.URL.Query().Get("limit"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "limit must be a number")
+		return
+	}
+	matches, err := a.users.SearchUsers(r.Context(), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	result := searchResult{Matches: make([]userMatch, len(matches))}
+	for i, m := range matches {
+		result.Matches[i] = userMatch{User: adminUserResponse(&m.User), Rank: m.Rank, Highlights: []highlight{}}
+		for _, h := range m.Highlights {
+			result.Matches[i].Highlights = append(result.Matches[i].Highlights, highlight{Field: h.Field, Start: h.Start, End: h.End})
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (a *App) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := a.users.GetUser(r.Context(), pathID(r, "userID"))
+	if err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	setETag(w, &user)
+	writeJSON(w, http.StatusOK, adminUserResponse(&user))
+}
+
+type createUserRequest struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Password string   `json:"password"`
+	Roles    []string `json:"roles"`
+}
+
+func (a *App) AdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	username := strings.TrimSpace(req.Username)
+	email := strings.TrimSpace(req.Email)
+	password := strings.TrimSpace(req.Password)
+	// the admin sets the password, there is no confirmation
+	if msg := validateSignup(username, email, password, password); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, msg)
+		return
+	}
+
+	user, err := a.users.CreateUser(r.Context(), username, email, password, req.Roles)
+	if err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	a.audit(r, "admin_user_created", user.ID, map[string]interface{}{"roles": user.Roles})
+	setETag(w, user)
+	writeJSON(w, http.StatusCreated, adminUserResponse(user))
+}
+
+// omitted fields stay as they are
+type adminUpdateUserRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+	Password *string `json:"password"`
+}
+
+func (a *App) AdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	var req adminUpdateUserRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	// on the primary like UpdateProfile, for If-Match
+	ctx := repository.OnPrimary(r.Context())
+	user, err := a.users.GetUser(ctx, pathID(r, "userID"))
+	if err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	version, ok := checkIfMatch(w, r, &user)
+	if !ok {
+		return
+	}
+
+	patch := repository.UserPatch{Version: version}
+	var changed []string
+	if req.Username != nil && strings.TrimSpace(*req.Username) != user.Username {
+		username := strings.TrimSpace(*req.Username)
+		if msg := helper.ValidateUsername(username); msg != "" {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, msg)
+			return
+		}
+		patch.Username = &username
+		changed = append(changed, "username")
+	}
+	if req.Email != nil && strings.TrimSpace(*req.Email) != user.Email {
+		email := strings.TrimSpace(*req.Email)
+		if msg := helper.ValidateEmail(email); msg != "" {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, msg)
+			return
+		}
+		patch.Email = &email
+		changed = append(changed, "email")
+	}
+	if req.Password != nil {
+		password := strings.TrimSpace(*req.Password)
+		if msg := helper.ValidatePassword(password); msg != "" {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, msg)
+			return
+		}
+		hash, err := utils.GenerateHash(password)
+		if err != nil {
+			a.writeAdminUserError(w, err)
+			return
+		}
+		patch.Password = &hash
+		changed = append(changed, "password")
+	}
+
+	if !patch.Empty() {
+		if user, err = a.users.UpdateUser(ctx, user.ID, patch); err != nil {
+			a.writeAdminUserError(w, err)
+			return
+		}
+		a.audit(r, "admin_user_updated", user.ID, map[string]interface{}{"fields": changed})
+	}
+	setETag(w, &user)
+	writeJSON(w, http.StatusOK, adminUserResponse(&user))
+}
+
+func (a *App) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	a.setUserDisabled(w, r, true)
+}
+
+func (a *App) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	a.setUserDisabled(w, r, false)
+}
+
+func (a *App) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	userID := pathID(r, "userID")
+	if disabled && userID == requestClaims(r).UserID {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "You can't disable your own account")
+		return
+	}
+	if err := a.users.SetDisabled(r.Context(), userID, disabled); err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	event := "admin_user_enabled"
+	if disabled {
+		event = "admin_user_disabled"
+	}
+	a.audit(r, event, userID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminLogoutUser closes every session of the user
+func (a *App) AdminLogoutUser(w http.ResponseWriter, r *http.Request) {
+	userID := pathID(r, "userID")
+	if err := a.users.ForceLogout(r.Context(), userID); err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	a.audit(r, "admin_user_logged_out", userID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// {"roles": [...]} replaces the roles assigned to the user directly
+func (a *App) AdminSetUserRoles(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Roles []string `json:"roles"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Roles == nil {
+		req.Roles = []string{}
+	}
+	userID := pathID(r, "userID")
+	if err := a.users.SetRoles(r.Context(), userID, req.Roles); err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	a.audit(r, "admin_user_roles_set", userID, map[string]interface{}{"roles": req.Roles})
+
+	userRoles, err := a.roles.UserRoles(r.Context(), userID)
+	if err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, userRoles)
+}
+
+// AdminDeleteUser logs the user out, the account is purged after the grace period
+func (a *App) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID := pathID(r, "userID")
+	if userID == requestClaims(r).UserID {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Use DELETE /api/v1/me to delete your own account")
+		return
+	}
+	if err := a.users.DeleteUser(r.Context(), userID, requestClaims(r).UserID); err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	a.audit(r, "admin_user_deleted", userID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminRestoreUser cancels the deletion during the grace period
+func (a *App) AdminRestoreUser(w http.ResponseWriter, r *http.Request) {
+	userID := pathID(r, "userID")
+	if err := a.users.RestoreUser(r.Context(), userID); err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	a.audit(r, "admin_user_restored", userID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminExportUser sends everything kept about the user like GET /api/v1/me/export
+func (a *App) AdminExportUser(w http.ResponseWriter, r *http.Request) {
+	userID := pathID(r, "userID")
+	if err := a.writeExport(w, r, userID); err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	a.audit(r, "admin_user_exported", userID, nil)
+}
+
+// AdminEraseUser anonymizes the account right away, for erasure requests
+// that can't wait for the grace period. Downstream services get user_erased.
+func (a *App) AdminEraseUser(w http.ResponseWriter, r *http.Request) {
+	userID := pathID(r, "userID")
+	if userID == requestClaims(r).UserID {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Use DELETE /api/v1/me to delete your own account")
+		return
+	}
+	if err := a.privacy.Erase(r.Context(), userID); err != nil {
+		a.writeAdminUserError(w, err)
+		return
+	}
+	a.audit(r, "admin_user_erased", userID, nil)
+	produceEvent("user_erased", map[string]interface{}{"user_id": userID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// audit publishes what an admin did to a user, together with who did it,
+// and adds it to the audit trail of the user
+func (a *App) audit(r *http.Request, event string, userID int, fields map[string]interface{}) {
+	claims := requestClaims(r)
+	actorID := claims.UserID
+	a.record(r.Context(), &repository.UserEvent{UserID: userID, ActorID: &actorID, Event: event, Details: fields})
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["actor_id"] = claims.UserID
+	fields["actor"] = claims.Username
+	fields["user_id"] = userID
+	log.Printf("audit: %s user %d by %s (%d)", event, userID, claims.Username, claims.UserID)
+	produceEvent(event, fields)
+}
+
+func (a *App) writeAdminUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, access.ErrInvalidArgument):
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+	case errors.Is(err, repository.ErrUserNotFound):
+		writeError(w, http.StatusNotFound, codeNotFound, "User not found")
+	case errors.Is(err, repository.ErrRoleNotFound):
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, err.Error())
+	case errors.Is(err, repository.ErrUserExists):
+		writeError(w, http.StatusConflict, codeConflict, err.Error())
+	default:
+		a.writeUserError(w, err)
+	}
+}
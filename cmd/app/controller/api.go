@@ -0,0 +1,548 @@
+// JSON API for the SPA and mobile clients, the same rules as the HTML forms
+package controller
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/controller/helper"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/cmd/internal/kafka"
+	"AuthDB/internal/access"
+	"AuthDB/internal/openapi"
+	"AuthDB/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Error codes of the envelope {"error": {"code": ..., "message": ...}}
+const (
+	codeInvalidArgument  = "invalid_argument"
+	codeUnauthenticated  = "unauthenticated"
+	codePermissionDenied = "permission_denied"
+	codeNotFound         = "not_found"
+	codeConflict         = "conflict"
+	codeNotAcceptable    = "not_acceptable"
+	codeUnsupportedMedia = "unsupported_media_type"
+	codeInternal         = "internal"
+	// If-Match names an older version of the user
+	codeFailedPrecondition = "failed_precondition"
+)
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type errorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, errorEnvelope{Error: apiError{Code: code, Message: message}})
+}
+
+// the code that goes with a status when the handler has no better one
+func statusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return codeInvalidArgument
+	case http.StatusUnauthorized:
+		return codeUnauthenticated
+	case http.StatusForbidden:
+		return codePermissionDenied
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusConflict:
+		return codeConflict
+	}
+	return codeInternal
+}
+
+// UserResponse is a user as the API shows it, without the password hash
+type UserResponse struct {
+	ID        int        `json:"id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	Roles     []string   `json:"roles"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	Version   int        `json:"version"`
+}
+
+func userResponse(u *repository.User) UserResponse {
+	roles := u.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+	return UserResponse{ID: u.ID, Username: u.Username, Email: u.Email, Roles: roles, CreatedAt: u.CreatedAt, Version: u.Version}
+}
+
+func (a *App) APIRoutes(r *mux.Router) {
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(negotiate, validateAPI)
+
+	api.HandleFunc("/signup", a.APISignup).Methods("POST")
+	api.HandleFunc("/login", a.APILogin).Methods("POST")
+	api.HandleFunc("/logout", a.apiAuthorized(a.APILogout)).Methods("POST")
+
+	api.HandleFunc("/me", a.apiAuthorized(a.Me)).Methods("GET")
+	api.HandleFunc("/me", a.apiAuthorized(a.permitted("profile", "update", a.UpdateProfile))).Methods("PATCH")
+	api.HandleFunc("/me/password", a.apiAuthorized(a.permitted("profile", "update", a.ChangePassword))).Methods("PUT")
+	api.HandleFunc("/me", a.apiAuthorized(a.permitted("profile", "delete", a.APIDeleteAccount))).Methods("DELETE")
+	api.HandleFunc("/me/export", a.apiAuthorized(a.permitted("profile", "read", a.ExportAccount))).Methods("GET")
+}
+
+// negotiate makes sure the client speaks JSON:
+// 406 when Accept rules JSON out, 415 when a body is not JSON
+func negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsJSON(r.Header.Get("Accept")) {
+			writeError(w, http.StatusNotAcceptable, codeNotAcceptable, "only application/json is available")
+			return
+		}
+		if r.ContentLength != 0 && r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "the body must be application/json")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateAPI checks requests and responses against internal/openapi/openapi.yaml
+func validateAPI(next http.Handler) http.Handler {
+	return openapi.Default().Middleware(func(w http.ResponseWriter, status int, message string) {
+		writeError(w, status, statusCode(status), message)
+	})(next)
+}
+
+func acceptsJSON(accept string) bool {
+	if accept == "" {
+		return true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			return true
+		}
+	}
+	return false
+}
+
+// decode reads the JSON body into v, unknown fields are rejected
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "invalid JSON body")
+		return false
+	}
+	return true
+}
+
+type claimsKey struct{}
+
+// apiAuthorized is authorized for the API: the session comes from the bearer token
+// (or the cookie of a browser SPA) and failures are 401 JSON instead of a redirect
+func (a *App) apiAuthorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := requestToken(r)
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, codeUnauthenticated, "missing bearer token")
+			return
+		}
+		claims, err := a.auth.Validate(token)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, codeUnauthenticated, "Invalid token")
+			return
+		}
+		next(w, withClaims(r, claims))
+	}
+}
+
+// withClaims puts the claims into the request. The user is the session of the reads too,
+// after its writes it reads them from the primary (repository.WithSession).
+func withClaims(r *http.Request, claims *auth.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsKey{}, claims)
+	return r.WithContext(repository.WithSession(ctx, access.Principal{UserID: claims.UserID}.String()))
+}
+
+// requestToken takes the bearer token, else the session cookie
+func requestToken(r *http.Request) string {
+	return access.SessionToken(r.Header.Get("Authorization"), r.Header.Get("Cookie"))
+}
+
+func requestClaims(r *http.Request) *auth.Claims {
+	claims, _ := r.Context().Value(claimsKey{}).(*auth.Claims)
+	return claims
+}
+
+type signupRequest struct {
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	Repassword string `json:"repassword"`
+}
+
+func (a *App) APISignup(w http.ResponseWriter, r *http.Request) {
+	var req signupRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	username := strings.TrimSpace(req.Username)
+	email := strings.TrimSpace(req.Email)
+	password := strings.TrimSpace(req.Password)
+	repassword := strings.TrimSpace(req.Repassword)
+	// API clients may skip the confirmation
+	if repassword == "" {
+		repassword = password
+	}
+
+	if msg := validateSignup(username, email, password, repassword); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, msg)
+		return
+	}
+
+	exist, err := a.store.UserExists(r.Context(), username, email)
+	if err != nil {
+		log.Printf("Error checking existing user: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Error checking existing user")
+		return
+	}
+	if exist {
+		writeError(w, http.StatusConflict, codeConflict, "username or email is already taken")
+		return
+	}
+
+	user, err := repository.NewUser(username, email, password)
+	if err == nil {
+		err = a.createUser(r.Context(), user)
+	}
+	// another signup took the name since UserExists
+	if errors.Is(err, repository.ErrUserExists) {
+		writeError(w, http.StatusConflict, codeConflict, "username or email is already taken")
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding user: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Something went wrong, please try later")
+		return
+	}
+
+	produceEvent("signup", map[string]interface{}{"user_id": user.ID})
+	writeJSON(w, http.StatusCreated, userResponse(user))
+}
+
+type loginRequest struct {
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	RememberMe bool   `json:"remember_me"`
+}
+
+type loginResponse struct {
+	AccessToken string       `json:"access_token"`
+	TokenType   string       `json:"token_type"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	User        UserResponse `json:"user"`
+}
+
+func (a *App) APILogin(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "You must provide a username and password")
+		return
+	}
+
+	session, err := a.auth.Login(r.Context(), req.Username, req.Password, req.RememberMe)
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound), errors.Is(err, auth.ErrInvalidCredentials):
+		// don't tell the client which of the two was wrong
+		writeError(w, http.StatusUnauthorized, codeUnauthenticated, "invalid username or password")
+		return
+	case errors.Is(err, auth.ErrAccountLocked):
+		writeError(w, http.StatusTooManyRequests, codePermissionDenied, "Too many failed attempts, try again later")
+		return
+	case errors.Is(err, auth.ErrAccountDisabled):
+		writeError(w, http.StatusForbidden, codePermissionDenied, "This account is disabled")
+		return
+	case errors.Is(err, auth.ErrAccountDeleted):
+		writeError(w, http.StatusForbidden, codePermissionDenied, "This account is deleted")
+		return
+	case err != nil:
+		log.Printf("Error logging in: %v", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Something went wrong, please try later")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, loginResponse{
+		AccessToken: session.Token,
+		TokenType:   "Bearer",
+		ExpiresAt:   session.ExpiresAt,
+		User:        userResponse(session.User),
+	})
+}
+
+func (a *App) APILogout(w http.ResponseWriter, r *http.Request) {
+	a.auth.Logout(requestToken(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) Me(w http.ResponseWriter, r *http.Request) {
+	user, err := a.store.GetUser(r.Context(), requestClaims(r).UserID)
+	if err != nil {
+		a.writeUserError(w, err)
+		return
+	}
+	setETag(w, &user)
+	writeJSON(w, http.StatusOK, userResponse(&user))
+}
+
+// the ETag of a user is its version, a PATCH with If-Match fails once someone else changed the user
+func setETag(w http.ResponseWriter, u *repository.User) {
+	w.Header().Set("ETag", `"`+strconv.Itoa(u.Version)+`"`)
+}
+
+// ifMatch is the version in If-Match, 0 without the header or for *.
+// false if it is no ETag of ours, the request can't match then
+func ifMatch(r *http.Request) (int, bool) {
+	v := strings.TrimSpace(r.Header.Get("If-Match"))
+	if v == "" || v == "*" {
+		return 0, true
+	}
+	if len(v) < 3 || v[0] != '"' || v[len(v)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.Atoi(v[1 : len(v)-1])
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+// checkIfMatch writes 412 unless If-Match matches the user, read it repository.OnPrimary
+func checkIfMatch(w http.ResponseWriter, r *http.Request, u *repository.User) (int, bool) {
+	version, ok := ifMatch(r)
+	if !ok || (version != 0 && version != u.Version) {
+		writeError(w, http.StatusPreconditionFailed, codeFailedPrecondition, repository.ErrVersionConflict.Error())
+		return 0, false
+	}
+	return version, true
+}
+
+// omitted fields stay as they are
+type updateProfileRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+}
+
+func (a *App) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var req updateProfileRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	// If-Match is checked against this read, a replica behind would answer 412
+	ctx := repository.OnPrimary(r.Context())
+	user, err := a.store.GetUser(ctx, requestClaims(r).UserID)
+	if err != nil {
+		a.writeUserError(w, err)
+		return
+	}
+	version, ok := checkIfMatch(w, r, &user)
+	if !ok {
+		return
+	}
+
+	patch := repository.UserPatch{Version: version}
+	changes := make(map[string]interface{})
+	if req.Username != nil && strings.TrimSpace(*req.Username) != user.Username {
+		username := strings.TrimSpace(*req.Username)
+		if msg := helper.ValidateUsername(username); msg != "" {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, msg)
+			return
+		}
+		patch.Username = &username
+		changes["new_username"] = username
+	}
+	if req.Email != nil && strings.TrimSpace(*req.Email) != user.Email {
+		email := strings.TrimSpace(*req.Email)
+		if msg := helper.ValidateEmail(email); msg != "" {
+			writeError(w, http.StatusBadRequest, codeInvalidArgument, msg)
+			return
+		}
+		patch.Email = &email
+		changes["email_changed"] = true
+	}
+
+	if !patch.Empty() {
+		if user, err = a.store.UpdateUser(ctx, user.ID, patch); err != nil {
+			a.writeUserError(w, err)
+			return
+		}
+		changes["user_id"] = user.ID
+		produceEvent("update_data", changes)
+	}
+	setETag(w, &user)
+	writeJSON(w, http.StatusOK, userResponse(&user))
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+func (a *App) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	ctx := r.Context()
+	user, err := a.store.GetUser(ctx, requestClaims(r).UserID)
+	if err != nil {
+		a.writeUserError(w, err)
+		return
+	}
+
+	if !utils.CompareHashPassword(req.CurrentPassword, user.Password) {
+		writeError(w, http.StatusForbidden, codePermissionDenied, "Incorrect password")
+		return
+	}
+	newPassword := strings.TrimSpace(req.NewPassword)
+	if msg := helper.ValidatePassword(newPassword); msg != "" {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, msg)
+		return
+	}
+	if newPassword == req.CurrentPassword {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "Passwords need to be different")
+		return
+	}
+
+	hash, err := utils.GenerateHash(newPassword)
+	if err != nil {
+		a.writeUserError(w, err)
+		return
+	}
+	if _, err := a.store.UpdateUser(ctx, user.ID, repository.UserPatch{Password: &hash}); err != nil {
+		a.writeUserError(w, err)
+		return
+	}
+	produceEvent("update_password", map[string]interface{}{"user_id": user.ID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIDeleteAccount schedules the deletion, logging in during the grace period keeps the account
+func (a *App) APIDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := requestClaims(r).UserID
+	if err := a.store.RequestDeletion(r.Context(), userID, userID); err != nil {
+		a.writeUserError(w, err)
+		return
+	}
+	// close every session of the deleted user
+	a.auth.LogoutUser(userID)
+	produceEvent("delete_account", map[string]interface{}{"deleteduser_id": userID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExportAccount sends everything kept about the user, ?format=zip as a ZIP archive
+func (a *App) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	userID := requestClaims(r).UserID
+	if err := a.writeExport(w, r, userID); err != nil {
+		a.writeUserError(w, err)
+		return
+	}
+	produceEvent("data_exported", map[string]interface{}{"user_id": userID})
+	a.record(r.Context(), &repository.UserEvent{UserID: userID, Event: "data_exported"})
+}
+
+// writeExport answers with the export of the user, nothing is written when it fails
+func (a *App) writeExport(w http.ResponseWriter, r *http.Request, userID int) error {
+	export, err := a.privacy.Export(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if r.URL.Query().Get("format") != "zip" {
+		writeJSON(w, http.StatusOK, export)
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := export.WriteZip(&buf); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.zip"`, userID))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// txStore runs a transaction the other stores called with its ctx take part in, PostgresUserStore does
+type txStore interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error, opts ...repository.TxOption) error
+}
+
+// createUser adds the user and its signup event together, in a transaction of the store
+// if it has them (the memory one of the tests doesn't)
+func (a *App) createUser(ctx context.Context, user *repository.User) error {
+	create := func(ctx context.Context) error {
+		if err := a.store.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		return a.events.RecordEvent(ctx, &repository.UserEvent{UserID: user.ID, Event: "signup"})
+	}
+	if s, ok := a.store.(txStore); ok {
+		return s.WithTx(ctx, create)
+	}
+	return create(ctx)
+}
+
+// record adds the event to the history of the user, failures are only logged like the Kafka ones
+func (a *App) record(ctx context.Context, e *repository.UserEvent) {
+	if err := a.events.RecordEvent(ctx, e); err != nil {
+		log.Printf("Failed to record %s of user %d: %v", e.Event, e.UserID, err)
+	}
+}
+
+func (a *App) writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		writeError(w, http.StatusNotFound, codeNotFound, "User not found")
+		return
+	case errors.Is(err, repository.ErrUsernameTaken):
+		writeError(w, http.StatusConflict, codeConflict, "This username already exists")
+		return
+	case errors.Is(err, repository.ErrEmailTaken):
+		writeError(w, http.StatusConflict, codeConflict, "This email already exists")
+		return
+	case errors.Is(err, repository.ErrNotPendingDeletion):
+		writeError(w, http.StatusConflict, codeConflict, err.Error())
+		return
+	case errors.Is(err, repository.ErrVersionConflict):
+		writeError(w, http.StatusPreconditionFailed, codeFailedPrecondition, err.Error())
+		return
+	}
+	log.Printf("Error handling user request: %v", err)
+	writeError(w, http.StatusInternalServerError, codeInternal, "Something went wrong, please try later")
+}
+
+func produceEvent(event string, fields map[string]interface{}) {
+	if err := kafka.ProduceEvent(event, fields); err != nil {
+		log.Println("Failed to produce Kafka message:", err)
+	}
+}
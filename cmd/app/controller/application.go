@@ -3,17 +3,21 @@
 package controller
 
 import (
+	"AuthDB/cmd/app/auth"
 	"AuthDB/cmd/app/controller/helper"
 	"AuthDB/cmd/app/repository"
 	"AuthDB/cmd/internal/kafka"
+	"AuthDB/internal/access"
+	"AuthDB/internal/openapi"
 	"AuthDB/utils"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -23,13 +27,38 @@ import (
 type App struct {
 	ctx     context.Context
 	repo    *repository.Repository
-	cache   map[string]*repository.User
-	cacheMu sync.Mutex
+	store   repository.UserStore
+	auth    *auth.Service
+	checker *access.Checker
+	roles   *access.RoleAdmin
+	users   *access.UserAdmin
+	gate    *access.Gate
+	events  repository.EventLog
+	privacy *access.Privacy
+
+	// hosts besides our own the login may redirect back to
+	redirectHosts []string
 }
 
-func NewApp(ctx context.Context, dbpool *pgxpool.Pool) *App {
-	return &App{ctx: ctx, repo: repository.NewRepository(dbpool),
-		cache: make(map[string]*repository.User)}
+// users keeps the accounts, events their login history and audit trail,
+// gate authorizes the requests nginx forwards to /auth/verify
+func NewApp(ctx context.Context, dbpool *pgxpool.Pool, users repository.UserStore, events repository.EventLog,
+	authService *auth.Service, checker *access.Checker, roleAdmin *access.RoleAdmin, gate *access.Gate) *App {
+	repo := repository.NewRepository(dbpool)
+	// the tests run without a database and without attributes
+	var attributes access.Attributes
+	if dbpool != nil {
+		attributes = repo
+	}
+	return &App{ctx: ctx, repo: repo, store: users, auth: authService,
+		checker: checker, roles: roleAdmin, users: access.NewUserAdmin(users, roleAdmin), gate: gate,
+		events: events, privacy: access.NewPrivacy(users, attributes, events, authService)}
+}
+
+// AllowRedirectHosts lets the login send the user back to the apps protected by nginx,
+// without it only local paths are followed
+func (a *App) AllowRedirectHosts(hosts ...string) {
+	a.redirectHosts = hosts
 }
 
 var (
@@ -48,96 +77,84 @@ func (a *App) Routes(r *mux.Router) {
 
 	r.HandleFunc("/login", a.wrapHandler(a.Login)).Methods("POST")
 	r.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
-		a.LoginPage(w, "")
+		a.LoginPageWithNext(w, "", r.URL.Query().Get("next"))
 	}).Methods("GET")
 
-	r.HandleFunc("/delete", a.wrapHandler(a.authorized(a.DeleteAccount))).Methods("POST")
+	// nginx auth_request, any method because nginx sends the subrequest with the original one
+	r.HandleFunc("/auth/verify", a.VerifyAuth)
+	r.HandleFunc("/auth/login", a.LoginRedirect)
+
+	r.HandleFunc("/delete", a.wrapHandler(a.authorized(a.permitted("profile", "delete", a.DeleteAccount)))).Methods("POST")
 	r.HandleFunc("/delete", a.wrapHandler(a.authorized(func(w http.ResponseWriter, r *http.Request) {
 		a.RenderDeleteConfirmationPage(w)
 	}))).Methods("GET")
 
-	r.HandleFunc("/update", a.wrapHandler(a.authorized(a.UpdateData))).Methods("POST")
+	r.HandleFunc("/update", a.wrapHandler(a.authorized(a.permitted("profile", "update", a.UpdateData)))).Methods("POST")
 	r.HandleFunc("/update", a.wrapHandler(a.authorized(func(w http.ResponseWriter, r *http.Request) {
 		a.UpdateUserPage(w, "")
 	}))).Methods("GET")
 
 	r.HandleFunc("/logout", a.wrapHandler((a.authorized(a.Logout)))).Methods("GET")
 
-	r.HandleFunc("/users", a.wrapHandler(a.authorized(GetAllUsers))).Methods("GET")
+	r.HandleFunc("/users", a.wrapHandler(a.authorized(a.permitted("users", "read", a.UsersPage)))).Methods("GET")
+
+	// the document every route must be described in
+	r.Handle("/openapi.json", openapi.Default()).Methods("GET")
+
+	a.RoleRoutes(r)
+	a.APIRoutes(r)
 }
 
 func (a *App) Login(w http.ResponseWriter, r *http.Request) {
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	// where the user wanted to go before being sent to login
+	next := r.FormValue("next")
 
 	if username == "" || password == "" {
-		a.LoginPage(w, "You must provide a username and password")
+		a.LoginPageWithNext(w, "You must provide a username and password", next)
 		return
 	}
 
-	user, err := a.repo.Login(a.ctx, nil, username)
-	if err != nil {
-		log.Printf("Error querying user: %v", err)
+	// creating cookies with check button remember me
+	// if true, the session will be kept for 15 days
+	// else 1 hour
+	rememberMe := r.FormValue("remember_me") == "on"
+
+	// the same code path is used by the gRPC Authenticate
+	session, err := a.auth.Login(a.ctx, username, password, rememberMe)
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		a.LoginPageWithNext(w, "User not found", next)
 		return
-	}
-	if user == nil{
-		a.LoginPage(w, "User not found")
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		a.LoginPageWithNext(w, "Incorrect password", next)
 		return
-	}
-
-	// Compare user password and login password using byte
-	if !utils.CompareHashPassword(password, user.Password) {
-		a.LoginPage(w, "Incorrect password")
+	case errors.Is(err, auth.ErrAccountLocked):
+		a.LoginPageWithNext(w, "Too many failed attempts, try again later", next)
 		return
-	}
-
-	// Generate JWT-token with user username
-	token, err := utils.GenerateJWT(user.Username)
-	if err != nil {
-		log.Fatalf("Error generate token: %v", err)
+	case errors.Is(err, auth.ErrAccountDisabled):
+		a.LoginPageWithNext(w, "This account is disabled", next)
+		return
+	case errors.Is(err, auth.ErrAccountDeleted):
+		a.LoginPageWithNext(w, "This account is deleted", next)
+		return
+	case err != nil:
+		log.Printf("Error logging in: %v", err)
+		http.Error(w, "Something went wrong, please try later", http.StatusInternalServerError)
 		return
 	}
 
-	// to protect access to hash
-	a.cacheMu.Lock()
-	a.cache[token] = user
-	a.cacheMu.Unlock()
-
-	// creating cookies with check button remember me
-	rememberMe := r.FormValue("remember_me") == "on"
-	var livingTime time.Duration
-	// if true, the cookie will be kept for 15 days
-	// else 1 hour
-	if rememberMe {
-		livingTime = 24 * time.Hour * 15
-	} else {
-		livingTime = 1 * time.Hour
-	}
-	// remember livingTime
-	expiration := time.Now().Add(livingTime)
 	// Create cookie
 	cookie := http.Cookie{
 		Name:     "token",
-		Value:    token,
-		Expires:  expiration,
+		Value:    session.Token,
+		Expires:  session.ExpiresAt,
 		Secure:   false,
 		HttpOnly: true,
 	}
 	http.SetCookie(w, &cookie)
-	// Creating kafka message
-	message := kafka.Message{
-		Value: []byte(fmt.Sprintf(`{
-		"event": "login",
-		"user_id": "%d",
-		"email": "%s",
-		"timestamp": "%s"
-		}`, user.ID, user.Email, time.Now().UTC().Format(time.RFC3339))),
-	}
-	// The producer writes the Kafka message to the Kafka cluster
-	if err := kafka.ProduceMessage(kafka.Brokers, kafka.Topic, string(message.Value)); err != nil {
-		log.Println("Failed to produce Kafka message:", err)
-	}
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, helper.SafeRedirect(next, a.redirectHosts), http.StatusSeeOther)
 }
 
 func (a *App) Signup(w http.ResponseWriter, r *http.Request) {
@@ -147,27 +164,12 @@ func (a *App) Signup(w http.ResponseWriter, r *http.Request) {
 	password := strings.TrimSpace(r.FormValue("password"))
 	repassword := strings.TrimSpace(r.FormValue("repassword"))
 
-	if username == "" || email == "" || password == "" || repassword == "" {
-		a.SignupPage(w, "Not all fields are filled in")
-		return
-	}
-
-	if password != repassword {
-		a.SignupPage(w, "Password mismatch")
+	if msg := validateSignup(username, email, password, repassword); msg != "" {
+		a.SignupPage(w, msg)
 		return
 	}
 
-	if !helper.IsValidPassword(password) {
-		a.SignupPage(w, "The password should not contain only numbers or letters")
-		return
-	}
-
-	if len(username) <= 4 {
-		a.SignupPage(w, "Minimum username length - 4 characters")
-		return
-	}
-
-	userExist, err := a.repo.UserExist(a.ctx, nil, username, email)
+	userExist, err := a.store.UserExists(a.ctx, username, email)
 	if err != nil {
 		a.SignupPage(w, "Error checking existing user")
 		return
@@ -183,16 +185,17 @@ func (a *App) Signup(w http.ResponseWriter, r *http.Request) {
 	errCh := make(chan error)
 	go func() {
 		defer close(errCh)
-		user, err := repository.NewUser(username, email, password)
+		u, err := repository.NewUser(username, email, password)
 		if err != nil {
 			errCh <- err
 			return
 		}
-		err = user.Add(a.ctx, nil)
+		err = a.createUser(a.ctx, u)
 		if err != nil {
 			errCh <- err
 			return
 		}
+		user = *u
 		errCh <- nil
 	}()
 	// read from channel
@@ -207,9 +210,8 @@ func (a *App) Signup(w http.ResponseWriter, r *http.Request) {
 		Value: []byte(fmt.Sprintf(`{
 			"event": "signup",
 			"user_id": "%d",
-			"email": "%s",
 			"timestamp": "%s"
-		}`, user.ID, user.Email, time.Now().UTC().Format(time.RFC3339))),
+		}`, user.ID, time.Now().UTC().Format(time.RFC3339))),
 	}
 
 	// The producer writes the Kafka message to the Kafka cluster
@@ -219,9 +221,12 @@ func (a *App) Signup(w http.ResponseWriter, r *http.Request) {
 	a.LoginPage(w, fmt.Sprintln("Successful signup!"))
 }
 
-// A simple function to delete a user's cookie
+// Closes the session and deletes the user's cookie
 // To log him out
 func (a *App) Logout(w http.ResponseWriter, r *http.Request) {
+	if token, err := ReadCookie("token", r); err == nil {
+		a.auth.Logout(token)
+	}
 	for _, v := range r.Cookies() {
 		c := http.Cookie{
 			Name:   v.Name,
@@ -264,23 +269,58 @@ func (a *App) authorized(next http.HandlerFunc) http.HandlerFunc {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		// read token
-		// lock access to the cache while we work with it
-		a.cacheMu.Lock()
-		_, ok := a.cache[token]
-		a.cacheMu.Unlock()
-		// if ok == false (token not found)
+		// if the session is not found or the token is not valid
 		// redirect to login
-		if !ok {
+		claims, err := a.auth.Validate(token)
+		if err != nil {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 		// if ok == true (token found)
-		// continue processing the request
+		// continue processing the request, requestClaims returns the claims
+		next(w, withClaims(r, claims))
+	}
+}
+
+// check that the logged in user has the permission,
+// must be wrapped in authorized or apiAuthorized.
+// The route variables are passed to the policies as resource attributes,
+// a profile without {owner_id} is the user's own.
+func (a *App) permitted(resource, action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := a.auth.Validate(requestToken(r))
+		if err != nil {
+			denyRequest(w, r, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+
+		attributes := make(map[string]string)
+		for k, v := range mux.Vars(r) {
+			attributes[k] = v
+		}
+		if _, ok := attributes["owner_id"]; !ok && resource == "profile" {
+			attributes["owner_id"] = strconv.Itoa(claims.UserID)
+		}
+
+		principal := access.Principal{UserID: claims.UserID, Username: claims.Username, Roles: claims.Roles}
+		check := access.Check{Resource: resource, Action: action, Attributes: attributes}
+		if d := a.checker.CheckAll(r.Context(), principal, []access.Check{check})[0]; !d.Allowed {
+			denyRequest(w, r, http.StatusForbidden, d.Reason)
+			return
+		}
 		next(w, r)
 	}
 }
 
+// JSON for the API, plain text for the pages
+func denyRequest(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeError(w, status, statusCode(status), message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
 // func (a *App) isAuthorized(r *http.Request) bool {
 // 	token, err := ReadCookie("token", r)
 // 	if err != nil {
@@ -303,23 +343,24 @@ func (a *App) DeleteAccount(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
-	// cache lookup
-	user, ok := a.cache[token]
+	// session lookup
+	session, ok := a.auth.Session(token)
 	// if not found redirect to login
 	if !ok {
-		log.Printf("Token not found in cache")
+		log.Printf("Session not found")
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
-	// if found delete user by id
-	err = a.repo.DeleteUserByID(a.ctx, user.ID)
+	user := session.User
+	// if found the account is deleted after the grace period, logging in again keeps it
+	err = a.store.RequestDeletion(a.ctx, user.ID, user.ID)
 	if err != nil {
 		log.Printf("Error deleting user by ID: %v", err)
 		http.Error(w, "Something went wrong, please try later", http.StatusInternalServerError)
 		return
 	}
-	// delete token from cache (map)
-	delete(a.cache, token)
+	// close every session of the deleted user
+	a.auth.LogoutUser(user.ID)
 
 	// delete cookie to logout
 	for _, v := range r.Cookies() {
@@ -345,178 +386,60 @@ func (a *App) DeleteAccount(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
-// The following 3 functions are the same
-// only they update different data and queries to the database
-// also kafka messages are created
-func (a *App) UpdateUsername(w http.ResponseWriter, oldusername, newusername string) error {
-	user, err := a.repo.FindUserByLogin(a.ctx, oldusername)
-	if err != nil {
-		a.UpdateUserPage(w, "User not found")
-		return err
-	}
-	// old and new username must not be the same
-	if user.Username != newusername {
-		// set a new username if they do not match
-		query := `UPDATE users SET username = $1 WHERE username = $2`
-		err = a.repo.UpdateData(a.ctx, query, newusername, oldusername)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return err
-		}
-		return nil
-	}
-	// create kafka message
-	message := kafka.Message{
-		Value: []byte(fmt.Sprintf(`{
-			"event": "update_password",
-			"user_id": "%d",
-			"newusername": "%s",
-			"timestamp": "%s"
-		}`, user.ID, newusername, time.Now().UTC().Format(time.RFC3339))),
-	}
-
-	// The producer writes the Kafka message to the Kafka cluster
-	if err := kafka.ProduceMessage(kafka.Brokers, kafka.Topic, string(message.Value)); err != nil {
-		log.Println("Failed to produce Kafka message:", err)
-	}
-
-	a.UpdateUserPage(w, "This username already exists")
-	return fmt.Errorf("username already exists")
-}
-
-func (a *App) UpdateEmail(w http.ResponseWriter, oldEmail, newEmail string) error {
-	user, err := a.repo.FindUserByEmail(a.ctx, oldEmail)
-	if err != nil {
-		a.UpdateUserPage(w, "User not found")
-		return err
-	}
-	// old and new email must not be the same
-	if user.Email != newEmail {
-		// set new email
-		query := `UPDATE users SET email = $1 WHERE email = $2`
-		err = a.repo.UpdateData(a.ctx, query, newEmail, oldEmail)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return err
-		}
-		return nil
-	}
-	a.UpdateUserPage(w, "This email already exists")
-	// create kafka message
-	message := kafka.Message{
-		Value: []byte(fmt.Sprintf(`{
-			"event": "update_password",
-			"user_id": "%d",
-			"newemail": "%s",
-			"timestamp": "%s"
-		}`, user.ID, newEmail, time.Now().UTC().Format(time.RFC3339))),
-	}
-
-	// The producer writes the Kafka message to the Kafka cluster
-	if err := kafka.ProduceMessage(kafka.Brokers, kafka.Topic, string(message.Value)); err != nil {
-		log.Println("Failed to produce Kafka message:", err)
-	}
-	return fmt.Errorf("email already exists")
-}
+// UpdateData changes the username, email or password of the logged in user,
+// the fields left empty stay as they are
+func (a *App) UpdateData(w http.ResponseWriter, r *http.Request) {
+	userID := requestClaims(r).UserID
+	var patch repository.UserPatch
+	changes := map[string]interface{}{"user_id": userID}
 
-func (a *App) UpdatePassword(w http.ResponseWriter, r *http.Request, newPassword string) error {
-	userHashedPass := repository.HashPassword
-	if len(newPassword) <= 3 {
-		a.UpdateUserPage(w, "Minimum field length - 4 characters")
-	}
-	// user search by old hashed password
-	user, err := a.repo.FindUserByPassword(a.ctx, userHashedPass)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return err
-	}
-	// generate new hashed password
-	hashedNewPassword, err := utils.GenerateHash(newPassword)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return err
-	}
-	// old password (not hashed) and new password must not be the same
-	if user.Password != newPassword {
-		// set net password
-		query := `UPDATE users SET password = $1 WHERE password = $2`
-		err = a.repo.UpdateData(a.ctx, query, hashedNewPassword, userHashedPass)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return err
+	if username := strings.TrimSpace(r.FormValue("newUsername")); username != "" {
+		if msg := helper.ValidateUsername(username); msg != "" {
+			a.UpdateUserPage(w, msg)
+			return
 		}
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		patch.Username = &username
+		changes["new_username"] = username
 	}
-	a.UpdateUserPage(w, "Passwords need to be different")
-	user.Password = hashedNewPassword
-	// create kafka message
-	message := kafka.Message{
-		Value: []byte(fmt.Sprintf(`{
-			"event": "update_password",
-			"user_id": "%d",
-			"newpassword": "%s",
-			"timestamp": "%s"
-		}`, user.ID, newPassword, time.Now().UTC().Format(time.RFC3339))),
-	}
-
-	// The producer writes the Kafka message to the Kafka cluster
-	if err := kafka.ProduceMessage(kafka.Brokers, kafka.Topic, string(message.Value)); err != nil {
-		log.Println("Failed to produce Kafka message:", err)
-	}
-	return err
-}
-
-func (a *App) UpdateData(w http.ResponseWriter, r *http.Request) {
-	user := repository.User{}
-	// read lines
-	oldUsername := r.FormValue("oldUsername")
-	newUsername := r.FormValue("newUsername")
-	oldEmail := r.FormValue("oldEmail")
-	newEmail := r.FormValue("newEmail")
-	newPassword := r.FormValue("newPassword")
-
-	// will work a case where the string != ""
-	// then update data
-	if oldUsername != "" && newUsername != "" {
-		err := a.UpdateUsername(w, oldUsername, newUsername)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	if email := strings.TrimSpace(r.FormValue("newEmail")); email != "" {
+		if msg := helper.ValidateEmail(email); msg != "" {
+			a.UpdateUserPage(w, msg)
 			return
 		}
-	} else if oldEmail != "" && newEmail != "" {
-		err := a.UpdateEmail(w, oldEmail, newEmail)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		patch.Email = &email
+		changes["email_changed"] = true
+	}
+	if password := strings.TrimSpace(r.FormValue("newPassword")); password != "" {
+		if msg := helper.ValidatePassword(password); msg != "" {
+			a.UpdateUserPage(w, msg)
 			return
 		}
-	} else if newPassword != "" {
-		err := a.UpdatePassword(w, r, newPassword)
+		hash, err := utils.GenerateHash(password)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-	} else {
+		patch.Password = &hash
+		changes["password_changed"] = true
+	}
+	if patch.Empty() {
 		http.Error(w, "No valid update data provided", http.StatusBadRequest)
 		return
 	}
-	// create kafka message
-	message := kafka.Message{
-		Value: []byte(fmt.Sprintf(`{
-			"event": "update_data",
-			"user_id": "%d",
-			"old_username": "%s",
-			"new_username": "%s",
-			"new_password": "%s"
-			"old_email": "%s",
-			"new_email": "%s",
-			"timestamp": "%s"
-		}`, user.ID, oldUsername, newUsername, newPassword, oldEmail, newEmail, time.Now().UTC().Format(time.RFC3339))),
-	}
 
-	// The producer writes the Kafka message to the Kafka cluster
-	if err := kafka.ProduceMessage(kafka.Brokers, kafka.Topic, string(message.Value)); err != nil {
-		fmt.Println("Failed to produce Kafka message:", err)
+	if _, err := a.store.UpdateUser(r.Context(), userID, patch); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrUsernameTaken):
+			a.UpdateUserPage(w, "This username already exists")
+		case errors.Is(err, repository.ErrEmailTaken):
+			a.UpdateUserPage(w, "This email already exists")
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
 	}
+	produceEvent("update_data", changes)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 // func (a *App) authCallbackHandler(w http.ResponseWriter, r *http.Request) {
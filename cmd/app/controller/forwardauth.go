@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"AuthDB/internal/access"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// VerifyAuth is the nginx auth_request target.
+// nginx passes the original request in X-Original-Method / X-Original-Path, the path
+// as nginx routes it (decoded and normalised), other proxies may send X-Original-URI instead.
+// The session comes from the bearer token or the "token" cookie.
+// 200 carries X-Auth-User / X-Auth-Roles for the upstream, 401 sends the user to login
+// (see nginx/nginx.conf), 403 means logged in but not permitted.
+func (a *App) VerifyAuth(w http.ResponseWriter, r *http.Request) {
+	method := r.Header.Get("X-Original-Method")
+	if method == "" {
+		method = r.Method
+	}
+	uri := r.Header.Get("X-Original-URI")
+	if path := r.Header.Get("X-Original-Path"); path != "" {
+		// escaped again, a decoded ? is part of the path and not the query
+		uri = (&url.URL{Path: path}).EscapedPath()
+	}
+	if uri == "" {
+		uri = "/"
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+
+	d := a.gate.Authorize(r.Context(), access.ProxyRequest{
+		Method: method,
+		Path:   uri,
+		Host:   host,
+		Token:  access.SessionToken(r.Header.Get("Authorization"), r.Header.Get("Cookie")),
+	})
+	// the subrequest response must never be cached by nginx or the browser
+	w.Header().Set("Cache-Control", "no-store")
+	if !d.Allowed() {
+		if d.Status == http.StatusForbidden {
+			log.Printf("auth_request: %s %s denied: %s", method, uri, d.Reason)
+		}
+		w.WriteHeader(d.Status)
+		return
+	}
+	for k, v := range d.Headers() {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// LoginRedirect is where nginx sends a request that needs a login (@login in nginx/nginx.conf):
+// to the login page with the original X-Original-URI as next, escaped so its own query stays in it
+func (a *App) LoginRedirect(w http.ResponseWriter, r *http.Request) {
+	uri := r.Header.Get("X-Original-URI")
+	if uri == "" {
+		uri = "/"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, "/login?next="+url.QueryEscape(uri), http.StatusFound)
+}
@@ -0,0 +1,37 @@
+package helper
+
+import (
+	"net/url"
+	"strings"
+)
+
+// SafeRedirect returns the target when it is safe to redirect to after login:
+// a local path ("/orders?id=1") or an http(s) URL on one of the allowed hosts.
+// Anything else ("//evil.com", "javascript:...", other hosts) gives "/".
+func SafeRedirect(target string, allowedHosts []string) string {
+	if target == "" || strings.ContainsAny(target, "\\\r\n\t") {
+		return "/"
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "/"
+	}
+
+	// local path, but not a protocol relative URL like //evil.com
+	if u.Scheme == "" && u.Host == "" && u.User == nil {
+		if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+			return target
+		}
+		return "/"
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" || u.User != nil {
+		return "/"
+	}
+	for _, host := range allowedHosts {
+		if host != "" && strings.EqualFold(u.Hostname(), host) {
+			return target
+		}
+	}
+	return "/"
+}
@@ -0,0 +1,31 @@
+package helper
+
+import "net/mail"
+
+// Rules shared by the HTML forms, the JSON API and gRPC,
+// an empty result means the input is fine, otherwise it's the message for the user
+
+func ValidatePassword(password string) string {
+	if !IsValidPassword(password) {
+		return "The password should not contain only numbers or letters"
+	}
+	return ""
+}
+
+func ValidateUsername(username string) string {
+	if len(username) <= 4 {
+		return "Minimum username length - 4 characters"
+	}
+	return ""
+}
+
+func ValidateEmail(email string) string {
+	if email == "" {
+		return "Email must not be empty"
+	}
+	// the bare address only, not "Alice <alice@example.com>"
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return "Invalid email address"
+	}
+	return ""
+}
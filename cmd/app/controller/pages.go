@@ -27,6 +27,11 @@ func (a *App) SignupPage(w http.ResponseWriter, message string) {
 }
 
 func (a *App) LoginPage(w http.ResponseWriter, message string) {
+	a.LoginPageWithNext(w, message, "")
+}
+
+// next is where the user goes after logging in, see helper.SafeRedirect
+func (a *App) LoginPageWithNext(w http.ResponseWriter, message, next string) {
 	path := filepath.Join("public", "html", "login.html")
 	tmpl, err := template.ParseFiles(path)
 	if err != nil {
@@ -35,8 +40,9 @@ func (a *App) LoginPage(w http.ResponseWriter, message string) {
 	}
 	type answer struct {
 		Message string
+		Next    string
 	}
-	data := answer{Message: message}
+	data := answer{Message: message, Next: next}
 	err = tmpl.ExecuteTemplate(w, "login", data)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
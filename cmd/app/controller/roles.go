@@ -0,0 +1,187 @@
+// Admin JSON API for roles and permissions
+package controller
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (a *App) RoleRoutes(r *mux.Router) {
+	admin := r.PathPrefix("/api/v1/admin").Subrouter()
+	admin.Use(negotiate, validateAPI)
+	manage := func(h http.HandlerFunc) http.HandlerFunc {
+		return a.wrapHandler(a.apiAuthorized(a.permitted("roles", "manage", h)))
+	}
+
+	admin.HandleFunc("/roles", manage(a.ListRoles)).Methods("GET")
+	admin.HandleFunc("/roles", manage(a.CreateRole)).Methods("POST")
+	admin.HandleFunc("/roles/{roleID:[0-9]+}", manage(a.DeleteRole)).Methods("DELETE")
+	admin.HandleFunc("/roles/{roleID:[0-9]+}/parent", manage(a.SetRoleParent)).Methods("PUT")
+	admin.HandleFunc("/roles/{roleID:[0-9]+}/permissions", manage(a.RolePermissions)).Methods("GET")
+	admin.HandleFunc("/roles/{roleID:[0-9]+}/permissions/{permissionID:[0-9]+}", manage(a.GrantPermission)).Methods("POST")
+	admin.HandleFunc("/roles/{roleID:[0-9]+}/permissions/{permissionID:[0-9]+}", manage(a.RevokePermission)).Methods("DELETE")
+
+	admin.HandleFunc("/permissions", manage(a.ListPermissions)).Methods("GET")
+	admin.HandleFunc("/permissions", manage(a.CreatePermission)).Methods("POST")
+
+	admin.HandleFunc("/users/{userID:[0-9]+}/roles", manage(a.UserRoles)).Methods("GET")
+	admin.HandleFunc("/users/{userID:[0-9]+}/roles/{roleID:[0-9]+}", manage(a.AssignRole)).Methods("POST")
+	admin.HandleFunc("/users/{userID:[0-9]+}/roles/{roleID:[0-9]+}", manage(a.UnassignRole)).Methods("DELETE")
+
+	a.adminUserRoutes(admin)
+}
+
+func (a *App) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := a.roles.ListRoles(r.Context())
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, roles)
+}
+
+func (a *App) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var role repository.Role
+	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "invalid JSON body")
+		return
+	}
+	if err := a.roles.CreateRole(r.Context(), &role); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, role)
+}
+
+func (a *App) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	if err := a.roles.DeleteRole(r.Context(), pathID(r, "roleID")); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) SetRoleParent(w http.ResponseWriter, r *http.Request) {
+	// {"parent_id": null} removes the parent
+	var body struct {
+		ParentID *int `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "invalid JSON body")
+		return
+	}
+	if err := a.roles.SetRoleParent(r.Context(), pathID(r, "roleID"), body.ParentID); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) RolePermissions(w http.ResponseWriter, r *http.Request) {
+	permissions, err := a.roles.RolePermissions(r.Context(), pathID(r, "roleID"))
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, permissions)
+}
+
+func (a *App) GrantPermission(w http.ResponseWriter, r *http.Request) {
+	if err := a.roles.GrantPermission(r.Context(), pathID(r, "roleID"), pathID(r, "permissionID")); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) RevokePermission(w http.ResponseWriter, r *http.Request) {
+	if err := a.roles.RevokePermission(r.Context(), pathID(r, "roleID"), pathID(r, "permissionID")); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	permissions, err := a.roles.ListPermissions(r.Context())
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, permissions)
+}
+
+func (a *App) CreatePermission(w http.ResponseWriter, r *http.Request) {
+	var p repository.Permission
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidArgument, "invalid JSON body")
+		return
+	}
+	if err := a.roles.CreatePermission(r.Context(), &p); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func (a *App) UserRoles(w http.ResponseWriter, r *http.Request) {
+	userRoles, err := a.roles.UserRoles(r.Context(), pathID(r, "userID"))
+	if err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, userRoles)
+}
+
+func (a *App) AssignRole(w http.ResponseWriter, r *http.Request) {
+	if err := a.roles.AssignRole(r.Context(), pathID(r, "userID"), pathID(r, "roleID")); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) UnassignRole(w http.ResponseWriter, r *http.Request) {
+	if err := a.roles.UnassignRole(r.Context(), pathID(r, "userID"), pathID(r, "roleID")); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// the routes only match digits, so the error can be ignored
+func pathID(r *http.Request, name string) int {
+	id, _ := strconv.Atoi(mux.Vars(r)[name])
+	return id
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func writeRoleError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	message := "Something went wrong, please try later"
+	switch {
+	case errors.Is(err, access.ErrInvalidArgument):
+		status, message = http.StatusBadRequest, err.Error()
+	case errors.Is(err, repository.ErrRoleNotFound), errors.Is(err, repository.ErrReferenceNotFound):
+		status, message = http.StatusNotFound, err.Error()
+	case errors.Is(err, repository.ErrRoleExists), errors.Is(err, repository.ErrRoleCycle):
+		status, message = http.StatusConflict, err.Error()
+	default:
+		log.Printf("Error managing roles: %v", err)
+	}
+	writeError(w, status, statusCode(status), message)
+}
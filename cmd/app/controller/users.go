@@ -2,88 +2,72 @@ package controller
 
 import (
 	"AuthDB/cmd/app/repository"
-	"context"
-	"encoding/json"
 	"html/template"
 	"net/http"
+	"net/url"
 	"path/filepath"
-	"strconv"
-
-	"github.com/gorilla/mux"
 )
 
-func GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	users, err := repository.GetAllUsers(ctx, nil)
-	if err != nil {
-		http.Error(w, err.Error(), 400)
-		return
-	}
-	main := filepath.Join("public", "html", "usersPage.html")
-	tmpl, err := template.ParseFiles(main)
+type usersPageData struct {
+	Users []repository.User
+	// the filter as it came, for the form
+	Query url.Values
+	// links to the first and the next page, empty when there is none
+	First string
+	Next  string
+	Total *int
+	// the choices of the form
+	Statuses []repository.AccountStatus
+	Sorts    []string
+}
+
+// GET /users takes the parameters of GET /api/v1/admin/users
+func (a *App) UsersPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := userFilterFromQuery(query)
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
-	err = tmpl.ExecuteTemplate(w, "users", users)
+	page, err := a.users.ListUsers(r.Context(), filter)
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
-}
 
-func AddUsers(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
-	username := r.FormValue("username")
-	email := r.FormValue("email")
-	password := r.FormValue("password")
-
-	if username == "" || email == "" || password == "" {
-		http.Error(w, "Not all fields are filled in", http.StatusBadRequest)
-		return
-	}
-	user, err := repository.NewUser(username, email, password)
-	if err != nil {
-		http.Error(w, "Error creating user", http.StatusInternalServerError)
-		return
-	}
-	err = user.Add(ctx, nil)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	data := usersPageData{Users: page.Users, Query: query, Total: page.Total,
+		Statuses: []repository.AccountStatus{repository.StatusActive, repository.StatusDisabled,
+			repository.StatusPendingDeletion, repository.StatusDeleted}}
+	for _, column := range []string{"id", "username", "email", "created_at"} {
+		data.Sorts = append(data.Sorts, column, "-"+column)
 	}
-	err = json.NewEncoder(w).Encode("User added successfully")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	link := func(cursor string) string {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Del("cursor")
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		return "/users?" + q.Encode()
 	}
-}
-
-func DeleteUserByID(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userId := vars["userID"]
-
-	ctx := context.Background()
-	user, err := repository.GetUserById(ctx, userId)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	if filter.Cursor != "" {
+		data.First = link("")
 	}
-
-	userID, err := strconv.Atoi(userId)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if page.Next != "" {
+		data.Next = link(page.Next)
 	}
 
-	err = user.DeleteByID(ctx, nil, userID)
+	main := filepath.Join("public", "html", "usersPage.html")
+	tmpl, err := template.ParseFiles(main)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), 400)
 		return
 	}
-
-	err = json.NewEncoder(w).Encode("User deleted successfully")
+	err = tmpl.ExecuteTemplate(w, "users", data)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), 400)
 		return
 	}
 }
@@ -0,0 +1,21 @@
+package controller
+
+import "AuthDB/cmd/app/controller/helper"
+
+// validateSignup is the signup rules of the HTML form and the JSON API,
+// an empty result means the input is fine, otherwise it's the message for the user
+func validateSignup(username, email, password, repassword string) string {
+	if username == "" || email == "" || password == "" || repassword == "" {
+		return "Not all fields are filled in"
+	}
+	if password != repassword {
+		return "Password mismatch"
+	}
+	if msg := helper.ValidatePassword(password); msg != "" {
+		return msg
+	}
+	if msg := helper.ValidateEmail(email); msg != "" {
+		return msg
+	}
+	return helper.ValidateUsername(username)
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyExists   = errors.New("api key with this name already exists")
+)
+
+// APIKey identifies another service, the key itself is never stored
+type APIKey struct {
+	ID        int        `json:"id" db:"id"`
+	Name      string     `json:"name" db:"name"`
+	Roles     []string   `json:"roles" db:"roles"`
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+}
+
+// prefix of every key, makes them easy to spot in logs and configs
+const apiKeyPrefix = "adb_"
+
+// CreateAPIKey returns the new key, it can't be read again later
+func (r *Repository) CreateAPIKey(ctx context.Context, tx pgx.Tx, name string, roles []string) (string, *APIKey, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(raw)
+
+	apiKey := &APIKey{Name: name, Roles: roles}
+	err := r.conn(ctx, tx).QueryRow(ctx,
+		`insert into api_keys (name, key_hash, roles) values ($1, $2, $3) returning id, created_at`,
+		name, hashAPIKey(key), roles).Scan(&apiKey.ID, &apiKey.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return "", nil, ErrAPIKeyExists
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+// FindAPIKey looks up a key that was not revoked
+func (r *Repository) FindAPIKey(ctx context.Context, tx pgx.Tx, key string) (*APIKey, error) {
+	var apiKey APIKey
+	err := r.conn(ctx, tx).QueryRow(ctx,
+		`select id, name, roles, created_at from api_keys where key_hash = $1 and revoked_at is null`,
+		hashAPIKey(key)).Scan(&apiKey.ID, &apiKey.Name, &apiKey.Roles, &apiKey.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+func (r *Repository) RevokeAPIKey(ctx context.Context, tx pgx.Tx, name string) error {
+	tag, err := r.conn(ctx, tx).Exec(ctx,
+		`update api_keys set revoked_at = now() where name = $1 and revoked_at is null`, name)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Defaults of Cluster.MaxLag and Cluster.StickyFor
+const (
+	DefaultReplicaMaxLag = 2 * time.Second
+	// longer than the lag a replica may have plus a health check, so a session never reads
+	// a replica older than its last write
+	DefaultStickyFor = 5 * time.Second
+)
+
+// Cluster is the primary with its read replicas, a DB for the stores. Everything runs on the
+// primary but the reads the stores send to Reader: the lookups, listings and searches of users
+// and the event log go to a healthy replica, round robin. A replica is healthy once Check
+// reached it and found it less than MaxLag behind, a replica failing a query is left out
+// until the next Check and the query runs on the primary.
+//
+// The permission checks of Repository and the logins of auth.Service stay on the primary (main gives
+// them the pool of Primary), a revoked role or a disabled account must not linger on a replica.
+type Cluster struct {
+	primary  *pgxpool.Pool
+	replicas []*replica
+	next     atomic.Uint32
+
+	MaxLag time.Duration
+	// a session reads on the primary this long after it used it, see WithSession
+	StickyFor time.Duration
+
+	mu     sync.Mutex
+	writes map[string]time.Time
+}
+
+type replica struct {
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+// NewCluster connects to the replicas lazily, an unreachable one only stays unhealthy.
+// Without replicas everything runs on the primary.
+func NewCluster(ctx context.Context, primary *pgxpool.Pool, replicaURLs ...string) (*Cluster, error) {
+	c := &Cluster{primary: primary, MaxLag: DefaultReplicaMaxLag, StickyFor: DefaultStickyFor,
+		writes: make(map[string]time.Time)}
+	for _, url := range replicaURLs {
+		cfg, err := poolConfig(url)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		cfg.LazyConnect = true
+		pool, err := pgxpool.ConnectConfig(ctx, cfg)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("replica %s: %w", cfg.ConnConfig.Host, err)
+		}
+		c.replicas = append(c.replicas, &replica{pool: pool})
+	}
+	return c, nil
+}
+
+func (c *Cluster) Primary() *pgxpool.Pool {
+	return c.primary
+}
+
+// Close closes the replicas, the primary belongs to the caller
+func (c *Cluster) Close() {
+	for _, r := range c.replicas {
+		r.pool.Close()
+	}
+}
+
+type sessionKey struct{}
+
+// WithSession makes the queries of ctx those of one session, usually a user: for StickyFor after
+// the session used the primary, its reads go there too and it reads what it wrote
+func WithSession(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+type primaryKey struct{}
+
+// OnPrimary makes the reads of ctx go to the primary, for the ones a write is checked against
+// (an If-Match compared with a lagging replica would fail)
+func OnPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// wrote remembers the session of ctx used the primary
+func (c *Cluster) wrote(ctx context.Context) {
+	if session, ok := ctx.Value(sessionKey{}).(string); ok && len(c.replicas) > 0 {
+		c.mu.Lock()
+		c.writes[session] = time.Now()
+		c.mu.Unlock()
+	}
+}
+
+func (c *Cluster) sticky(ctx context.Context) bool {
+	session, ok := ctx.Value(sessionKey{}).(string)
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	at, ok := c.writes[session]
+	return ok && time.Since(at) < c.StickyFor
+}
+
+func (c *Cluster) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	c.wrote(ctx)
+	return c.primary.Exec(ctx, sql, args...)
+}
+
+func (c *Cluster) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	c.wrote(ctx)
+	return c.primary.Query(ctx, sql, args...)
+}
+
+func (c *Cluster) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	c.wrote(ctx)
+	return c.primary.QueryRow(ctx, sql, args...)
+}
+
+func (c *Cluster) Begin(ctx context.Context) (pgx.Tx, error) {
+	c.wrote(ctx)
+	return c.primary.Begin(ctx)
+}
+
+func (c *Cluster) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	c.wrote(ctx)
+	return c.primary.BeginTx(ctx, opts)
+}
+
+// Reader is where the read-only queries of ctx go: a healthy replica, or the primary when
+// there is none, the session of ctx is sticky or ctx is OnPrimary. It must not be used for writes.
+func (c *Cluster) Reader(ctx context.Context) Querier {
+	if onPrimary, _ := ctx.Value(primaryKey{}).(bool); onPrimary || len(c.replicas) == 0 || c.sticky(ctx) {
+		return c.primary
+	}
+	start := c.next.Add(1)
+	for i := range c.replicas {
+		r := c.replicas[(int(start)+i)%len(c.replicas)]
+		if r.healthy.Load() {
+			return &replicaQuerier{cluster: c, replica: r}
+		}
+	}
+	return c.primary
+}
+
+// Healthy is how many replicas take reads
+func (c *Cluster) Healthy() int {
+	n := 0
+	for _, r := range c.replicas {
+		if r.healthy.Load() {
+			n++
+		}
+	}
+	return n
+}
+
+// Check measures the lag of every replica, the ones not answering or behind by MaxLag
+// or more stop taking reads until they catch up. It also forgets the old writes of the sessions.
+func (c *Cluster) Check(ctx context.Context) {
+	for _, r := range c.replicas {
+		// a server that is not in recovery has no replay lsn, no lag either
+		var lag float64
+		err := r.pool.QueryRow(ctx, `select coalesce(case when pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() then 0
+			else extract(epoch from now() - pg_last_xact_replay_timestamp()) end, 0)::float8`).Scan(&lag)
+		healthy := err == nil && time.Duration(lag*float64(time.Second)) < c.MaxLag
+		if was := r.healthy.Swap(healthy); was != healthy {
+			host := r.pool.Config().ConnConfig.Host
+			switch {
+			case healthy:
+				log.Printf("Replica %s takes reads again", host)
+			case err != nil:
+				log.Printf("Replica %s is unreachable, reading from the primary: %v", host, err)
+			default:
+				log.Printf("Replica %s is %.1fs behind, reading from the primary", host, lag)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	for session, at := range c.writes {
+		if time.Since(at) >= c.StickyFor {
+			delete(c.writes, session)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// Run checks the replicas every interval until ctx is done
+func (c *Cluster) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replicaQuerier runs the reads on a replica and on the primary when the replica fails them
+type replicaQuerier struct {
+	cluster *Cluster
+	replica *replica
+}
+
+func (q *replicaQuerier) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return q.cluster.Exec(ctx, sql, args...)
+}
+
+func (q *replicaQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	rows, err := q.replica.pool.Query(ctx, sql, args...)
+	if q.fallback(ctx, err) {
+		return q.cluster.primary.Query(ctx, sql, args...)
+	}
+	return rows, err
+}
+
+func (q *replicaQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return &fallbackRow{querier: q, ctx: ctx, sql: sql, args: args,
+		row: q.replica.pool.QueryRow(ctx, sql, args...)}
+}
+
+// fallback tells if the query has to run on the primary instead, an unreachable replica
+// is left out until Check finds it again
+func (q *replicaQuerier) fallback(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		// canceled by a conflict with the recovery, the primary has none
+		case "40001":
+			return true
+		// shutting down or starting
+		case "57P01", "57P02", "57P03":
+		default:
+			return false
+		}
+	} else {
+		var netErr net.Error
+		if !errors.As(err, &netErr) && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return false
+		}
+	}
+	if q.replica.healthy.Swap(false) {
+		log.Printf("Replica %s failed, reading from the primary: %v", q.replica.pool.Config().ConnConfig.Host, err)
+	}
+	return true
+}
+
+// fallbackRow is the row of the replica, or of the primary if the replica failed the query
+type fallbackRow struct {
+	querier *replicaQuerier
+	ctx     context.Context
+	sql     string
+	args    []interface{}
+	row     pgx.Row
+}
+
+func (r *fallbackRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	if r.querier.fallback(r.ctx, err) {
+		return r.querier.cluster.primary.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	}
+	return err
+}
+
+// reader is a DB with replicas, Cluster
+type reader interface {
+	Reader(ctx context.Context) Querier
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 	
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -16,23 +17,11 @@ var(
 	
 // Initialize maindb and testdb
 func InitDBConn(ctx context.Context, dbURL string) (dbpool *pgxpool.Pool, err error) {
-	cfg, err := pgxpool.ParseConfig(dbURL)
+	cfg, err := poolConfig(dbURL)
 	if err != nil {
-		err = fmt.Errorf("failed to parse pg config: %v", err)
 		return
 	}
 
-	// MaxConns is the maximum size of the pool
-	cfg.MaxConns = int32(10)
-	cfg.MinConns = int32(1)
-	// HealthCheckPeriod is the duration between checks of the health of idle connections.
-	cfg.HealthCheckPeriod = 1 * time.Minute
-	// MaxConnLifetime is the duration since creation after which a connection will be automatically closed.
-	cfg.MaxConnLifetime = 24 * time.Hour
-	// It's like maxconnlifetime but it'll be closed by the health check.
-	cfg.MaxConnIdleTime = 30 * time.Minute
-	cfg.ConnConfig.ConnectTimeout = 5 * time.Second
-	
 	dbpool, err = pgxpool.ConnectConfig(ctx, cfg)
 	if err != nil {
 		err = fmt.Errorf("failed to connect config: %w", err)
@@ -43,3 +32,28 @@ func InitDBConn(ctx context.Context, dbURL string) (dbpool *pgxpool.Pool, err er
 	Dbpool = dbpool
 	return
 }
+
+// poolConfig is the config of the pools of the primary and the replicas,
+// pool_max_conns and pool_min_conns of the URL override the defaults
+func poolConfig(dbURL string) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pg config: %v", err)
+	}
+
+	// MaxConns is the maximum size of the pool
+	if !strings.Contains(dbURL, "pool_max_conns") {
+		cfg.MaxConns = int32(10)
+	}
+	if !strings.Contains(dbURL, "pool_min_conns") {
+		cfg.MinConns = int32(1)
+	}
+	// HealthCheckPeriod is the duration between checks of the health of idle connections.
+	cfg.HealthCheckPeriod = 1 * time.Minute
+	// MaxConnLifetime is the duration since creation after which a connection will be automatically closed.
+	cfg.MaxConnLifetime = 24 * time.Hour
+	// It's like maxconnlifetime but it'll be closed by the health check.
+	cfg.MaxConnIdleTime = 30 * time.Minute
+	cfg.ConnConfig.ConnectTimeout = 5 * time.Second
+	return cfg, nil
+}
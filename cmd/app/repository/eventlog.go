@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// UserEvent is one entry of the login history or the audit trail of a user
+type UserEvent struct {
+	ID     int64 `json:"id"`
+	UserID int   `json:"user_id"`
+	// the admin who did it, nil for what the user did
+	ActorID   *int                   `json:"actor_id,omitempty"`
+	Event     string                 `json:"event"`
+	Details   map[string]interface{} `json:"details"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// EventLog keeps the events of the users, PostgresEventLog in user_events
+// and MemoryEventLog for tests. Both pass tests/storetest.
+type EventLog interface {
+	// RecordEvent sets ID and CreatedAt
+	RecordEvent(ctx context.Context, e *UserEvent) error
+	// UserEvents are the events about the user and the ones the user did to others, oldest first
+	UserEvents(ctx context.Context, userID int) ([]UserEvent, error)
+	// EraseUserEvents empties the details of the events about the user, the rows stay
+	EraseUserEvents(ctx context.Context, userID int) error
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryEventLog keeps the events in a slice, for tests without a database
+type MemoryEventLog struct {
+	mu     sync.Mutex
+	events []UserEvent
+}
+
+func NewMemoryEventLog() *MemoryEventLog {
+	return &MemoryEventLog{}
+}
+
+func (l *MemoryEventLog) RecordEvent(ctx context.Context, e *UserEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e.Details == nil {
+		e.Details = map[string]interface{}{}
+	}
+	e.ID = int64(len(l.events) + 1)
+	e.CreatedAt = time.Now()
+	l.events = append(l.events, copyEvent(*e))
+	return nil
+}
+
+func (l *MemoryEventLog) UserEvents(ctx context.Context, userID int) ([]UserEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := []UserEvent{}
+	for _, e := range l.events {
+		if e.UserID == userID || (e.ActorID != nil && *e.ActorID == userID) {
+			events = append(events, copyEvent(e))
+		}
+	}
+	return events, nil
+}
+
+func (l *MemoryEventLog) EraseUserEvents(ctx context.Context, userID int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range l.events {
+		if l.events[i].UserID == userID {
+			l.events[i].Details = map[string]interface{}{}
+		}
+	}
+	return nil
+}
+
+func copyEvent(e UserEvent) UserEvent {
+	details := make(map[string]interface{}, len(e.Details))
+	for k, v := range e.Details {
+		details[k] = v
+	}
+	e.Details = details
+	if e.ActorID != nil {
+		id := *e.ActorID
+		e.ActorID = &id
+	}
+	return e
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+)
+
+// PostgresEventLog keeps the events in the user_events table,
+// called with the ctx of WithTx it runs in that transaction
+type PostgresEventLog struct {
+	db DB
+}
+
+func NewPostgresEventLog(db DB) *PostgresEventLog {
+	return &PostgresEventLog{db: db}
+}
+
+func (l *PostgresEventLog) q(ctx context.Context) Querier {
+	if tx := TxFromContext(ctx); tx != nil {
+		return tx
+	}
+	return l.db
+}
+
+// reads go to a replica when db is a Cluster
+func (l *PostgresEventLog) r(ctx context.Context) Querier {
+	if tx := TxFromContext(ctx); tx != nil {
+		return tx
+	}
+	if c, ok := l.db.(reader); ok {
+		return c.Reader(ctx)
+	}
+	return l.db
+}
+
+func (l *PostgresEventLog) RecordEvent(ctx context.Context, e *UserEvent) error {
+	if e.Details == nil {
+		e.Details = map[string]interface{}{}
+	}
+	return l.q(ctx).QueryRow(ctx, `insert into user_events (user_id, actor_id, event, details)
+		values ($1, $2, $3, $4) returning id, created_at`,
+		e.UserID, e.ActorID, e.Event, e.Details).Scan(&e.ID, &e.CreatedAt)
+}
+
+func (l *PostgresEventLog) UserEvents(ctx context.Context, userID int) ([]UserEvent, error) {
+	rows, err := l.r(ctx).Query(ctx, `select id, user_id, actor_id, event, details, created_at from user_events
+		where user_id = $1 or actor_id = $1
+		order by created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []UserEvent{}
+	for rows.Next() {
+		var e UserEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.ActorID, &e.Event, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (l *PostgresEventLog) EraseUserEvents(ctx context.Context, userID int) error {
+	_, err := l.q(ctx).Exec(ctx, `update user_events set details = '{}' where user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Permission allows Action on Resource, "*" matches any value
+type Permission struct {
+	ID          int    `json:"id" db:"id"`
+	Resource    string `json:"resource" db:"resource"`
+	Action      string `json:"action" db:"action"`
+	Description string `json:"description" db:"description"`
+}
+
+const Wildcard = "*"
+
+// Allows reports whether the permission covers action on resource
+func (p Permission) Allows(resource, action string) bool {
+	return (p.Resource == Wildcard || p.Resource == resource) &&
+		(p.Action == Wildcard || p.Action == action)
+}
+
+// Attributes of the user the access policies can look at
+func (r *Repository) UserAttributes(ctx context.Context, tx pgx.Tx, userID int) (map[string]string, error) {
+	rows, err := r.conn(ctx, tx).Query(ctx, `select key, value from user_attributes where user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attrs := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		attrs[key] = value
+	}
+	return attrs, rows.Err()
+}
+
+func (r *Repository) SetUserAttribute(ctx context.Context, tx pgx.Tx, userID int, key, value string) error {
+	_, err := r.conn(ctx, tx).Exec(ctx, `insert into user_attributes (user_id, key, value) values ($1, $2, $3)
+		on conflict (user_id, key) do update set value = excluded.value`, userID, key, value)
+	return mapForeignKey(err)
+}
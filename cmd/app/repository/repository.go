@@ -1,10 +1,11 @@
 // If you see tx, then this function is interacting with the transaction
 // If you need to use a transaction, then tx should not be nill
+// Without tx the functions run in the transaction of WithTx in ctx, if there is one
 package repository
 
 import (
 	"context"
-	"fmt"
+	"errors"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -17,113 +18,38 @@ type Repository struct {
 func NewRepository(pool *pgxpool.Pool) *Repository {
 	return &Repository{pool: pool}
 }
-func (r *Repository) Login(ctx context.Context, tx pgx.Tx, username string) (*User, error) {
-	query := `SELECT id, username, password, email FROM users WHERE username = $1`
-	u := User{}
 
-	var err error
+// the user operations on the transaction if there is one, otherwise on the pool
+// (or the transaction of WithTx in ctx). Without the PII keys, like the deprecated User methods.
+func (r *Repository) users(tx pgx.Tx) *PostgresUserStore {
 	if tx != nil {
-		err = tx.QueryRow(ctx, query, username).Scan(&u.ID, &u.Username, &u.Password, &u.Email)
-	} else {
-		err = r.pool.QueryRow(ctx, query, username).Scan(&u.ID, &u.Username, &u.Password, &u.Email)
+		return NewPostgresUserStore(tx, nil)
 	}
+	return NewPostgresUserStore(r.pool, nil)
+}
 
+// Login returns nil without an error if there is no such user.
+//
+// Deprecated: use UserStore.GetUserByUsername
+func (r *Repository) Login(ctx context.Context, tx pgx.Tx, username string) (*User, error) {
+	u, err := r.users(tx).GetUserByUsername(ctx, username)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil
+	}
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil
-		}
 		return nil, err
 	}
-	
 	return &u, nil
 }
 
 // Checking if such a user already exists.
-// If user already exists with the same username and email,
-// then count will be > 0
-func (r *Repository) UserExist(ctx context.Context, tx pgx.Tx, username, email string) (exist bool, err error) {
-	var count int
-	query := `select count(*) from users where username = $1 or email = $2`
-
-	if tx != nil {
-		err = tx.QueryRow(ctx, query, username, email).Scan(&count)
-	} else {
-		err = r.pool.QueryRow(ctx, query, username, email).Scan(&count)
-	}
-	if err != nil {
-		return false, err
-	}
-	return count > 0, nil
+//
+// Deprecated: use UserStore.UserExists
+func (r *Repository) UserExist(ctx context.Context, tx pgx.Tx, username, email string) (bool, error) {
+	return r.users(tx).UserExists(ctx, username, email)
 }
 
-func (r *Repository) GetByID(ctx context.Context, tx pgx.Tx, id int) (user User, err error) {
-	query := `select * from users where id = $1`
-
-	if tx != nil {
-		err = tx.QueryRow(ctx, query, id).Scan(
-			&user.ID, &user.Username, &user.Email,
-			&user.Password, &user.Role, &user.CreatedAt,
-		)
-	} else {
-		err = r.pool.QueryRow(ctx, query, id).Scan(
-			&user.ID, &user.Username, &user.Email,
-			&user.Password, &user.Role, &user.CreatedAt,
-		)
-	}
-	if err != nil {
-		return user, err
-	}
-	return user, nil
-}
-
-func (r *Repository) DeleteUserByID(ctx context.Context, id int) error {
-	query := `delete from users where id = $1`
-
-	_, err := r.pool.Exec(ctx, query, id)
-	return err
+// Deprecated: use UserStore.GetUser
+func (r *Repository) GetByID(ctx context.Context, tx pgx.Tx, id int) (User, error) {
+	return r.users(tx).GetUser(ctx, id)
 }
-
-func (r *Repository) UpdateData(ctx context.Context, query, new, old string) error {
-	_, err := r.pool.Exec(ctx, query, new, old)
-	return err
-}
-
-func (r *Repository) FindUserByEmail(ctx context.Context, email string) (u User, err error) {
-	row := r.pool.QueryRow(ctx, `select id, username, email, password from users where email = $1`,
-		email)
-	err = row.Scan(&u.ID, &u.Username, &u.Email, &u.Password)
-	if err != nil {
-		return u, fmt.Errorf("failed to query data: %v", err)
-	}
-	return u, nil
-}
-
-func (r *Repository) FindUserByPassword(ctx context.Context, password string) (u User, err error) {
-	row := r.pool.QueryRow(ctx, `select id, username, email, password from users where password = $1`,
-		password)
-	err = row.Scan(&u.ID, &u.Username, &u.Email, &u.Password)
-	if err != nil {
-		return u, fmt.Errorf("failed to query data: %v", err)
-	}
-	return u, nil
-}
-
-func (r *Repository) FindUserByLogin(ctx context.Context, username string) (u User, err error) {
-	row := r.pool.QueryRow(ctx, `select id, username, email, password from users where username = $1`,
-		username)
-	err = row.Scan(&u.ID, &u.Username, &u.Email, &u.Password)
-	if err != nil {
-		return u, fmt.Errorf("failed to query data: %v", err)
-	}
-	return u, nil
-}
-
-func (r *Repository) FindUserByID(ctx context.Context, userID int) (u User, err error) {
-	row := r.pool.QueryRow(ctx, "select id, username, role from users where id = $1",
-		userID)
-	err = row.Scan(&u.ID, &u.Username, &u.Role)
-	if err != nil{
-		return u, fmt.Errorf("failed to query data: %v", err)
-	}
-	return u, nil
-}
\ No newline at end of file
@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	ErrRoleNotFound       = errors.New("role not found")
+	ErrRoleExists         = errors.New("role already exists")
+	ErrPermissionNotFound = errors.New("permission not found")
+	ErrRoleCycle          = errors.New("role hierarchy must not contain cycles")
+	ErrReferenceNotFound  = errors.New("user, role or permission not found")
+)
+
+// Role inherits every permission of its parent
+type Role struct {
+	ID          int    `json:"id" db:"id"`
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+	ParentID    *int   `json:"parent_id,omitempty" db:"parent_id"`
+}
+
+// Role every new user gets
+const DefaultRole = "user"
+
+// pgx.Tx and *pgxpool.Pool both satisfy it
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// tx if there is one, then the transaction of WithTx in ctx, otherwise the pool
+func (r *Repository) conn(ctx context.Context, tx pgx.Tx) Querier {
+	if tx != nil {
+		return tx
+	}
+	if tx := TxFromContext(ctx); tx != nil {
+		return tx
+	}
+	return r.pool
+}
+
+// what WithTx starts the transaction on, a savepoint of tx if there is one
+func (r *Repository) beginner(tx pgx.Tx) TxBeginner {
+	if tx != nil {
+		return tx
+	}
+	return r.pool
+}
+
+// ids of the roles and of everything they inherit
+const effectiveRolesCTE = `with recursive effective(id) as (
+		select role_id from user_roles where user_id = $1
+		union
+		select r.parent_id from roles r join effective e on r.id = e.id where r.parent_id is not null
+	)`
+
+func (r *Repository) ListRoles(ctx context.Context, tx pgx.Tx) ([]Role, error) {
+	rows, err := r.conn(ctx, tx).Query(ctx,
+		`select id, name, coalesce(description, ''), parent_id from roles order by id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanRoles(rows)
+}
+
+func (r *Repository) GetRoleByName(ctx context.Context, tx pgx.Tx, name string) (role Role, err error) {
+	err = r.conn(ctx, tx).QueryRow(ctx,
+		`select id, name, coalesce(description, ''), parent_id from roles where name = $1`, name).
+		Scan(&role.ID, &role.Name, &role.Description, &role.ParentID)
+	if err == pgx.ErrNoRows {
+		return role, ErrRoleNotFound
+	}
+	return role, err
+}
+
+func (r *Repository) CreateRole(ctx context.Context, tx pgx.Tx, role *Role) error {
+	err := r.conn(ctx, tx).QueryRow(ctx,
+		`insert into roles (name, description, parent_id) values ($1, $2, $3) returning id`,
+		role.Name, role.Description, role.ParentID).Scan(&role.ID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrRoleExists
+	}
+	return mapForeignKey(err)
+}
+
+func (r *Repository) DeleteRole(ctx context.Context, tx pgx.Tx, roleID int) error {
+	tag, err := r.conn(ctx, tx).Exec(ctx, `delete from roles where id = $1`, roleID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+// SetRoleParent makes the role inherit from parentID, nil removes the parent.
+// The check and the update are serializable, so two concurrent changes can't make a cycle together.
+func (r *Repository) SetRoleParent(ctx context.Context, tx pgx.Tx, roleID int, parentID *int) error {
+	return WithTx(ctx, r.beginner(tx), func(ctx context.Context) error {
+		if parentID != nil {
+			// walk up from the new parent, meeting the role itself means a cycle
+			var cycle bool
+			err := r.conn(ctx, nil).QueryRow(ctx, `with recursive ancestors(id) as (
+					select $1::int
+					union
+					select r.parent_id from roles r join ancestors a on r.id = a.id where r.parent_id is not null
+				)
+				select exists (select 1 from ancestors where id = $2)`, *parentID, roleID).Scan(&cycle)
+			if err != nil {
+				return err
+			}
+			if cycle {
+				return ErrRoleCycle
+			}
+		}
+
+		tag, err := r.conn(ctx, nil).Exec(ctx, `update roles set parent_id = $1 where id = $2`, parentID, roleID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrRoleNotFound
+		}
+		return nil
+	}, Isolation(pgx.Serializable))
+}
+
+func (r *Repository) ListPermissions(ctx context.Context, tx pgx.Tx) ([]Permission, error) {
+	rows, err := r.conn(ctx, tx).Query(ctx,
+		`select id, resource, action, coalesce(description, '') from permissions order by resource, action`)
+	if err != nil {
+		return nil, err
+	}
+	return scanPermissions(rows)
+}
+
+func (r *Repository) CreatePermission(ctx context.Context, tx pgx.Tx, p *Permission) error {
+	return r.conn(ctx, tx).QueryRow(ctx,
+		`insert into permissions (resource, action, description) values ($1, $2, $3)
+		on conflict (resource, action) do update set description = excluded.description
+		returning id`,
+		p.Resource, p.Action, p.Description).Scan(&p.ID)
+}
+
+// Permissions granted to the role directly, without inheritance
+func (r *Repository) RolePermissions(ctx context.Context, tx pgx.Tx, roleID int) ([]Permission, error) {
+	rows, err := r.conn(ctx, tx).Query(ctx, `select p.id, p.resource, p.action, coalesce(p.description, '')
+		from permissions p
+		join role_permissions rp on rp.permission_id = p.id
+		where rp.role_id = $1
+		order by p.resource, p.action`, roleID)
+	if err != nil {
+		return nil, err
+	}
+	return scanPermissions(rows)
+}
+
+func (r *Repository) GrantPermission(ctx context.Context, tx pgx.Tx, roleID, permissionID int) error {
+	_, err := r.conn(ctx, tx).Exec(ctx,
+		`insert into role_permissions (role_id, permission_id) values ($1, $2) on conflict do nothing`,
+		roleID, permissionID)
+	return mapForeignKey(err)
+}
+
+func (r *Repository) RevokePermission(ctx context.Context, tx pgx.Tx, roleID, permissionID int) error {
+	_, err := r.conn(ctx, tx).Exec(ctx,
+		`delete from role_permissions where role_id = $1 and permission_id = $2`, roleID, permissionID)
+	return err
+}
+
+func (r *Repository) AssignRole(ctx context.Context, tx pgx.Tx, userID, roleID int) error {
+	_, err := r.conn(ctx, tx).Exec(ctx,
+		`insert into user_roles (user_id, role_id) values ($1, $2) on conflict do nothing`, userID, roleID)
+	return mapForeignKey(err)
+}
+
+func (r *Repository) UnassignRole(ctx context.Context, tx pgx.Tx, userID, roleID int) error {
+	_, err := r.conn(ctx, tx).Exec(ctx,
+		`delete from user_roles where user_id = $1 and role_id = $2`, userID, roleID)
+	return err
+}
+
+// Roles assigned to the user directly
+func (r *Repository) UserRoles(ctx context.Context, tx pgx.Tx, userID int) ([]Role, error) {
+	rows, err := r.conn(ctx, tx).Query(ctx, `select r.id, r.name, coalesce(r.description, ''), r.parent_id
+		from roles r
+		join user_roles ur on ur.role_id = r.id
+		where ur.user_id = $1
+		order by r.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRoles(rows)
+}
+
+// Names of the user's roles together with every inherited role
+func (r *Repository) EffectiveRoles(ctx context.Context, tx pgx.Tx, userID int) ([]string, error) {
+	var names []string
+	err := r.conn(ctx, tx).QueryRow(ctx, effectiveRolesCTE+`
+		select coalesce(array_agg(r.name order by r.name), '{}') from roles r where r.id in (select id from effective)`,
+		userID).Scan(&names)
+	return names, err
+}
+
+// The named roles with every role they inherit from, for principals that are not users
+func (r *Repository) RolesEffective(ctx context.Context, tx pgx.Tx, roles []string) ([]string, error) {
+	var names []string
+	err := r.conn(ctx, tx).QueryRow(ctx, `with recursive effective(id) as (
+			select id from roles where name = any($1)
+			union
+			select r.parent_id from roles r join effective e on r.id = e.id where r.parent_id is not null
+		)
+		select coalesce(array_agg(r.name order by r.name), '{}') from roles r where r.id in (select id from effective)`,
+		roles).Scan(&names)
+	return names, err
+}
+
+// Every permission of the user, inherited ones included
+func (r *Repository) UserPermissions(ctx context.Context, tx pgx.Tx, userID int) ([]Permission, error) {
+	rows, err := r.conn(ctx, tx).Query(ctx, effectiveRolesCTE+`
+		select distinct p.id, p.resource, p.action, coalesce(p.description, '')
+		from permissions p
+		join role_permissions rp on rp.permission_id = p.id
+		where rp.role_id in (select id from effective)`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanPermissions(rows)
+}
+
+// Every permission of the named roles, inherited ones included.
+// Used for principals that are not users, e.g. API keys.
+func (r *Repository) RolesPermissions(ctx context.Context, tx pgx.Tx, roles []string) ([]Permission, error) {
+	rows, err := r.conn(ctx, tx).Query(ctx, `with recursive effective(id) as (
+			select id from roles where name = any($1)
+			union
+			select r.parent_id from roles r join effective e on r.id = e.id where r.parent_id is not null
+		)
+		select distinct p.id, p.resource, p.action, coalesce(p.description, '')
+		from permissions p
+		join role_permissions rp on rp.permission_id = p.id
+		where rp.role_id in (select id from effective)`, roles)
+	if err != nil {
+		return nil, err
+	}
+	return scanPermissions(rows)
+}
+
+func scanRoles(rows pgx.Rows) ([]Role, error) {
+	defer rows.Close()
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.ParentID); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func scanPermissions(rows pgx.Rows) ([]Permission, error) {
+	defer rows.Close()
+	permissions := []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.ID, &p.Resource, &p.Action, &p.Description); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+	return permissions, rows.Err()
+}
+
+// foreign_key_violation means the user, role or permission doesn't exist
+func mapForeignKey(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return fmt.Errorf("%w: %s", ErrReferenceNotFound, pgErr.Detail)
+	}
+	return err
+}
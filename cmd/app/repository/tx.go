@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+type txKey struct{}
+
+// TxFromContext is the transaction WithTx put into ctx, nil outside of one
+func TxFromContext(ctx context.Context) pgx.Tx {
+	tx, _ := ctx.Value(txKey{}).(pgx.Tx)
+	return tx
+}
+
+func contextWithTx(ctx context.Context, tx pgx.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxBeginner starts transactions, *pgxpool.Pool and pgx.Tx (as a savepoint) do
+type TxBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+type txOptions struct {
+	isoLevel   pgx.TxIsoLevel
+	maxRetries int
+}
+
+type TxOption func(*txOptions)
+
+// Isolation sets the isolation level, read committed by default
+func Isolation(level pgx.TxIsoLevel) TxOption {
+	return func(o *txOptions) { o.isoLevel = level }
+}
+
+// Retries is how many times a transaction that failed on a serialization
+// failure or a deadlock is run again, DefaultTxRetries by default
+func Retries(n int) TxOption {
+	return func(o *txOptions) { o.maxRetries = n }
+}
+
+const DefaultTxRetries = 3
+
+// WithTx runs fn in a transaction carried by the ctx fn gets. Every repository
+// method and PostgresUserStore called with that ctx runs in it. fn returning
+// an error or panicking rolls everything back.
+//
+// Inside another WithTx fn runs in a savepoint of the outer transaction, the
+// options are ignored then and only the outermost call retries, the whole
+// transaction is aborted on a serialization failure anyway.
+// db is used when ctx has no transaction yet.
+func WithTx(ctx context.Context, db TxBeginner, fn func(ctx context.Context) error, opts ...TxOption) error {
+	if tx := TxFromContext(ctx); tx != nil {
+		return runTx(ctx, tx, nil, fn)
+	}
+	if tx, ok := db.(pgx.Tx); ok {
+		// a store made on a transaction, that one is the outer one
+		return runTx(ctx, tx, nil, fn)
+	}
+
+	o := txOptions{maxRetries: DefaultTxRetries}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var txOpts *pgx.TxOptions
+	if o.isoLevel != "" {
+		txOpts = &pgx.TxOptions{IsoLevel: o.isoLevel}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, db, txOpts, fn)
+		if err == nil || !retryable(err) || attempt >= o.maxRetries {
+			return err
+		}
+		// a little longer every time, so the conflicting transaction can finish
+		select {
+		case <-time.After(time.Duration(attempt+1) * 10 * time.Millisecond):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// WithTx runs fn in a transaction on the pool, see the WithTx function
+func (r *Repository) WithTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	return WithTx(ctx, r.pool, fn, opts...)
+}
+
+func runTx(ctx context.Context, db TxBeginner, txOpts *pgx.TxOptions, fn func(ctx context.Context) error) (err error) {
+	var tx pgx.Tx
+	if b, ok := db.(interface {
+		BeginTx(context.Context, pgx.TxOptions) (pgx.Tx, error)
+	}); ok && txOpts != nil {
+		tx, err = b.BeginTx(ctx, *txOpts)
+	} else {
+		tx, err = db.Begin(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback(ctx)
+			panic(p)
+		}
+	}()
+	if err := fn(contextWithTx(ctx, tx)); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// serialization_failure and deadlock_detected, running the transaction again may succeed
+func retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
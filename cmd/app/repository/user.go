@@ -3,7 +3,7 @@ package repository
 import (
 	"AuthDB/utils"
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -13,19 +13,53 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// columns of User in the order userFields expects them,
+// roles are the names assigned to the user directly
+const userColumns = `id, username, email, password,
+		array(select r.name from user_roles ur join roles r on r.id = ur.role_id
+			where ur.user_id = users.id order by r.name) as roles,
+		created_at, disabled_at, version, status, deletion_requested_at, deletion_requested_by, data_key, key_id`
+
+const selectUser = `select ` + userColumns + ` from users`
+
+// AccountStatus is where the account is in its life
+type AccountStatus string
+
+const (
+	StatusActive   AccountStatus = "active"
+	StatusDisabled AccountStatus = "disabled"
+	// the user asked to delete the account, logging in cancels it until the grace period is over
+	StatusPendingDeletion AccountStatus = "pending_deletion"
+	// anonymized by the purge, only ListUsers with this status still finds it
+	StatusDeleted AccountStatus = "deleted"
+)
+
 type User struct {
 	ID        int        `json:"id" db:"id"`
 	Username  string     `json:"username" db:"username"`
 	Password  string     `json:"password" db:"password"`
 	Email     string     `json:"email" db:"email"`
-	Role      string     `json:"role" db:"role"`
+	Roles     []string   `json:"roles" db:"roles"`
 	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+	// set while an admin has disabled the account
+	DisabledAt *time.Time `json:"disabled_at" db:"disabled_at"`
+	// incremented by every change, UserPatch.Version makes an update fail if it changed
+	Version int           `json:"version" db:"version"`
+	Status  AccountStatus `json:"status" db:"status"`
+	// set while the account is pending deletion
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at" db:"deletion_requested_at"`
+	// the id of who asked for the deletion, the user itself or an admin
+	DeletionRequestedBy *int `json:"deletion_requested_by" db:"deletion_requested_by"`
+
+	// the wrapped data key of the encrypted email and its key-encryption key, see package pii
+	dataKey []byte
+	keyID   *string
 }
 
-var (
-	HashPassword string
-	Role         = "user"
-)
+// SelfDeletion tells if the user asked for the pending deletion, not an admin
+func (u User) SelfDeletion() bool {
+	return u.DeletionRequestedBy != nil && *u.DeletionRequestedBy == u.ID
+}
 
 // Creating new user.
 // User struct receives hashed password
@@ -35,65 +69,37 @@ func NewUser(username, email, password string) (*User, error) {
 		return nil, fmt.Errorf("error hashing password: %v", err)
 	}
 	curTime := time.Now()
-	HashPassword = hashedPassword
 	user := &User{
 		Username:  username,
 		Email:     email,
 		Password:  hashedPassword,
-		Role:      Role,
+		Roles:     []string{DefaultRole},
 		CreatedAt: &curTime,
 	}
 	return user, nil
 }
 
-func GetAllUsers(ctx context.Context, tx pgx.Tx) ([]User, error) {
-	var err error
-	var users []User
-	var rows pgx.Rows
-
+// db is the transaction if there is one, otherwise the global pool.
+// The deprecated functions below don't know the PII keys, the encrypted emails need a UserStore.
+func db(tx pgx.Tx) DB {
 	if tx != nil {
-		rows, err = tx.Query(ctx, "select * from users")
-	} else {
-		rows, err = Dbpool.Query(ctx, "select * from users")
-	}
-	if err != nil {
-		return nil, err
+		return tx
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var user User
-		var createdAt sql.NullTime
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.Role, &user.CreatedAt); err != nil {
-			return nil, err
-		}
-		if createdAt.Valid {
-			user.CreatedAt = &createdAt.Time
-		} else {
-			user.CreatedAt = nil
-		}
-		users = append(users, user)
-	}
-	return users, nil
+	return Dbpool
 }
 
-func GetUserById(ctx context.Context, userID string) (u User, err error) {
-	query := `select id, username, email, password from users where id = $1`
-	row := Dbpool.QueryRow(ctx, query, userID)
-	err = row.Scan(&u.ID, &u.Username, &u.Email, &u.Password)
-	return
+// Deprecated: use UserStore.ListUsers
+func GetAllUsers(ctx context.Context, tx pgx.Tx) ([]User, error) {
+	page, err := NewPostgresUserStore(db(tx), nil).ListUsers(ctx, UserFilter{})
+	return page.Users, err
 }
 
-func (u *User) Add(ctx context.Context, tx pgx.Tx) (err error) {
-	if tx != nil {
-		_, err := tx.Exec(ctx, "INSERT INTO users (username, email, password) VALUES ($1, $2, $3)",
-			u.Username, u.Email, u.Password)
-		return err
-	} else {
-		_, err := Dbpool.Exec(ctx, "INSERT INTO users (username, email, password) VALUES ($1, $2, $3)",
-			u.Username, u.Email, u.Password)
-		return err
-	}
+// Add inserts the user together with its roles (DefaultRole if there are none)
+// and sets u.ID.
+//
+// Deprecated: use UserStore.CreateUser
+func (u *User) Add(ctx context.Context, tx pgx.Tx) error {
+	return NewPostgresUserStore(db(tx), nil).CreateUser(ctx, u)
 }
 
 func (u *User) AddAdminUser(ctx context.Context, pool *pgxpool.Pool, tx pgx.Tx) error {
@@ -119,17 +125,32 @@ func (u *User) AddAdminUser(ctx context.Context, pool *pgxpool.Pool, tx pgx.Tx)
 		return fmt.Errorf("error hashing password: %v", err)
 	}
 
-	// User creating
-	var userID int
-	err = pool.QueryRow(ctx, `
-		INSERT INTO goadmin_users (username, password, role_id)
-		VALUES ($1, $2, (SELECT id FROM goadmin_roles WHERE slug = 'admin'))
-		RETURNING id
-	`, u.Username, string(hashedPassword)).Scan(&userID)
+	// the administrator role is seeded by the admin migrations (migrations/admin)
+	var roleID int
+	err = pool.QueryRow(ctx, `SELECT id FROM goadmin_roles WHERE slug = 'administrator'`).Scan(&roleID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("GoAdmin role 'administrator' is missing, run `migrate up admin`")
+	}
 	if err != nil {
-		return fmt.Errorf("error creating admin user: %w", err)
+		return fmt.Errorf("error loading the administrator role: %w", err)
 	}
-	return nil
+
+	// User creating
+	return pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var userID int
+		err := tx.QueryRow(ctx, `
+			INSERT INTO goadmin_users (username, password, name)
+			VALUES ($1, $2, $1)
+			RETURNING id
+		`, u.Username, string(hashedPassword)).Scan(&userID)
+		if err != nil {
+			return fmt.Errorf("error creating admin user: %w", err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO goadmin_role_users (role_id, user_id) VALUES ($1, $2)`, roleID, userID); err != nil {
+			return fmt.Errorf("error giving the admin user its role: %w", err)
+		}
+		return nil
+	})
 }
 
 // func getQueryRow(ctx context.Context, tx pgx.Tx, query string, args ...interface{}) pgx.Row {
@@ -157,28 +178,16 @@ func (u *User) AddAdminUser(ctx context.Context, pool *pgxpool.Pool, tx pgx.Tx)
 // 	return nil
 // }
 
-func (u *User) DeleteByID(ctx context.Context, tx pgx.Tx, userID int) (err error) {
-	query := `delete from users where id = $1`
-	if tx != nil {
-		_, err = tx.Exec(ctx, query, userID)
-	} else {
-		_, err = Dbpool.Exec(ctx, query, userID)
-	}
-	if err != nil {
-		return err
-	}
-	return nil
+// Deprecated: use UserStore.DeleteUser
+func (u *User) DeleteByID(ctx context.Context, tx pgx.Tx, userID int) error {
+	return NewPostgresUserStore(db(tx), nil).DeleteUser(ctx, userID)
 }
 
-func (u *User) UpdateByID(ctx context.Context, tx pgx.Tx) (err error) {
-	query := `update users set username = $1, email = $2, password = $3 where id = $4`
-	if tx != nil {
-		_, err = tx.Exec(ctx, query, u.Username, u.Email, u.Password, u.ID)
-	} else {
-		_, err = Dbpool.Exec(ctx, query, u.Username, u.Email, u.Password, u.ID)
-	}
-	if err != nil {
-		return err
-	}
-	return nil
+// UpdateByID saves username, email and password of the user with u.ID.
+//
+// Deprecated: use UserStore.UpdateUser
+func (u *User) UpdateByID(ctx context.Context, tx pgx.Tx) error {
+	_, err := NewPostgresUserStore(db(tx), nil).UpdateUser(ctx, u.ID,
+		UserPatch{Username: &u.Username, Email: &u.Email, Password: &u.Password})
+	return err
 }
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("username or email is already taken")
+	// UpdateUser tells which one is taken
+	ErrUsernameTaken = errors.New("this username already exists")
+	ErrEmailTaken    = errors.New("this email already exists")
+	// the user was changed since the caller read it
+	ErrVersionConflict = errors.New("the user was changed by someone else")
+	// CancelDeletion of an account that is not pending deletion
+	ErrNotPendingDeletion = errors.New("the account is not pending deletion")
+	// ListUsers by email with the emails encrypted, the order would be the one of the ciphertext
+	ErrEncryptedSort = errors.New("can't sort by the encrypted emails")
+)
+
+// UserStore is every operation on user accounts.
+// PostgresUserStore is the real one, MemoryUserStore keeps the users in memory for tests.
+// Both pass the same suite, tests/storetest.
+type UserStore interface {
+	// CreateUser inserts the user with its roles (DefaultRole if there are none)
+	// and sets ID and CreatedAt. ErrUserExists, ErrRoleNotFound
+	CreateUser(ctx context.Context, u *User) error
+	// ErrUserNotFound for the Get methods, deleted users are not found by any method but ListUsers
+	GetUser(ctx context.Context, id int) (User, error)
+	GetUserByUsername(ctx context.Context, username string) (User, error)
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	// UserExists is true if the username or the email is taken
+	UserExists(ctx context.Context, username, email string) (bool, error)
+	// ListUsers returns one page of the matching users (all of them without a limit)
+	// starting after f.Cursor. ErrInvalidCursor, ErrEncryptedSort
+	ListUsers(ctx context.Context, f UserFilter) (UserPage, error)
+	// SearchUsers returns up to limit users whose username or email is like the query,
+	// the closest first, see userstore_search.go
+	SearchUsers(ctx context.Context, query string, limit int) ([]UserMatch, error)
+	// UpdateUser changes the fields set in the patch at once and returns the updated user.
+	// ErrUserNotFound, ErrUsernameTaken, ErrEmailTaken, ErrVersionConflict
+	UpdateUser(ctx context.Context, id int, p UserPatch) (User, error)
+	// SetUserDisabled disables or enables the account, a pending deletion stays pending.
+	// Every change below increments the version like UpdateUser.
+	SetUserDisabled(ctx context.Context, id int, disabled bool) error
+	// SetUserRoles replaces the roles assigned to the user directly. ErrUserNotFound, ErrRoleNotFound
+	SetUserRoles(ctx context.Context, id int, roles []string) error
+	// RequestDeletion makes the account pending deletion, PurgeUsers deletes it later.
+	// by is who asks, the user itself or an admin. Asking again keeps the first time,
+	// and the admin once an admin asked.
+	RequestDeletion(ctx context.Context, id, by int) error
+	// CancelDeletion makes a pending account active (or disabled) again. ErrNotPendingDeletion
+	CancelDeletion(ctx context.Context, id int) error
+	// PurgeUsers deletes the accounts pending deletion for longer than grace, or anonymizes
+	// them (StatusDeleted, no roles and attributes), and returns their ids
+	PurgeUsers(ctx context.Context, grace time.Duration, anonymize bool) ([]int, error)
+	// EraseUser anonymizes the user right away like PurgeUsers, whatever its state
+	EraseUser(ctx context.Context, id int) error
+	// DeleteUser deletes the user right away
+	DeleteUser(ctx context.Context, id int) error
+}
+
+// UserPatch is a partial update of a user, nil fields stay as they are
+type UserPatch struct {
+	Username *string
+	Email    *string
+	// the hash, not the password
+	Password *string
+	// the version the caller read, 0 skips the check
+	Version int
+}
+
+func (p UserPatch) Empty() bool {
+	return p.Username == nil && p.Email == nil && p.Password == nil
+}
+
+// UserFilter selects a page of users
+type UserFilter struct {
+	// part of the username or email, case insensitive
+	Search string
+	// only users with this role assigned directly
+	Role string
+	// nil for both
+	Disabled *bool
+	// only users in this state, every state but StatusDeleted when empty
+	Status AccountStatus
+	// created in [CreatedFrom, CreatedTo), nil for no bound
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// the part after @ of the email, case insensitive
+	EmailDomain string
+	// one of UserSortColumns, id when empty
+	Sort string
+	Desc bool
+	// 0 for no limit
+	Limit int
+	// UserPage.Next of the previous page, empty for the first one
+	Cursor string
+	// skips this many users after the cursor. The skipped rows are read all the same,
+	// only the GoAdmin table pages by number, the APIs use the cursors.
+	Offset int
+	// CountTotal fills UserPage.Total, it costs a count of every matching user
+	CountTotal bool
+}
+
+// UserPage is what ListUsers returns
+type UserPage struct {
+	Users []User
+	// the cursor of the next page, empty on the last one
+	Next string
+	// the number of all matching users, with UserFilter.CountTotal only
+	Total *int
+}
+
+// columns the users can be sorted by, email only while the emails are in plaintext
+var UserSortColumns = map[string]string{
+	"id":         "id",
+	"username":   "lower(username)",
+	"email":      "lower(email)",
+	"created_at": "created_at",
+}
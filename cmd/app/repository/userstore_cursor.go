@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// userCursor is where a page of ListUsers ended: the sort key and the id of its last user,
+// the next page starts after them (keyset pagination). The callers get it base64 encoded
+// and hand it back as it is.
+type userCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d,omitempty"`
+	// the sort key as the store compares it, empty when sorted by id
+	Key string `json:"k,omitempty"`
+	ID  int    `json:"i"`
+}
+
+// userSort is the column of f.Sort, id for an unknown one
+func userSort(f UserFilter) string {
+	if _, ok := UserSortColumns[f.Sort]; ok {
+		return f.Sort
+	}
+	return "id"
+}
+
+func (c userCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor is the cursor of f, nil for the first page.
+// A cursor only goes with the sort order it was made for.
+func decodeCursor(f UserFilter) (*userCursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+	var c userCursor
+	data, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil || json.Unmarshal(data, &c) != nil || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != userSort(f) || c.Desc != f.Desc {
+		return nil, fmt.Errorf("%w: it is for another sort order", ErrInvalidCursor)
+	}
+	return &c, nil
+}
@@ -0,0 +1,426 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryUserStore keeps the users in a map, for tests of the handlers without a database.
+// It knows the roles DefaultRole and admin, AddRole adds more.
+type MemoryUserStore struct {
+	mu     sync.RWMutex
+	users  map[int]User
+	roles  map[string]bool
+	nextID int
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users:  make(map[int]User),
+		roles:  map[string]bool{DefaultRole: true, "admin": true},
+		nextID: 1,
+	}
+}
+
+func (s *MemoryUserStore) AddRole(name string) {
+	s.mu.Lock()
+	s.roles[name] = true
+	s.mu.Unlock()
+}
+
+// the callers get copies, changing them doesn't change the store
+func copyUser(u User) User {
+	u.Roles = append([]string{}, u.Roles...)
+	if u.CreatedAt != nil {
+		t := *u.CreatedAt
+		u.CreatedAt = &t
+	}
+	if u.DisabledAt != nil {
+		t := *u.DisabledAt
+		u.DisabledAt = &t
+	}
+	if u.DeletionRequestedAt != nil {
+		t := *u.DeletionRequestedAt
+		u.DeletionRequestedAt = &t
+	}
+	if u.DeletionRequestedBy != nil {
+		by := *u.DeletionRequestedBy
+		u.DeletionRequestedBy = &by
+	}
+	return u
+}
+
+// get is the user unless it was deleted
+func (s *MemoryUserStore) get(id int) (User, bool) {
+	u, ok := s.users[id]
+	return u, ok && u.Status != StatusDeleted
+}
+
+func (s *MemoryUserStore) CreateUser(ctx context.Context, u *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(u.Roles) == 0 {
+		u.Roles = []string{DefaultRole}
+	}
+	if err := s.checkRoles(u.Roles); err != nil {
+		return err
+	}
+	if s.taken(u.Username, u.Email, 0) {
+		return ErrUserExists
+	}
+	u.ID = s.nextID
+	u.Version = 1
+	u.Status = StatusActive
+	s.nextID++
+	now := time.Now()
+	u.CreatedAt = &now
+	stored := copyUser(*u)
+	stored.Roles = sortedRoles(u.Roles)
+	s.users[u.ID] = stored
+	return nil
+}
+
+func (s *MemoryUserStore) GetUser(ctx context.Context, id int) (User, error) {
+	return s.find(func(u User) bool { return u.ID == id })
+}
+
+func (s *MemoryUserStore) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	return s.find(func(u User) bool { return u.Username == username })
+}
+
+func (s *MemoryUserStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	return s.find(func(u User) bool { return u.Email == email })
+}
+
+func (s *MemoryUserStore) find(match func(User) bool) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.Status != StatusDeleted && match(u) {
+			return copyUser(u), nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (s *MemoryUserStore) UserExists(ctx context.Context, username, email string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.Status != StatusDeleted && (u.Username == username || u.Email == email) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// taken by another user than id, deleted ones too like the unique constraints.
+// Empty values are not compared.
+func (s *MemoryUserStore) taken(username, email string, id int) bool {
+	for _, u := range s.users {
+		if u.ID != id && ((username != "" && u.Username == username) || (email != "" && u.Email == email)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryUserStore) ListUsers(ctx context.Context, f UserFilter) (UserPage, error) {
+	cursor, err := decodeCursor(f)
+	if err != nil {
+		return UserPage{}, err
+	}
+	s.mu.RLock()
+	var matching []User
+	search := strings.ToLower(f.Search)
+	domain := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(f.EmailDomain), "@"))
+	for _, u := range s.users {
+		if search != "" && !strings.Contains(strings.ToLower(u.Username), search) &&
+			!strings.Contains(strings.ToLower(u.Email), search) {
+			continue
+		}
+		if f.Role != "" && !containsString(u.Roles, f.Role) {
+			continue
+		}
+		if f.Disabled != nil && *f.Disabled != (u.DisabledAt != nil) {
+			continue
+		}
+		if (f.Status == "" && u.Status == StatusDeleted) || (f.Status != "" && u.Status != f.Status) {
+			continue
+		}
+		if (f.CreatedFrom != nil && u.CreatedAt.Before(*f.CreatedFrom)) || (f.CreatedTo != nil && !u.CreatedAt.Before(*f.CreatedTo)) {
+			continue
+		}
+		if domain != "" && emailDomain(u.Email) != domain {
+			continue
+		}
+		matching = append(matching, copyUser(u))
+	}
+	s.mu.RUnlock()
+
+	by := userSort(f)
+	// by the sort key and then the id, both in the direction of f.Desc
+	compare := func(aKey string, aID int, bKey string, bID int) int {
+		c := compareSortKeys(by, aKey, bKey)
+		if c == 0 {
+			c = aID - bID
+		}
+		if f.Desc {
+			return -c
+		}
+		return c
+	}
+	slices.SortFunc(matching, func(a, b User) int {
+		return compare(memorySortKey(by, a), a.ID, memorySortKey(by, b), b.ID)
+	})
+
+	var page UserPage
+	if f.CountTotal {
+		total := len(matching)
+		page.Total = &total
+	}
+	start := 0
+	if cursor != nil {
+		for start < len(matching) && compare(memorySortKey(by, matching[start]), matching[start].ID, cursor.Key, cursor.ID) <= 0 {
+			start++
+		}
+	}
+	start = min(start+f.Offset, len(matching))
+	end := len(matching)
+	if f.Limit > 0 && start+f.Limit < end {
+		end = start + f.Limit
+		last := matching[end-1]
+		page.Next = userCursor{Sort: by, Desc: f.Desc, Key: memorySortKey(by, last), ID: last.ID}.encode()
+	}
+	page.Users = append([]User{}, matching[start:end]...)
+	return page, nil
+}
+
+// memorySortKey is the key of u in the cursors of the memory store
+func memorySortKey(by string, u User) string {
+	switch by {
+	case "username":
+		return strings.ToLower(u.Username)
+	case "email":
+		return strings.ToLower(u.Email)
+	case "created_at":
+		return u.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return ""
+}
+
+func compareSortKeys(by, a, b string) int {
+	if by == "created_at" {
+		ta, _ := time.Parse(time.RFC3339Nano, a)
+		tb, _ := time.Parse(time.RFC3339Nano, b)
+		return ta.Compare(tb)
+	}
+	return strings.Compare(a, b)
+}
+
+func (s *MemoryUserStore) UpdateUser(ctx context.Context, id int, p UserPatch) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.get(id)
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	if p.Version != 0 && u.Version != p.Version {
+		return User{}, ErrVersionConflict
+	}
+	if p.Empty() {
+		return copyUser(u), nil
+	}
+	if p.Username != nil {
+		if s.taken(*p.Username, "", id) {
+			return User{}, ErrUsernameTaken
+		}
+		u.Username = *p.Username
+	}
+	if p.Email != nil {
+		if s.taken("", *p.Email, id) {
+			return User{}, ErrEmailTaken
+		}
+		u.Email = *p.Email
+	}
+	if p.Password != nil {
+		u.Password = *p.Password
+	}
+	u.Version++
+	s.users[id] = u
+	return copyUser(u), nil
+}
+
+func (s *MemoryUserStore) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.get(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	switch {
+	case !disabled:
+		u.DisabledAt = nil
+	case u.DisabledAt == nil:
+		now := time.Now()
+		u.DisabledAt = &now
+	}
+	if u.Status != StatusPendingDeletion {
+		u.Status = StatusActive
+		if disabled {
+			u.Status = StatusDisabled
+		}
+	}
+	u.Version++
+	s.users[id] = u
+	return nil
+}
+
+func (s *MemoryUserStore) SetUserRoles(ctx context.Context, id int, roles []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.get(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := s.checkRoles(roles); err != nil {
+		return err
+	}
+	u.Roles = sortedRoles(roles)
+	u.Version++
+	s.users[id] = u
+	return nil
+}
+
+func (s *MemoryUserStore) RequestDeletion(ctx context.Context, id, by int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.get(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if u.Status != StatusPendingDeletion || u.SelfDeletion() || u.DeletionRequestedBy == nil {
+		u.DeletionRequestedBy = &by
+	}
+	u.Status = StatusPendingDeletion
+	if u.DeletionRequestedAt == nil {
+		now := time.Now()
+		u.DeletionRequestedAt = &now
+	}
+	u.Version++
+	s.users[id] = u
+	return nil
+}
+
+func (s *MemoryUserStore) CancelDeletion(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.get(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if u.Status != StatusPendingDeletion {
+		return ErrNotPendingDeletion
+	}
+	u.Status = StatusActive
+	if u.DisabledAt != nil {
+		u.Status = StatusDisabled
+	}
+	u.DeletionRequestedAt, u.DeletionRequestedBy = nil, nil
+	u.Version++
+	s.users[id] = u
+	return nil
+}
+
+func (s *MemoryUserStore) PurgeUsers(ctx context.Context, grace time.Duration, anonymize bool) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []int{}
+	for id, u := range s.users {
+		if u.Status != StatusPendingDeletion || time.Since(*u.DeletionRequestedAt) <= grace {
+			continue
+		}
+		ids = append(ids, id)
+		if !anonymize {
+			delete(s.users, id)
+			continue
+		}
+		s.users[id] = anonymized(u)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (s *MemoryUserStore) EraseUser(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.get(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	s.users[id] = anonymized(u)
+	return nil
+}
+
+// anonymized is u as StatusDeleted, without anything personal
+func anonymized(u User) User {
+	u.Status = StatusDeleted
+	u.Username = fmt.Sprintf("deleted-%d", u.ID)
+	u.Email = fmt.Sprintf("deleted-%d@invalid", u.ID)
+	u.Password = ""
+	u.Roles = []string{}
+	u.DisabledAt, u.DeletionRequestedAt, u.DeletionRequestedBy = nil, nil, nil
+	u.Version++
+	return u
+}
+
+func (s *MemoryUserStore) DeleteUser(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
+
+func (s *MemoryUserStore) checkRoles(roles []string) error {
+	var missing []string
+	for _, name := range roles {
+		if !s.roles[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrRoleNotFound, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// without duplicates and sorted by name, like the roles column of selectUser
+func sortedRoles(roles []string) []string {
+	set := make(map[string]bool, len(roles))
+	sorted := []string{}
+	for _, r := range roles {
+		if !set[r] {
+			set[r] = true
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
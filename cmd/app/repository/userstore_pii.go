@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"AuthDB/internal/pii"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrNoPIIKey = errors.New("the email is encrypted but PII_KEY_FILE is not set")
+
+// sealedEmail is an email as it is stored: the ciphertext, the blind indexes of the address
+// and of its domain and the wrapped data key of the row. Without a cipher it is the plaintext
+// and the rest is nil.
+type sealedEmail struct {
+	value       string
+	index       []byte
+	domainIndex []byte
+	dataKey     []byte
+	keyID       *string
+}
+
+func (s *PostgresUserStore) seal(email string) (sealedEmail, error) {
+	if s.cipher == nil {
+		return sealedEmail{value: email}, nil
+	}
+	key, err := s.cipher.NewDataKey()
+	if err != nil {
+		return sealedEmail{}, err
+	}
+	value, err := key.Encrypt("email", email)
+	if err != nil {
+		return sealedEmail{}, err
+	}
+	return sealedEmail{value: value, index: s.cipher.Index(email), domainIndex: s.domainIndex(emailDomain(email)),
+		dataKey: key.Wrapped, keyID: &key.KeyID}, nil
+}
+
+// open decrypts the email of a user read from the table, plaintext stays as it is
+func (s *PostgresUserStore) open(u *User) error {
+	if !pii.IsEncrypted(u.Email) {
+		return nil
+	}
+	if s.cipher == nil {
+		return ErrNoPIIKey
+	}
+	if u.keyID == nil {
+		return fmt.Errorf("user %d has an encrypted email without a data key", u.ID)
+	}
+	key, err := s.cipher.DataKey(*u.keyID, u.dataKey)
+	if err != nil {
+		return err
+	}
+	u.Email, err = key.Decrypt("email", u.Email)
+	return err
+}
+
+// index is the blind index of the email, nil without a cipher (it matches nothing then)
+func (s *PostgresUserStore) index(email string) []byte {
+	if s.cipher == nil {
+		return nil
+	}
+	return s.cipher.Index(email)
+}
+
+// domainIndex is the blind index of an email domain for UserFilter.EmailDomain,
+// nil without a cipher
+func (s *PostgresUserStore) domainIndex(domain string) []byte {
+	if s.cipher == nil {
+		return nil
+	}
+	return s.cipher.Index(domain)
+}
+
+// emailDomain is the part after the last @
+func emailDomain(email string) string {
+	return strings.ToLower(strings.TrimSpace(email[strings.LastIndex(email, "@")+1:]))
+}
+
+// RotatePII encrypts up to batch rows that are still plaintext or use another key-encryption key
+// than the current one (or were encrypted before the domain index), with a new data key each. It returns how many rows it did,
+// 0 once every row uses the current key. The rows are locked, several runs can share the work.
+func (s *PostgresUserStore) RotatePII(ctx context.Context, batch int) (int, error) {
+	if s.cipher == nil {
+		return 0, errors.New("RotatePII needs a store with a cipher")
+	}
+	var done int
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		done = 0
+		rows, err := s.q(ctx).Query(ctx, `select id, email, data_key, key_id from users
+			where status <> 'deleted' and (key_id is distinct from $1 or email_domain_index is null)
+			order by id limit $2 for update skip locked`, s.cipher.CurrentKeyID(), batch)
+		if err != nil {
+			return err
+		}
+		var users []User
+		for rows.Next() {
+			var u User
+			if err := rows.Scan(&u.ID, &u.Email, &u.dataKey, &u.keyID); err != nil {
+				rows.Close()
+				return err
+			}
+			users = append(users, u)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, u := range users {
+			if err := s.open(&u); err != nil {
+				return err
+			}
+			email, err := s.seal(u.Email)
+			if err != nil {
+				return err
+			}
+			// not a change of the user, the version stays
+			_, err = s.q(ctx).Exec(ctx, `update users set email = $2, email_index = $3, data_key = $4, key_id = $5,
+				email_domain_index = $6 where id = $1`, u.ID, email.value, email.index, email.dataKey, email.keyID, email.domainIndex)
+			if err != nil {
+				return mapTaken(err)
+			}
+			done++
+		}
+		return nil
+	})
+	return done, err
+}
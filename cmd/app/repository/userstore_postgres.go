@@ -0,0 +1,484 @@
+package repository
+
+import (
+	"AuthDB/internal/pii"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// PostgresUserStore keeps the users in the users table.
+// db is the pool, or a transaction when the calls must be atomic.
+// Called with the ctx of WithTx, the store runs in that transaction instead.
+// With a cipher the emails are encrypted, see userstore_pii.go.
+type PostgresUserStore struct {
+	db     DB
+	cipher *pii.Cipher
+}
+
+// DB is the pool or a transaction
+type DB interface {
+	Querier
+	TxBeginner
+}
+
+// cipher is nil to keep the emails in plaintext, main loads it from PII_KEY_FILE
+func NewPostgresUserStore(db DB, cipher *pii.Cipher) *PostgresUserStore {
+	return &PostgresUserStore{db: db, cipher: cipher}
+}
+
+// WithTx runs fn in a transaction, the store called with the ctx fn gets runs in it
+func (s *PostgresUserStore) WithTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	return WithTx(ctx, s.db, fn, opts...)
+}
+
+// the transaction of WithTx if there is one
+func (s *PostgresUserStore) q(ctx context.Context) Querier {
+	if tx := TxFromContext(ctx); tx != nil {
+		return tx
+	}
+	return s.db
+}
+
+// the querier of the read-only queries: the transaction of WithTx, a replica when db is a Cluster
+// or db. The reads of the methods that write go through q.
+func (s *PostgresUserStore) r(ctx context.Context) Querier {
+	if tx := TxFromContext(ctx); tx != nil {
+		return tx
+	}
+	if c, ok := s.db.(reader); ok {
+		return c.Reader(ctx)
+	}
+	return s.db
+}
+
+// the fields of userColumns
+func userFields(u *User) []interface{} {
+	return []interface{}{&u.ID, &u.Username, &u.Email, &u.Password, &u.Roles, &u.CreatedAt, &u.DisabledAt,
+		&u.Version, &u.Status, &u.DeletionRequestedAt, &u.DeletionRequestedBy, &u.dataKey, &u.keyID}
+}
+
+// scanUser reads the user and decrypts its email
+func (s *PostgresUserStore) scanUser(row pgx.Row, u *User) error {
+	if err := row.Scan(userFields(u)...); err != nil {
+		return err
+	}
+	return s.open(u)
+}
+
+// deleted users are never found
+func (s *PostgresUserStore) getUser(ctx context.Context, where string, args ...interface{}) (u User, err error) {
+	err = s.scanUser(s.r(ctx).QueryRow(ctx, selectUser+" where status <> 'deleted' and "+where, args...), &u)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return u, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (s *PostgresUserStore) CreateUser(ctx context.Context, u *User) error {
+	if len(u.Roles) == 0 {
+		u.Roles = []string{DefaultRole}
+	}
+	if err := s.checkRoles(ctx, u.Roles); err != nil {
+		return err
+	}
+	email, err := s.seal(u.Email)
+	if err != nil {
+		return err
+	}
+	var createdAt time.Time
+	err = s.q(ctx).QueryRow(ctx, `with new_user as (
+			insert into users (username, email, password, email_index, data_key, key_id, email_domain_index)
+			values ($1, $2, $3, $5, $6, $7, $8) returning id, created_at, version
+		), assigned as (
+			insert into user_roles (user_id, role_id)
+			select new_user.id, roles.id from new_user, roles where roles.name = any($4)
+		)
+		select id, created_at, version from new_user`,
+		u.Username, email.value, u.Password, u.Roles, email.index, email.dataKey, email.keyID,
+		email.domainIndex).Scan(&u.ID, &createdAt, &u.Version)
+	if err != nil {
+		return mapUniqueUser(err)
+	}
+	u.CreatedAt = &createdAt
+	u.Status = StatusActive
+	return nil
+}
+
+func (s *PostgresUserStore) GetUser(ctx context.Context, id int) (User, error) {
+	return s.getUser(ctx, "id = $1", id)
+}
+
+func (s *PostgresUserStore) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	return s.getUser(ctx, "username = $1", username)
+}
+
+func (s *PostgresUserStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	return s.getUser(ctx, "(email = $1 or email_index = $2)", email, s.index(email))
+}
+
+// UserExists reads the primary, it decides if a username or an email can be taken
+func (s *PostgresUserStore) UserExists(ctx context.Context, username, email string) (bool, error) {
+	var exists bool
+	err := s.q(ctx).QueryRow(ctx,
+		`select exists (select 1 from users where status <> 'deleted' and (username = $1 or email = $2 or email_index = $3))`,
+		username, email, s.index(email)).Scan(&exists)
+	return exists, err
+}
+
+func (s *PostgresUserStore) ListUsers(ctx context.Context, f UserFilter) (UserPage, error) {
+	cursor, err := decodeCursor(f)
+	if err != nil {
+		return UserPage{}, err
+	}
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if f.Search != "" {
+		pattern := arg("%" + escapeLike(f.Search) + "%")
+		if s.cipher == nil {
+			where = append(where, "(username ilike "+pattern+" or email ilike "+pattern+")")
+		} else {
+			// encrypted emails are only found by the whole address
+			where = append(where, "(username ilike "+pattern+" or email_index = "+arg(s.index(f.Search))+")")
+		}
+	}
+	if f.Role != "" {
+		where = append(where, `exists (select 1 from user_roles ur join roles r on r.id = ur.role_id
+			where ur.user_id = users.id and r.name = `+arg(f.Role)+`)`)
+	}
+	if f.Disabled != nil {
+		if *f.Disabled {
+			where = append(where, "disabled_at is not null")
+		} else {
+			where = append(where, "disabled_at is null")
+		}
+	}
+	if f.Status != "" {
+		where = append(where, "status = "+arg(f.Status))
+	} else {
+		where = append(where, "status <> 'deleted'")
+	}
+	if f.CreatedFrom != nil {
+		where = append(where, "created_at >= "+arg(*f.CreatedFrom))
+	}
+	if f.CreatedTo != nil {
+		where = append(where, "created_at < "+arg(*f.CreatedTo))
+	}
+	if f.EmailDomain != "" {
+		domain := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(f.EmailDomain), "@"))
+		// the plaintext rows by the address, the encrypted ones by the blind index
+		where = append(where, "(lower(split_part(email, '@', 2)) = "+arg(domain)+
+			" or email_domain_index = "+arg(s.domainIndex(domain))+")")
+	}
+
+	// the count and the page from the same replica
+	db := s.r(ctx)
+	var page UserPage
+	if f.CountTotal {
+		var total int
+		err := db.QueryRow(ctx, `select count(*) from users where `+strings.Join(where, " and "), args...).Scan(&total)
+		if err != nil {
+			return UserPage{}, err
+		}
+		page.Total = &total
+	}
+
+	by := userSort(f)
+	if by == "email" && s.cipher != nil {
+		return UserPage{}, ErrEncryptedSort
+	}
+	key := UserSortColumns[by]
+	order, after := " asc", " > "
+	if f.Desc {
+		order, after = " desc", " < "
+	}
+	if cursor != nil {
+		if by == "id" {
+			where = append(where, "id"+after+arg(cursor.ID))
+		} else {
+			where = append(where, "("+key+", id)"+after+"("+arg(cursor.Key)+"::"+userSortTypes[by]+", "+arg(cursor.ID)+")")
+		}
+	}
+	// id keeps the order stable between pages
+	query := `select ` + userColumns + `, (` + key + `)::text from users where ` + strings.Join(where, " and ") +
+		` order by ` + key + order + `, id` + order
+	// one more tells if there is a next page
+	if f.Limit > 0 {
+		query += " limit " + arg(f.Limit+1)
+	}
+	if f.Offset > 0 {
+		query += " offset " + arg(f.Offset)
+	}
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return UserPage{}, err
+	}
+	defer rows.Close()
+
+	page.Users = []User{}
+	var keys []string
+	for rows.Next() {
+		var u User
+		var key string
+		if err := rows.Scan(append(userFields(&u), &key)...); err != nil {
+			return UserPage{}, err
+		}
+		if err := s.open(&u); err != nil {
+			return UserPage{}, err
+		}
+		page.Users = append(page.Users, u)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return UserPage{}, err
+	}
+	if f.Limit > 0 && len(page.Users) > f.Limit {
+		page.Users = page.Users[:f.Limit]
+		last := page.Users[f.Limit-1]
+		next := userCursor{Sort: by, Desc: f.Desc, ID: last.ID}
+		if by != "id" {
+			next.Key = keys[f.Limit-1]
+		}
+		page.Next = next.encode()
+	}
+	return page, nil
+}
+
+// the types of UserSortColumns, the cursor keys are cast back to them
+var userSortTypes = map[string]string{
+	"username":   "text",
+	"email":      "text",
+	"created_at": "timestamp",
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (s *PostgresUserStore) UpdateUser(ctx context.Context, id int, p UserPatch) (User, error) {
+	if p.Empty() {
+		u, err := s.GetUser(ctx, id)
+		if err == nil && p.Version != 0 && u.Version != p.Version {
+			return u, ErrVersionConflict
+		}
+		return u, err
+	}
+	// a new email gets a new data key
+	var email sealedEmail
+	var newEmail *string
+	if p.Email != nil {
+		var err error
+		if email, err = s.seal(*p.Email); err != nil {
+			return User{}, err
+		}
+		newEmail = &email.value
+	}
+	var u User
+	err := s.scanUser(s.q(ctx).QueryRow(ctx, `update users set
+			username = coalesce($2, username), password = coalesce($4, password),
+			email = coalesce($3, email),
+			email_index = case when $3::text is null then email_index else $6 end,
+			data_key = case when $3::text is null then data_key else $7 end,
+			key_id = case when $3::text is null then key_id else $8 end,
+			email_domain_index = case when $3::text is null then email_domain_index else $9 end,
+			version = version + 1
+		where id = $1 and status <> 'deleted' and ($5::int = 0 or version = $5)
+		returning `+userColumns,
+		id, p.Username, newEmail, p.Password, p.Version, email.index, email.dataKey, email.keyID, email.domainIndex), &u)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// a user that is still there has a newer version
+		if _, err := s.GetUser(ctx, id); err != nil {
+			return u, err
+		}
+		return u, ErrVersionConflict
+	}
+	if err != nil {
+		return u, mapTaken(err)
+	}
+	return u, nil
+}
+
+func (s *PostgresUserStore) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	query := `update users set disabled_at = null, version = version + 1,
+			status = case when status = 'pending_deletion' then status else 'active' end
+		where id = $1 and status <> 'deleted'`
+	if disabled {
+		query = `update users set disabled_at = coalesce(disabled_at, CURRENT_TIMESTAMP), version = version + 1,
+				status = case when status = 'pending_deletion' then status else 'disabled' end
+			where id = $1 and status <> 'deleted'`
+	}
+	tag, err := s.q(ctx).Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *PostgresUserStore) SetUserRoles(ctx context.Context, id int, roles []string) error {
+	return s.WithTx(ctx, func(ctx context.Context) error {
+		// locks the user, two concurrent calls don't mix their roles
+		err := s.q(ctx).QueryRow(ctx,
+			`update users set version = version + 1 where id = $1 and status <> 'deleted' returning id`, id).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.checkRoles(ctx, roles); err != nil {
+			return err
+		}
+		_, err = s.q(ctx).Exec(ctx, `with removed as (
+				delete from user_roles where user_id = $1 and role_id not in (select id from roles where name = any($2))
+			)
+			insert into user_roles (user_id, role_id)
+			select $1, id from roles where name = any($2)
+			on conflict do nothing`, id, roles)
+		return err
+	})
+}
+
+func (s *PostgresUserStore) RequestDeletion(ctx context.Context, id, by int) error {
+	tag, err := s.q(ctx).Exec(ctx, `update users set status = 'pending_deletion',
+			deletion_requested_at = coalesce(deletion_requested_at, CURRENT_TIMESTAMP),
+			deletion_requested_by = case when status = 'pending_deletion' and deletion_requested_by <> id
+				then deletion_requested_by else $2 end,
+			version = version + 1
+		where id = $1 and status <> 'deleted'`, id, by)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *PostgresUserStore) CancelDeletion(ctx context.Context, id int) error {
+	tag, err := s.q(ctx).Exec(ctx, `update users set deletion_requested_at = null, deletion_requested_by = null, version = version + 1,
+			status = case when disabled_at is null then 'active' else 'disabled' end
+		where id = $1 and status = 'pending_deletion'`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		if _, err := s.GetUser(ctx, id); err != nil {
+			return err
+		}
+		return ErrNotPendingDeletion
+	}
+	return nil
+}
+
+func (s *PostgresUserStore) PurgeUsers(ctx context.Context, grace time.Duration, anonymize bool) ([]int, error) {
+	query := `with purged as (
+			delete from users
+			where status = 'pending_deletion' and deletion_requested_at < CURRENT_TIMESTAMP - $1::interval
+			returning id
+		)
+		select id from purged order by id`
+	if anonymize {
+		query = anonymizeUsers(`status = 'pending_deletion' and deletion_requested_at < CURRENT_TIMESTAMP - $1::interval`)
+	}
+	rows, err := s.q(ctx).Query(ctx, query, grace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *PostgresUserStore) EraseUser(ctx context.Context, id int) error {
+	var erased int
+	err := s.q(ctx).QueryRow(ctx, anonymizeUsers(`id = $1 and status <> 'deleted'`), id).Scan(&erased)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// anonymizeUsers is the query that empties the users matching where and returns their ids,
+// the row stays for what refers to it, nothing in it points to the person anymore
+func anonymizeUsers(where string) string {
+	return `with purged as (
+			update users set status = 'deleted', username = 'deleted-' || id, email = 'deleted-' || id || '@invalid',
+				password = '', disabled_at = null, deletion_requested_at = null, deletion_requested_by = null, version = version + 1,
+				email_index = null, data_key = null, key_id = null, email_domain_index = null
+			where ` + where + `
+			returning id
+		), roles as (
+			delete from user_roles where user_id in (select id from purged)
+		), attributes as (
+			delete from user_attributes where user_id in (select id from purged)
+		)
+		select id from purged order by id`
+}
+
+func (s *PostgresUserStore) DeleteUser(ctx context.Context, id int) error {
+	tag, err := s.q(ctx).Exec(ctx, `delete from users where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ErrRoleNotFound unless every name is a role
+func (s *PostgresUserStore) checkRoles(ctx context.Context, roles []string) error {
+	var missing []string
+	err := s.q(ctx).QueryRow(ctx, `select coalesce(array_agg(name), '{}') from unnest($1::text[]) as name
+		where name not in (select name from roles)`, roles).Scan(&missing)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrRoleNotFound, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// unique_violation of users_username_key or users_email_key
+func mapTaken(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch {
+		case strings.Contains(pgErr.ConstraintName, "username"):
+			return ErrUsernameTaken
+		case strings.Contains(pgErr.ConstraintName, "email"):
+			return ErrEmailTaken
+		}
+	}
+	return err
+}
+
+// unique_violation on username or email
+func mapUniqueUser(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrUserExists
+	}
+	return err
+}
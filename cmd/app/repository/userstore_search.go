@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// UserMatch is a user found by SearchUsers
+type UserMatch struct {
+	User User
+	// higher is closer, only comparable within one search
+	Rank float64
+	// the parts of the username and the email that contain a word of the query,
+	// a match by similarity alone (a typo) has none
+	Highlights []Highlight
+}
+
+// Highlight is the bytes [Start, End) of Field, "username" or "email"
+type Highlight struct {
+	Field string
+	Start int
+	End   int
+}
+
+// searchQuery is the query of SearchUsers: the whole of it in lower case and its words
+type searchQuery struct {
+	text  string
+	terms []string
+}
+
+func newSearchQuery(query string) searchQuery {
+	text := strings.ToLower(strings.Join(strings.Fields(query), " "))
+	return searchQuery{text: text, terms: searchWords(text)}
+}
+
+// searchWords splits s like the search vector does: everything but letters and digits separates
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// tsquery is every word as a prefix, 'ali:* & exa:*'. The words are letters and digits only.
+func (q searchQuery) tsquery() string {
+	prefixes := make([]string, len(q.terms))
+	for i, term := range q.terms {
+		prefixes[i] = term + ":*"
+	}
+	return strings.Join(prefixes, " & ")
+}
+
+// highlight finds the query and its words in the username and the email of u
+func (q searchQuery) highlight(u User) []Highlight {
+	words := append([]string{q.text}, q.terms...)
+	// the longest first, the alternation takes the first that matches
+	sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+	re := regexp.MustCompile("(?i)" + strings.Join(words, "|"))
+
+	highlights := []Highlight{}
+	for _, field := range []struct{ name, value string }{{"username", u.Username}, {"email", u.Email}} {
+		for _, loc := range re.FindAllStringIndex(field.value, -1) {
+			highlights = append(highlights, Highlight{Field: field.name, Start: loc[0], End: loc[1]})
+		}
+	}
+	return highlights
+}
+
+// SearchUsers ranks the users by how close their username or email is to the query:
+// the exact username or address first, then the ones starting with it, containing it,
+// having words starting with its words or similar words (pg_trgm). Deleted users are left out.
+// Encrypted emails are only found by the whole address, see userstore_pii.go.
+// No limit returns every match.
+func (s *PostgresUserStore) SearchUsers(ctx context.Context, query string, limit int) ([]UserMatch, error) {
+	q := newSearchQuery(query)
+	if q.text == "" {
+		return []UserMatch{}, nil
+	}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	text, pattern, index := arg(q.text), arg("%"+escapeLike(q.text)+"%"), arg(s.index(q.text))
+	prefix := arg(escapeLike(q.text) + "%")
+
+	// the plaintext emails only, the ciphertext is noise
+	email := "case when key_id is null then lower(email) end"
+	match := []string{
+		"lower(username) like " + pattern,
+		email + " like " + pattern,
+		text + " <% lower(username)",
+		text + " <% " + email,
+		"email_index = " + index,
+	}
+	fullText := "0"
+	if len(q.terms) > 0 {
+		tsquery := "to_tsquery('simple', " + arg(q.tsquery()) + ")"
+		match = append(match, "search_vector @@ "+tsquery)
+		fullText = "ts_rank(search_vector, " + tsquery + ")"
+	}
+	rank := `greatest(word_similarity(` + text + `, lower(username)), coalesce(word_similarity(` + text + `, ` + email + `), 0))
+		+ ` + fullText + `
+		+ case when lower(username) = ` + text + ` or ` + email + ` = ` + text + ` or email_index = ` + index + ` then 1 else 0 end
+		+ case when lower(username) like ` + prefix + ` or ` + email + ` like ` + prefix + ` then 0.5 else 0 end`
+
+	sql := `select ` + userColumns + `, ` + rank + ` as rank from users
+		where status <> 'deleted' and (` + strings.Join(match, " or ") + `)
+		order by rank desc, id`
+	if limit > 0 {
+		sql += " limit " + arg(limit)
+	}
+	rows, err := s.r(ctx).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []UserMatch{}
+	for rows.Next() {
+		var m UserMatch
+		if err := rows.Scan(append(userFields(&m.User), &m.Rank)...); err != nil {
+			return nil, err
+		}
+		if err := s.open(&m.User); err != nil {
+			return nil, err
+		}
+		m.Highlights = q.highlight(m.User)
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// SearchUsers of the memory store ranks like the Postgres one, with a simpler similarity
+func (s *MemoryUserStore) SearchUsers(ctx context.Context, query string, limit int) ([]UserMatch, error) {
+	q := newSearchQuery(query)
+	matches := []UserMatch{}
+	if q.text == "" {
+		return matches, nil
+	}
+	s.mu.RLock()
+	for _, u := range s.users {
+		if u.Status == StatusDeleted {
+			continue
+		}
+		username, email := strings.ToLower(u.Username), strings.ToLower(u.Email)
+		similarity := max(wordSimilarity(q.text, username), wordSimilarity(q.text, email))
+		words := searchWords(username + " " + email)
+		prefixed := 0
+		for _, term := range q.terms {
+			if slices.ContainsFunc(words, func(w string) bool { return strings.HasPrefix(w, term) }) {
+				prefixed++
+			}
+		}
+		allPrefixed := len(q.terms) > 0 && prefixed == len(q.terms)
+		contains := strings.Contains(username, q.text) || strings.Contains(email, q.text)
+		if !contains && !allPrefixed && similarity < wordSimilarityThreshold {
+			continue
+		}
+
+		rank := similarity
+		if allPrefixed {
+			rank += 0.1
+		}
+		if username == q.text || email == q.text {
+			rank++
+		}
+		if strings.HasPrefix(username, q.text) || strings.HasPrefix(email, q.text) {
+			rank += 0.5
+		}
+		m := UserMatch{User: copyUser(u), Rank: rank}
+		m.Highlights = q.highlight(m.User)
+		matches = append(matches, m)
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(matches, func(a, b UserMatch) int {
+		if a.Rank != b.Rank {
+			if a.Rank > b.Rank {
+				return -1
+			}
+			return 1
+		}
+		return a.User.ID - b.User.ID
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// pg_trgm.word_similarity_threshold, the default of Postgres
+const wordSimilarityThreshold = 0.6
+
+// wordSimilarity is roughly word_similarity of pg_trgm: the share of the trigrams of query
+// found in the closest word of s
+func wordSimilarity(query, s string) float64 {
+	want := trigrams(query)
+	if len(want) == 0 {
+		return 0
+	}
+	best := 0.0
+	for _, word := range searchWords(s) {
+		have := trigrams(word)
+		shared := 0
+		for t := range want {
+			if have[t] {
+				shared++
+			}
+		}
+		best = max(best, float64(shared)/float64(len(want)))
+	}
+	return best
+}
+
+// trigrams of every word padded like pg_trgm does, "  a", " ab", "abc", "bc "
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range searchWords(s) {
+		r := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			set[string(r[i:i+3])] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Ping reports whether at least one of the brokers accepts connections,
+// used by the readiness checks
+func Ping(brokers []string, timeout time.Duration) error {
+	if len(brokers) == 0 {
+		return errors.New("kafka: no brokers configured")
+	}
+	cfg := sarama.NewConfig()
+	cfg.Net.DialTimeout = timeout
+	cfg.Net.ReadTimeout = timeout
+	cfg.Net.WriteTimeout = timeout
+
+	var lastErr error
+	for _, addr := range brokers {
+		broker := sarama.NewBroker(addr)
+		if err := broker.Open(cfg); err != nil {
+			lastErr = err
+			continue
+		}
+		// Open is asynchronous, Connected waits for the dial
+		ok, err := broker.Connected()
+		broker.Close()
+		if ok {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%s: not connected", addr)
+		}
+		lastErr = err
+	}
+	return fmt.Errorf("kafka: no broker reachable: %w", lastErr)
+}
@@ -39,6 +39,8 @@ var (
 	Consumer ConsumerInterface
 	Brokers  = []string{"localhost:9092"}
 	Topic    = "authdb-topic"
+	// the cluster InitKafka connects to
+	ClusterBrokers = []string{"kafka-1:9092", "kafka-2:9093"}
 )
 
 // The struct into which we will record Kafka's message
@@ -50,7 +52,7 @@ func InitKafka() (producer sarama.SyncProducer, consumer sarama.Consumer, err er
 	// Producer initialization
 	producercfg := sarama.NewConfig()
 	producercfg.Producer.Return.Successes = true
-	Producer1, err := sarama.NewSyncProducer([]string{"kafka-1:9092", "kafka-2:9093"}, producercfg)
+	Producer1, err := sarama.NewSyncProducer(ClusterBrokers, producercfg)
 	if err != nil {
 		log.Fatalln("Failed to start Sarama producer:", err)
 	}
@@ -60,7 +62,7 @@ func InitKafka() (producer sarama.SyncProducer, consumer sarama.Consumer, err er
 	consumercfg := sarama.NewConfig()
 	consumercfg.Consumer.Return.Errors = true
 	// or instead of consumercfg you can use nil (in this case the default consumer settings will be used)
-	Consumer1, err := sarama.NewConsumer([]string{"kafka-1:9092", "kafka-2:9093"}, consumercfg)
+	Consumer1, err := sarama.NewConsumer(ClusterBrokers, consumercfg)
 	if err != nil {
 		log.Fatalln("Failed to start Sarama consumer:", err)
 	}
@@ -1,14 +1,22 @@
 package kafka
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
+	"time"
 
 	"github.com/IBM/sarama"
 )
 
+var ErrNoProducer = errors.New("kafka producer is not initialized")
+
 // ProduceMessage sends a message to a Kafka topic and logs its partition and offset.
 // It connects to the Kafka cluster specified by brokers and publishes to the given topic.
 func ProduceMessage(brokers []string, topic, message string) error {
+	if Producer == nil {
+		return ErrNoProducer
+	}
 	msg := &sarama.ProducerMessage{
 		Topic: topic,
 		Value: sarama.StringEncoder(message),
@@ -23,3 +31,19 @@ func ProduceMessage(brokers []string, topic, message string) error {
 	log.Printf("Message is stored in topic(%s)/partition(%d)/offset(%d)\n", topic, partition, offset)
 	return nil
 }
+
+// ProduceEvent sends {"event": event, ...fields, "timestamp": now} to the default topic
+func ProduceEvent(event string, fields map[string]interface{}) error {
+	payload := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		payload[k] = v
+	}
+	payload["event"] = event
+	payload["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return ProduceMessage(Brokers, Topic, string(value))
+}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"AuthDB/cmd/app/auth"
 	"AuthDB/cmd/app/controller"
 	"AuthDB/cmd/app/repository"
 	"AuthDB/cmd/internal/kafka"
+	"AuthDB/internal/access"
+	"AuthDB/internal/adminpanel"
+	"AuthDB/internal/api/certs"
+	apphealth "AuthDB/internal/api/health"
+	"AuthDB/internal/api/interceptor"
 	useraccess "AuthDB/internal/api/user"
+	"AuthDB/internal/pii"
+	"AuthDB/internal/policy"
 	"context"
 	"fmt"
 	"log"
@@ -13,13 +21,14 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/GoAdminGroup/go-admin/adapter/gorilla"
 	"github.com/GoAdminGroup/go-admin/engine"
-	"github.com/GoAdminGroup/go-admin/examples/datamodel"
 	"github.com/GoAdminGroup/go-admin/modules/config"
 	_ "github.com/GoAdminGroup/go-admin/modules/db/drivers/postgres"
 	"github.com/GoAdminGroup/go-admin/modules/language"
@@ -30,9 +39,15 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
-func initGoAdmin(router *mux.Router, dbURL string) (*engine.Engine, error) {
+// the accounts of the application are the "user" table, /admin/info/user
+func initGoAdmin(router *mux.Router, dbURL string, users *access.UserAdmin) (*engine.Engine, error) {
 	// Parse DATABASE_URL for GoAdmin config
 	parsedURL, err := url.Parse(dbURL)
 	if err != nil {
@@ -80,7 +95,7 @@ func initGoAdmin(router *mux.Router, dbURL string) (*engine.Engine, error) {
 	if err := eng.AddConfig(cfg).
 		AddGenerators().
 		AddDisplayFilterXssJsFilter().
-		AddGenerator("user", datamodel.GetUserTable).
+		AddGenerator("user", adminpanel.UserTable(users)).
 		AddPlugins(adminPlugin).
 		Use(router); err != nil {
 		return nil, fmt.Errorf("failed to configure GoAdmin engine: %v", err)
@@ -91,7 +106,86 @@ func initGoAdmin(router *mux.Router, dbURL string) (*engine.Engine, error) {
 	return eng, nil
 }
 
+// Access policies are read from POLICY_DIR (configs/policies by default),
+// POLICY_MODE is enforce, dry_run or off
+func loadPolicies() (*policy.Engine, error) {
+	mode := policy.Mode(os.Getenv("POLICY_MODE"))
+	switch mode {
+	case "":
+		mode = policy.Enforce
+	case policy.Enforce, policy.DryRun:
+	case policy.Off:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown POLICY_MODE %q", mode)
+	}
+
+	dir := os.Getenv("POLICY_DIR")
+	if dir == "" {
+		dir = filepath.Join("configs", "policies")
+	}
+	policies, err := policy.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded %d access policies from %s (%s)", len(policies), dir, mode)
+	return policy.NewEngine(policies, mode), nil
+}
+
+// Routes of the services behind Envoy/nginx are read from ROUTES_FILE (configs/routes.json by default)
+func loadRoutes() (access.Routes, error) {
+	path := os.Getenv("ROUTES_FILE")
+	if path == "" {
+		path = filepath.Join("configs", "routes.json")
+	}
+	routes, err := access.LoadRoutes(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded %d proxy routes from %s", len(routes), path)
+	return routes, nil
+}
+
+// gRPC TLS is on when GRPC_TLS_CERT and GRPC_TLS_KEY are set, GRPC_TLS_CLIENT_CA adds mutual TLS.
+// Client certificates are mapped to service roles by GRPC_TLS_IDENTITIES,
+// the files are checked for changes every GRPC_TLS_RELOAD_INTERVAL (30s by default)
+func grpcTLS(ctx context.Context, grpcAuth *interceptor.Auth) ([]grpc.ServerOption, error) {
+	cfg, ok, err := certs.FromEnv()
+	if err != nil || !ok {
+		if err == nil {
+			log.Println("gRPC TLS is not configured, serving plaintext")
+		}
+		return nil, err
+	}
+
+	interval := 30 * time.Second
+	if v := os.Getenv("GRPC_TLS_RELOAD_INTERVAL"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid GRPC_TLS_RELOAD_INTERVAL: %v", err)
+		}
+	}
+	if cfg.ClientCAFile != "" {
+		identities, err := certs.ParseIdentities(os.Getenv("GRPC_TLS_IDENTITIES"))
+		if err != nil {
+			return nil, err
+		}
+		grpcAuth.TrustClientCertificates(identities)
+	}
+
+	reloader, err := certs.NewReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.Watch(ctx, interval)
+	return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(reloader.TLSConfig()))}, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -105,6 +199,20 @@ func main() {
 		log.Fatalf("DATABASE_URL is not set")
 	}
 
+	// PII_KEY_FILE turns on the encryption of the emails, see package pii
+	var cipher *pii.Cipher
+	if path := os.Getenv("PII_KEY_FILE"); path != "" {
+		var err error
+		if cipher, err = pii.LoadKeyFile(path); err != nil {
+			log.Fatalf("Error loading PII keys: %v", err)
+		}
+		log.Printf("Encrypting PII with key %s", cipher.CurrentKeyID())
+	}
+
+	if err := migrateAndCheck(ctx, dbURL); err != nil {
+		log.Fatalf("Database schema: %v", err)
+	}
+
 	// Connect db
 	dbpool, err := repository.InitDBConn(ctx, dbURL)
 	if err != nil {
@@ -122,13 +230,73 @@ func main() {
 
 	// Main app
 	// Initialize main application and router
-	app := controller.NewApp(ctx, dbpool)
+	// Sessions, lockout, auth events and access decisions are shared by HTTP and gRPC
+	repo := repository.NewRepository(dbpool)
+	// DATABASE_REPLICA_URLS: comma separated read replicas for the lookups, listings and searches
+	// of the stores, the ones behind by DATABASE_REPLICA_MAX_LAG (2s by default) read from the primary
+	cluster, err := repository.NewCluster(ctx, dbpool, strings.Fields(strings.ReplaceAll(os.Getenv("DATABASE_REPLICA_URLS"), ",", " "))...)
+	if err != nil {
+		log.Fatalf("Error initializing DB replicas: %v", err)
+	}
+	defer cluster.Close()
+	if v := os.Getenv("DATABASE_REPLICA_MAX_LAG"); v != "" {
+		if cluster.MaxLag, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid DATABASE_REPLICA_MAX_LAG: %v", err)
+		}
+		cluster.StickyFor = max(cluster.StickyFor, cluster.MaxLag+2*time.Second)
+	}
+	go cluster.Run(ctx, time.Second)
+	users := repository.NewPostgresUserStore(cluster, cipher)
+	// login history and audit trail, for the data exports
+	events := repository.NewPostgresEventLog(cluster)
+	// the logins read the primary: a replica behind could still let in a disabled user or an old
+	// password, keep revoked roles or not know a user who just signed up
+	authService := auth.NewService(repository.NewPostgresUserStore(cluster.Primary(), cipher))
+	authService.RecordEvents(events)
+	policyEngine, err := loadPolicies()
+	if err != nil {
+		log.Fatalf("Error loading access policies: %v", err)
+	}
+	checker := access.NewChecker(repo, policyEngine)
+	roleAdmin := access.NewRoleAdmin(repo, checker, authService)
+	// routes of the services behind Envoy (ext_authz) and nginx (auth_request)
+	routes, err := loadRoutes()
+	if err != nil {
+		log.Fatalf("Error loading proxy routes: %v", err)
+	}
+	app := controller.NewApp(ctx, dbpool, users, events, authService, checker, roleAdmin,
+		access.NewGate(authService, checker, routes))
+	// AUTH_REDIRECT_HOSTS: comma separated hosts of the protected apps the login may return to
+	if hosts := os.Getenv("AUTH_REDIRECT_HOSTS"); hosts != "" {
+		app.AllowRedirectHosts(strings.Fields(strings.ReplaceAll(hosts, ",", " "))...)
+	}
+	// accounts pending deletion are purged after ACCOUNT_DELETION_GRACE (30 days by default),
+	// PURGE_MODE=anonymize keeps the rows without the personal data instead of deleting them
+	grace := access.DefaultDeletionGrace
+	if v := os.Getenv("ACCOUNT_DELETION_GRACE"); v != "" {
+		if grace, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid ACCOUNT_DELETION_GRACE: %v", err)
+		}
+	}
+	authService.SetDeletionGrace(grace)
+	purger := access.NewPurger(users, events, grace, os.Getenv("PURGE_MODE") == "anonymize")
+	purger.OnPurged = func(userID int, anonymized bool) {
+		if err := kafka.ProduceEvent("user_purged", map[string]interface{}{"user_id": userID, "anonymized": anonymized}); err != nil {
+			log.Println("Failed to produce Kafka message:", err)
+		}
+		// the personal data is gone either way, downstream services forget the user too
+		if err := kafka.ProduceEvent("user_erased", map[string]interface{}{"user_id": userID}); err != nil {
+			log.Println("Failed to produce Kafka message:", err)
+		}
+	}
+	go purger.Run(ctx, time.Hour)
+
 	mainRouter := mux.NewRouter()
 	app.Routes(mainRouter)
 
 	mainMux := http.NewServeMux()
 
-	_, err = initGoAdmin(mainRouter, dbURL)
+	_, err = initGoAdmin(mainRouter, dbURL, access.NewUserAdmin(users, roleAdmin))
 	if err != nil {
 		log.Fatalf("Error initializing GoAdmin: %v", err)
 	}
@@ -149,59 +317,120 @@ func main() {
 		Handler: mainMux,
 	}
 
-	go func() {
-		log.Println("Starting server on port 4444")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed: %v", err)
-		}
-	}()
-
 	// gRPC Server
 	port := os.Getenv("GRPC_PORT")
 	if port == "" {
 		log.Fatalf("GRPC_PORT not set")
 	}
 	// Create an AccessService instance
-	accessService := &useraccess.AccessService{}
-	if err := useraccess.StartGRPCServer(":"+port, accessService); err != nil {
-		log.Fatalf("Failed to start grpc server: %v", err)
+	accessService := useraccess.NewAccessService(authService, checker)
+	roleService := useraccess.NewRoleService(roleAdmin)
+
+	// Every call is authenticated and checked against useraccess.MethodRules,
+	// calls without a deadline get GRPC_TIMEOUT (10s by default)
+	timeout := 10 * time.Second
+	if v := os.Getenv("GRPC_TIMEOUT"); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid GRPC_TIMEOUT: %v", err)
+		}
+	}
+	grpcAuth := interceptor.NewAuth(authService, repo, checker, useraccess.MethodRules)
+	opts := interceptor.ServerOptions(grpcAuth, timeout)
+	tlsOpts, err := grpcTLS(ctx, grpcAuth)
+	if err != nil {
+		log.Fatalf("Error configuring gRPC TLS: %v", err)
+	}
+	userService := useraccess.NewUserService(access.NewUserAdmin(users, roleAdmin))
+	grpcServer := useraccess.NewGRPCServer(accessService, roleService, append(opts, tlsOpts...)...)
+	useraccess.RegisterUserService(grpcServer, userService)
+
+	// Envoy ext_authz for the services behind the proxy
+	useraccess.RegisterExtAuthz(grpcServer, accessService.ExtAuthz(routes))
+
+	// grpc.health.v1 reports NOT_SERVING while the database or Kafka is unreachable
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	monitor := apphealth.NewMonitor(healthServer, useraccess.ServiceNames, map[string]apphealth.Probe{
+		"database": dbpool.Ping,
+		"kafka": func(ctx context.Context) error {
+			return kafka.Ping(kafka.ClusterBrokers, 2*time.Second)
+		},
+	})
+	go monitor.Run(ctx, 10*time.Second)
+
+	if os.Getenv("GRPC_REFLECTION") == "true" {
+		reflection.Register(grpcServer)
+	}
+
+	// The same services as JSON over HTTP, see the google.api.http options in api/user_v1/user.proto.
+	// The gateway calls its own plaintext server, it has no client certificate for grpcServer.
+	gatewayServer := useraccess.NewGRPCServer(accessService, roleService, opts...)
+	useraccess.RegisterUserService(gatewayServer, userService)
+	gateway, err := useraccess.NewGateway(ctx, gatewayServer)
+	if err != nil {
+		log.Fatalf("Error creating gRPC gateway: %v", err)
+	}
+	mainMux.Handle("/v1/", gateway)
+
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("Failed to listen on gRPC port: %v", err)
 	}
 
+	// Both servers run until one of them fails or a shutdown signal arrives
+	serverErr := make(chan error, 2)
+	go func() {
+		log.Println("Starting server on port 4444")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErr <- fmt.Errorf("HTTP server: %w", err)
+		}
+	}()
+	go func() {
+		log.Printf("Starting gRPC server on port %s", port)
+		if err := grpcServer.Serve(lis); err != nil {
+			serverErr <- fmt.Errorf("gRPC server: %w", err)
+		}
+	}()
+
 	// Graceful shutdown
 	// we need to reserve to buffer size 1, so the notifier are not blocked
 	exit := make(chan os.Signal, 1)
 	// The operating system sends a shutdown signal to a process when it wants to terminate it gracefully
 	signal.Notify(exit, os.Interrupt, syscall.SIGTERM)
-	// program is blocked until the channel receives a signal.
-	// waiting to receive a signal such as os.Interrupt or syscall.SIGTERM
-	go func() {
-		<-exit
-		log.Println("Shutting down servers...")
+	// program is blocked until the channel receives a signal or a server fails
+	select {
+	case <-exit:
+	case err := <-serverErr:
+		log.Printf("Server failed: %v", err)
+	}
+	log.Println("Shutting down servers...")
+
+	// Stop reporting SERVING first, so load balancers stop sending new calls
+	healthServer.Shutdown()
 
-		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		defer cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 15*time.Second)
+	defer shutdownCancel()
 
-		// http server shutdown
+	// Drain both servers at the same time
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
 		if err := server.Shutdown(shutdownCtx); err != nil {
 			log.Printf("HTTP Server Shutdown Failed: %v", err)
 		}
+		// the gateway calls of the HTTP requests are done by now
+		useraccess.GracefulStop(gatewayServer, time.Second)
+	}()
+	go func() {
+		defer wg.Done()
+		useraccess.GracefulStop(grpcServer, 15*time.Second)
+	}()
+	wg.Wait()
 
-		// Kafka shutdown
-		log.Println("Closing Kafka producer and consumer...")
-		if err := producer.Close(); err != nil {
-			log.Printf("Kafka producer close failed: %v", err)
-		}
-		if err := consumer.Close(); err != nil {
-			log.Printf("Kafka consumer close failed: %v", err)
-		}
-
-		// Close the db connection
-		log.Println("Closing database connection pool...")
-		dbpool.Close()
-
-		// cancel app ctx
-		cancel()
+	// cancel app ctx, stops the health monitor and the certificate watcher
+	cancel()
 
-		log.Println("Shutdown complete.")
-	}()
+	// Kafka and the db connection are closed by the deferred calls above
+	log.Println("Shutdown complete.")
 }
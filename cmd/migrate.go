@@ -0,0 +1,95 @@
+package main
+
+import (
+	"AuthDB/migrations"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// runMigrate is the migrate subcommand, it runs the embedded migrations (package migrations):
+//
+//	go run ./cmd migrate up | status | check [app | admin]
+//	go run ./cmd migrate down | redo [app | admin]
+//	/app/main migrate status
+//
+// up and status work on both sets by default, down and redo on the app set.
+// check reports the schema drift, like the app at startup.
+func runMigrate(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		log.Fatalf("usage: migrate up | down | status | redo | check [app | admin]")
+	}
+	command := args[0]
+	sets := migrations.Sets
+	if command == "down" || command == "redo" {
+		sets = []migrations.Set{migrations.App}
+	}
+	if len(args) == 2 {
+		set, err := migrations.SetByName(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		sets = []migrations.Set{set}
+	}
+
+	// the env file is optional, DATABASE_URL may come from the environment
+	_ = godotenv.Load("configs/db.env")
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		log.Fatalf("DATABASE_URL is not set")
+	}
+	db, err := migrations.Open(dbURL)
+	if err != nil {
+		log.Fatalf("Error initializing DB connection: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if command == "check" {
+		if err := migrations.Check(ctx, db); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("the database schema is up to date")
+		return
+	}
+	for _, set := range sets {
+		if err := migrations.Run(ctx, db, set, command, os.Stdout); err != nil {
+			log.Fatalf("Migrate %s %s failed: %v", command, set.Name, err)
+		}
+	}
+}
+
+// MIGRATE_ON_START=true applies the pending migrations before the app connects,
+// the advisory lock makes the other replicas wait for the first one
+func migrateAndCheck(ctx context.Context, dbURL string) error {
+	db, err := migrations.Open(dbURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if os.Getenv("MIGRATE_ON_START") == "true" {
+		results, err := migrations.Up(ctx, db)
+		for _, res := range results {
+			log.Println(res)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied %d migrations", len(results))
+	}
+
+	// a schema the build doesn't expect stops the start with the list of differences,
+	// SCHEMA_CHECK=warn only logs it
+	err = migrations.Check(ctx, db)
+	var drift *migrations.Drift
+	if errors.As(err, &drift) && os.Getenv("SCHEMA_CHECK") == "warn" {
+		log.Println(drift)
+		return nil
+	}
+	return err
+}
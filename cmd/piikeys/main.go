@@ -0,0 +1,82 @@
+// piikeys manages the keys of the PII encryption (PII_KEY_FILE, see package pii):
+//
+//	go run ./cmd/piikeys genkey
+//	go run ./cmd/piikeys rotate -batch 500
+//
+// genkey prints a new key for the key file. To rotate, add it to keys, make it current,
+// restart the app and run rotate: it re-encrypts every row of another key (and the ones
+// still in plaintext or without the email domain index) in batches. The old key can leave
+// the file once rotate is done.
+package main
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/pii"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatalf("usage: piikeys genkey | rotate [-batch n]")
+	}
+	switch os.Args[1] {
+	case "genkey":
+		key, err := pii.GenerateKey()
+		if err != nil {
+			log.Fatalf("Failed to generate a key: %v", err)
+		}
+		fmt.Println(key)
+	case "rotate":
+		flags := flag.NewFlagSet("rotate", flag.ExitOnError)
+		batch := flags.Int("batch", 500, "rows per transaction")
+		flags.Parse(os.Args[2:])
+		rotate(*batch)
+	default:
+		log.Fatalf("unknown command %q, use genkey or rotate", os.Args[1])
+	}
+}
+
+func rotate(batch int) {
+	// the env file is optional, DATABASE_URL and PII_KEY_FILE may come from the environment
+	_ = godotenv.Load("configs/db.env")
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		log.Fatalf("DATABASE_URL is not set")
+	}
+	path := os.Getenv("PII_KEY_FILE")
+	if path == "" {
+		log.Fatalf("PII_KEY_FILE is not set")
+	}
+	cipher, err := pii.LoadKeyFile(path)
+	if err != nil {
+		log.Fatalf("Error loading PII keys: %v", err)
+	}
+
+	ctx := context.Background()
+	dbpool, err := repository.InitDBConn(ctx, dbURL)
+	if err != nil {
+		log.Fatalf("Error initializing DB connection: %v", err)
+	}
+	defer dbpool.Close()
+	users := repository.NewPostgresUserStore(dbpool, cipher)
+
+	total := 0
+	for {
+		n, err := users.RotatePII(ctx, batch)
+		if err != nil {
+			log.Fatalf("Rotation stopped after %d rows: %v", total, err)
+		}
+		if n == 0 {
+			break
+		}
+		total += n
+		log.Printf("Re-encrypted %d rows", total)
+	}
+	fmt.Printf("Every user uses key %s, %d rows re-encrypted\n", cipher.CurrentKeyID(), total)
+}
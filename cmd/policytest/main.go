@@ -0,0 +1,61 @@
+// policytest runs the *_test.json cases against the policies of a directory
+// or explains how a single request is evaluated:
+//
+//	go run ./cmd/policytest -dir configs/policies
+//	go run ./cmd/policytest -dir configs/policies -explain request.json
+package main
+
+import (
+	"AuthDB/internal/policy"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func main() {
+	dir := flag.String("dir", "configs/policies", "directory with the policy files")
+	explain := flag.String("explain", "", "file with a request to explain instead of running the tests")
+	flag.Parse()
+
+	policies, err := policy.Load(*dir)
+	if err != nil {
+		log.Fatalf("Failed to load policies: %v", err)
+	}
+	engine := policy.NewEngine(policies, policy.Enforce)
+
+	if *explain != "" {
+		data, err := os.ReadFile(*explain)
+		if err != nil {
+			log.Fatalf("Failed to read request: %v", err)
+		}
+		var req policy.Request
+		if err := json.Unmarshal(data, &req); err != nil {
+			log.Fatalf("Failed to parse request: %v", err)
+		}
+		res := engine.Evaluate(req)
+		for _, t := range res.Trace {
+			fmt.Printf("%-30s %-5s applied=%-5v %s\n", t.PolicyID, t.Effect, t.Applied, t.Detail)
+		}
+		if res.Effect == "" {
+			fmt.Println("result: not_applicable")
+		} else {
+			fmt.Printf("result: %s (%s)\n", res.Effect, res.PolicyID)
+		}
+		return
+	}
+
+	cases, err := policy.LoadTests(*dir)
+	if err != nil {
+		log.Fatalf("Failed to load policy tests: %v", err)
+	}
+	failures := engine.RunTests(cases)
+	for _, f := range failures {
+		fmt.Println("FAIL", f)
+	}
+	fmt.Printf("%d tests, %d failed\n", len(cases), len(failures))
+	if len(failures) > 0 {
+		os.Exit(1)
+	}
+}
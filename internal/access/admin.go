@@ -0,0 +1,133 @@
+package access
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"context"
+	"strings"
+)
+
+// RoleAdmin manages roles and permissions for the admin HTTP and gRPC APIs.
+// Every change drops the cached decisions so it is visible right away.
+type RoleAdmin struct {
+	repo    *repository.Repository
+	checker *Checker
+	auth    *auth.Service
+}
+
+func NewRoleAdmin(repo *repository.Repository, checker *Checker, authService *auth.Service) *RoleAdmin {
+	return &RoleAdmin{repo: repo, checker: checker, auth: authService}
+}
+
+func (a *RoleAdmin) ListRoles(ctx context.Context) ([]repository.Role, error) {
+	return a.repo.ListRoles(ctx, nil)
+}
+
+func (a *RoleAdmin) CreateRole(ctx context.Context, role *repository.Role) error {
+	role.Name = strings.TrimSpace(role.Name)
+	if role.Name == "" {
+		return ErrInvalidArgument
+	}
+	return a.repo.CreateRole(ctx, nil, role)
+}
+
+func (a *RoleAdmin) DeleteRole(ctx context.Context, roleID int) error {
+	if err := a.repo.DeleteRole(ctx, nil, roleID); err != nil {
+		return err
+	}
+	a.checker.Invalidate()
+	return nil
+}
+
+func (a *RoleAdmin) SetRoleParent(ctx context.Context, roleID int, parentID *int) error {
+	if err := a.repo.SetRoleParent(ctx, nil, roleID, parentID); err != nil {
+		return err
+	}
+	a.checker.Invalidate()
+	return nil
+}
+
+func (a *RoleAdmin) ListPermissions(ctx context.Context) ([]repository.Permission, error) {
+	return a.repo.ListPermissions(ctx, nil)
+}
+
+func (a *RoleAdmin) CreatePermission(ctx context.Context, p *repository.Permission) error {
+	p.Resource = strings.TrimSpace(p.Resource)
+	p.Action = strings.TrimSpace(p.Action)
+	if p.Resource == "" || p.Action == "" {
+		return ErrInvalidArgument
+	}
+	return a.repo.CreatePermission(ctx, nil, p)
+}
+
+func (a *RoleAdmin) RolePermissions(ctx context.Context, roleID int) ([]repository.Permission, error) {
+	return a.repo.RolePermissions(ctx, nil, roleID)
+}
+
+func (a *RoleAdmin) GrantPermission(ctx context.Context, roleID, permissionID int) error {
+	if err := a.repo.GrantPermission(ctx, nil, roleID, permissionID); err != nil {
+		return err
+	}
+	a.checker.Invalidate()
+	return nil
+}
+
+func (a *RoleAdmin) RevokePermission(ctx context.Context, roleID, permissionID int) error {
+	if err := a.repo.RevokePermission(ctx, nil, roleID, permissionID); err != nil {
+		return err
+	}
+	a.checker.Invalidate()
+	return nil
+}
+
+// UserRoles holds what an admin sees about the roles of a user
+type UserRoles struct {
+	Roles          []repository.Role       `json:"roles"`
+	EffectiveRoles []string                `json:"effective_roles"`
+	Permissions    []repository.Permission `json:"permissions"`
+}
+
+func (a *RoleAdmin) UserRoles(ctx context.Context, userID int) (*UserRoles, error) {
+	roles, err := a.repo.UserRoles(ctx, nil, userID)
+	if err != nil {
+		return nil, err
+	}
+	effective, err := a.repo.EffectiveRoles(ctx, nil, userID)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := a.repo.UserPermissions(ctx, nil, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &UserRoles{Roles: roles, EffectiveRoles: effective, Permissions: permissions}, nil
+}
+
+func (a *RoleAdmin) AssignRole(ctx context.Context, userID, roleID int) error {
+	if err := a.repo.AssignRole(ctx, nil, userID, roleID); err != nil {
+		return err
+	}
+	return a.rolesChanged(ctx, userID)
+}
+
+func (a *RoleAdmin) UnassignRole(ctx context.Context, userID, roleID int) error {
+	if err := a.repo.UnassignRole(ctx, nil, userID, roleID); err != nil {
+		return err
+	}
+	return a.rolesChanged(ctx, userID)
+}
+
+// the open sessions of the user get the new roles
+func (a *RoleAdmin) rolesChanged(ctx context.Context, userID int) error {
+	a.checker.Invalidate()
+	roles, err := a.repo.UserRoles(ctx, nil, userID)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(roles))
+	for i, r := range roles {
+		names[i] = r.Name
+	}
+	a.auth.SetRoles(userID, names)
+	return nil
+}
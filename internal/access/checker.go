@@ -0,0 +1,315 @@
+// Package access decides whether a user may perform an action on a resource.
+// Role permissions come from the role/permission tables and are cached for a short time,
+// the attribute based policies (see package policy) are evaluated on every check.
+package access
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/policy"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// How long a role decision is reused before the permissions are read again
+const DecisionTTL = 30 * time.Second
+
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// Principal is who is asking for access.
+// The permissions of a user are looked up by UserID,
+// a service (API key) has no user and gets the permissions of its Roles.
+type Principal struct {
+	UserID   int
+	Username string
+	Roles    []string
+	Service  string
+}
+
+// APIKeyPrincipal and CertificatePrincipal name the service by where it comes from:
+// an API key and a client certificate with the same name are different callers
+// and never share cached decisions
+func APIKeyPrincipal(name string, roles []string) Principal {
+	return Principal{Service: "apikey:" + name, Username: name, Roles: roles}
+}
+
+func CertificatePrincipal(identity string, roles []string) Principal {
+	return Principal{Service: "cert:" + identity, Username: identity, Roles: roles}
+}
+
+func (p Principal) String() string {
+	if p.Service != "" {
+		return "service " + p.Service
+	}
+	return fmt.Sprintf("user %d", p.UserID)
+}
+
+// Decision of a single check, Reason is meant to be shown to the caller.
+// Trace explains what every policy did, it is empty when policies are off.
+type Decision struct {
+	Resource string
+	Action   string
+	Allowed  bool
+	Reason   string
+	Trace    []policy.Trace
+}
+
+// Check is a single resource + action pair,
+// Attributes describe the resource for the policies (e.g. owner_id)
+type Check struct {
+	Resource   string
+	Action     string
+	Attributes map[string]string
+}
+
+type cacheKey struct {
+	userID   int
+	service  string
+	resource string
+	action   string
+}
+
+type cachedDecision struct {
+	decision  Decision
+	expiresAt time.Time
+}
+
+type Checker struct {
+	repo     *repository.Repository
+	policies *policy.Engine
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	cache map[cacheKey]cachedDecision
+}
+
+// policies may be nil, then only role permissions decide
+func NewChecker(repo *repository.Repository, policies *policy.Engine) *Checker {
+	return &Checker{
+		repo:     repo,
+		policies: policies,
+		ttl:      DecisionTTL,
+		cache:    make(map[cacheKey]cachedDecision),
+	}
+}
+
+// Check evaluates one resource + action for the principal
+func (c *Checker) Check(ctx context.Context, p Principal, resource, action string) Decision {
+	return c.CheckAll(ctx, p, []Check{{Resource: resource, Action: action}})[0]
+}
+
+// CheckAll evaluates every check, the permissions and attributes of the user are read at most once
+func (c *Checker) CheckAll(ctx context.Context, p Principal, checks []Check) []Decision {
+	decisions := make([]Decision, len(checks))
+	l := &loader{checker: c, principal: p}
+
+	for i, check := range checks {
+		if check.Resource == "" || check.Action == "" {
+			decisions[i] = deny(check, "resource and action are required")
+			continue
+		}
+
+		d, ok := c.roleDecision(ctx, l, check)
+		if !ok {
+			// don't go on with the policies, the lookup failed
+			decisions[i] = d
+			continue
+		}
+		decisions[i] = c.applyPolicies(ctx, l, check, d)
+	}
+	return decisions
+}
+
+// HasRole reports whether the user has the role, directly or by inheritance
+func (c *Checker) HasRole(ctx context.Context, p Principal, role string) (bool, error) {
+	roles := p.Roles
+	if p.Service == "" {
+		var err error
+		if roles, err = c.repo.EffectiveRoles(ctx, nil, p.UserID); err != nil {
+			return false, err
+		}
+	}
+	for _, r := range roles {
+		if r == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Invalidate drops every cached decision, call it after the permissions change
+func (c *Checker) Invalidate() {
+	c.mu.Lock()
+	c.cache = make(map[cacheKey]cachedDecision)
+	c.mu.Unlock()
+}
+
+// loader reads what a batch of checks needs once
+type loader struct {
+	checker   *Checker
+	principal Principal
+
+	permissions    []repository.Permission
+	permissionsErr error
+	permsLoaded    bool
+
+	attributes  map[string]string
+	attrsLoaded bool
+
+	roles       []string
+	rolesLoaded bool
+}
+
+func (l *loader) userPermissions(ctx context.Context) ([]repository.Permission, error) {
+	if !l.permsLoaded {
+		if l.principal.Service != "" {
+			l.permissions, l.permissionsErr = l.checker.repo.RolesPermissions(ctx, nil, l.principal.Roles)
+		} else {
+			l.permissions, l.permissionsErr = l.checker.repo.UserPermissions(ctx, nil, l.principal.UserID)
+		}
+		l.permsLoaded = true
+		if l.permissionsErr != nil {
+			log.Printf("Error loading permissions of %s: %v", l.principal, l.permissionsErr)
+		}
+	}
+	return l.permissions, l.permissionsErr
+}
+
+// effectiveRoles are the roles of the principal with the ones they inherit from,
+// the policies match them like the permissions and HasRole do
+func (l *loader) effectiveRoles(ctx context.Context) []string {
+	if !l.rolesLoaded {
+		var err error
+		if l.principal.Service != "" {
+			l.roles, err = l.checker.repo.RolesEffective(ctx, nil, l.principal.Roles)
+		} else {
+			l.roles, err = l.checker.repo.EffectiveRoles(ctx, nil, l.principal.UserID)
+		}
+		if err != nil {
+			// the roles of the claims at least
+			log.Printf("Error loading roles of %s: %v", l.principal, err)
+			l.roles = l.principal.Roles
+		}
+		l.rolesLoaded = true
+	}
+	return l.roles
+}
+
+func (l *loader) userAttributes(ctx context.Context) map[string]string {
+	if !l.attrsLoaded && l.principal.Service == "" {
+		attrs, err := l.checker.repo.UserAttributes(ctx, nil, l.principal.UserID)
+		if err != nil {
+			// policies that need them simply won't apply
+			log.Printf("Error loading attributes of %s: %v", l.principal, err)
+		}
+		l.attributes = attrs
+		l.attrsLoaded = true
+	}
+	return l.attributes
+}
+
+// roleDecision is the cached decision of the role permissions alone,
+// ok is false when the permissions could not be read
+func (c *Checker) roleDecision(ctx context.Context, l *loader, check Check) (Decision, bool) {
+	key := cacheKey{userID: l.principal.UserID, service: l.principal.Service,
+		resource: check.Resource, action: check.Action}
+	if d, ok := c.cached(key); ok {
+		return d, true
+	}
+
+	permissions, err := l.userPermissions(ctx)
+	if err != nil {
+		// don't cache, the next call may succeed
+		return deny(check, "permission lookup failed"), false
+	}
+	d := evaluate(permissions, check)
+	c.store(key, d)
+	return d, true
+}
+
+// applyPolicies lets a matching deny policy take access away
+// and a matching allow policy grant what the roles don't
+func (c *Checker) applyPolicies(ctx context.Context, l *loader, check Check, d Decision) Decision {
+	mode := c.policies.Mode()
+	if mode == policy.Off {
+		return d
+	}
+
+	res := c.policies.Evaluate(policy.Request{
+		Subject: policy.Subject{
+			ID:         l.principal.UserID,
+			Username:   l.principal.Username,
+			Roles:      l.effectiveRoles(ctx),
+			Attributes: l.userAttributes(ctx),
+		},
+		Resource:   check.Resource,
+		Action:     check.Action,
+		Attributes: check.Attributes,
+	})
+
+	final := d
+	final.Trace = res.Trace
+	switch {
+	case res.Effect == policy.Deny:
+		final.Allowed = false
+		final.Reason = fmt.Sprintf("denied by policy %s", res.PolicyID)
+	case res.Effect == policy.Allow && !d.Allowed:
+		final.Allowed = true
+		final.Reason = fmt.Sprintf("granted by policy %s", res.PolicyID)
+	}
+
+	if mode == policy.DryRun {
+		if final.Allowed != d.Allowed {
+			log.Printf("Policy dry run: %s %s:%s would be %s (%s)",
+				l.principal, check.Resource, check.Action, allowedText(final.Allowed), final.Reason)
+		}
+		d.Trace = res.Trace
+		return d
+	}
+	return final
+}
+
+func evaluate(permissions []repository.Permission, check Check) Decision {
+	for _, perm := range permissions {
+		if perm.Allows(check.Resource, check.Action) {
+			return Decision{
+				Resource: check.Resource,
+				Action:   check.Action,
+				Allowed:  true,
+				Reason:   fmt.Sprintf("granted by permission %s:%s", perm.Resource, perm.Action),
+			}
+		}
+	}
+	return deny(check, fmt.Sprintf("no permission %s:%s", check.Resource, check.Action))
+}
+
+func deny(check Check, reason string) Decision {
+	return Decision{Resource: check.Resource, Action: check.Action, Allowed: false, Reason: reason}
+}
+
+func allowedText(allowed bool) string {
+	if allowed {
+		return "allowed"
+	}
+	return "denied"
+}
+
+func (c *Checker) cached(key cacheKey) (Decision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.cache[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(c.cache, key)
+		return Decision{}, false
+	}
+	return entry.decision, true
+}
+
+func (c *Checker) store(key cacheKey, d Decision) {
+	c.mu.Lock()
+	c.cache[key] = cachedDecision{decision: d, expiresAt: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+}
@@ -0,0 +1,143 @@
+package access
+
+import (
+	"AuthDB/cmd/app/auth"
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Gate authorizes requests to services behind a proxy (Envoy ext_authz, nginx auth_request):
+// it validates the session and checks the permission of the matching route
+type Gate struct {
+	auth    *auth.Service
+	checker *Checker
+	routes  Routes
+}
+
+func NewGate(authService *auth.Service, checker *Checker, routes Routes) *Gate {
+	return &Gate{auth: authService, checker: checker, routes: routes}
+}
+
+// Headers the proxy passes on to the upstream service on allow.
+// Clients can't set them, the proxy drops incoming ones.
+const (
+	HeaderUserID = "X-Auth-User-Id"
+	HeaderUser   = "X-Auth-User"
+	HeaderRoles  = "X-Auth-Roles"
+)
+
+// Headers of the authorized caller, empty for anonymous calls to public routes
+func (d GateDecision) Headers() map[string]string {
+	if d.Principal == nil {
+		return nil
+	}
+	return map[string]string{
+		HeaderUserID: strconv.Itoa(d.Principal.UserID),
+		HeaderUser:   d.Principal.Username,
+		HeaderRoles:  strings.Join(d.Principal.Roles, ","),
+	}
+}
+
+// ProxyRequest is the original request as the proxy saw it
+type ProxyRequest struct {
+	Method string
+	Path   string
+	Host   string
+	Token  string
+}
+
+// GateDecision carries an HTTP status for the proxy:
+// 200 allowed, 401 no or invalid session, 403 not permitted or no route
+type GateDecision struct {
+	Status    int
+	Reason    string
+	Principal *Principal
+}
+
+// cleanPath is the path of uri as the proxy routes it, without the query string.
+// A .. segment or an encoded . or / could route somewhere else than the rules see, ok is false.
+func cleanPath(uri string) (string, bool) {
+	p, _, _ := strings.Cut(uri, "?")
+	lower := strings.ToLower(p)
+	if strings.Contains(lower, "%2e") || strings.Contains(lower, "%2f") {
+		return "", false
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	cleaned := path.Clean("/" + p)
+	// the trailing slash is part of the route, /admin/ is not /admin
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, true
+}
+
+func (d GateDecision) Allowed() bool {
+	return d.Status == http.StatusOK
+}
+
+func (g *Gate) Authorize(ctx context.Context, req ProxyRequest) GateDecision {
+	// the query string is not part of the route
+	path, ok := cleanPath(req.Path)
+	if !ok {
+		return GateDecision{Status: http.StatusForbidden, Reason: "path with an encoded or .. segment: " + req.Path}
+	}
+
+	route, ok := g.routes.Match(req.Method, path)
+	if !ok {
+		return GateDecision{Status: http.StatusForbidden, Reason: "no route for " + req.Method + " " + path}
+	}
+
+	var principal *Principal
+	if req.Token != "" {
+		claims, err := g.auth.Validate(req.Token)
+		switch {
+		case err == nil:
+			principal = &Principal{UserID: claims.UserID, Username: claims.Username, Roles: claims.Roles}
+		// an invalid or expired token is anonymous on a public route, like no token at all
+		case !route.Public:
+			return GateDecision{Status: http.StatusUnauthorized, Reason: "Invalid token"}
+		}
+	}
+
+	switch {
+	case route.Public:
+		return GateDecision{Status: http.StatusOK, Reason: "public route", Principal: principal}
+	case principal == nil:
+		return GateDecision{Status: http.StatusUnauthorized, Reason: "missing bearer token or session cookie"}
+	case route.Resource == "":
+		return GateDecision{Status: http.StatusOK, Reason: "authenticated", Principal: principal}
+	}
+
+	d := g.checker.CheckAll(ctx, *principal, []Check{{
+		Resource: route.Resource,
+		Action:   route.Action,
+		Attributes: map[string]string{
+			"method": req.Method,
+			"path":   path,
+			"host":   req.Host,
+		},
+	}})[0]
+	if !d.Allowed {
+		return GateDecision{Status: http.StatusForbidden, Reason: d.Reason, Principal: principal}
+	}
+	return GateDecision{Status: http.StatusOK, Reason: d.Reason, Principal: principal}
+}
+
+// SessionToken takes the token from "Authorization: Bearer ..." or else from the "token" session cookie
+func SessionToken(authorization, cookie string) string {
+	if scheme, token, ok := strings.Cut(authorization, " "); ok && strings.EqualFold(scheme, "bearer") {
+		return strings.TrimSpace(token)
+	}
+	header := http.Header{"Cookie": {cookie}}
+	if c, err := (&http.Request{Header: header}).Cookie("token"); err == nil {
+		return c.Value
+	}
+	return ""
+}
@@ -0,0 +1,160 @@
+package access
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Attributes are the attributes of the users, *repository.Repository
+type Attributes interface {
+	UserAttributes(ctx context.Context, tx pgx.Tx, userID int) (map[string]string, error)
+}
+
+// Privacy answers the data subject requests (GDPR): the export of everything
+// kept about a user and the erasure of it
+type Privacy struct {
+	users      repository.UserStore
+	attributes Attributes
+	events     repository.EventLog
+	auth       *auth.Service
+}
+
+// attributes may be nil, the export has none then
+func NewPrivacy(users repository.UserStore, attributes Attributes, events repository.EventLog, authService *auth.Service) *Privacy {
+	return &Privacy{users: users, attributes: attributes, events: events, auth: authService}
+}
+
+// DataExport is everything kept about a user, without the password hash and the tokens
+type DataExport struct {
+	ExportedAt   time.Time              `json:"exported_at"`
+	Profile      ExportProfile          `json:"profile"`
+	Sessions     []ExportSession        `json:"sessions"`
+	LoginHistory []repository.UserEvent `json:"login_history"`
+	// what admins did to the account and what the user did as an admin
+	Audit []repository.UserEvent `json:"audit"`
+}
+
+type ExportProfile struct {
+	ID                  int                      `json:"id"`
+	Username            string                   `json:"username"`
+	Email               string                   `json:"email"`
+	Roles               []string                 `json:"roles"`
+	Attributes          map[string]string        `json:"attributes"`
+	Status              repository.AccountStatus `json:"status"`
+	CreatedAt           *time.Time               `json:"created_at,omitempty"`
+	DisabledAt          *time.Time               `json:"disabled_at,omitempty"`
+	DeletionRequestedAt *time.Time               `json:"deletion_requested_at,omitempty"`
+}
+
+type ExportSession struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// the events of the login history, the other ones are the audit trail
+var loginEvents = map[string]bool{
+	"login":              true,
+	"login_failed":       true,
+	"login_disabled":     true,
+	"login_deleted":      true,
+	"logout":             true,
+	"deletion_cancelled": true,
+}
+
+// Export collects the data of the user. ErrUserNotFound, also for erased users
+func (p *Privacy) Export(ctx context.Context, userID int) (*DataExport, error) {
+	user, err := p.users.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	export := &DataExport{
+		ExportedAt: time.Now().UTC(),
+		Profile: ExportProfile{
+			ID:                  user.ID,
+			Username:            user.Username,
+			Email:               user.Email,
+			Roles:               user.Roles,
+			Attributes:          map[string]string{},
+			Status:              user.Status,
+			CreatedAt:           user.CreatedAt,
+			DisabledAt:          user.DisabledAt,
+			DeletionRequestedAt: user.DeletionRequestedAt,
+		},
+		Sessions:     []ExportSession{},
+		LoginHistory: []repository.UserEvent{},
+		Audit:        []repository.UserEvent{},
+	}
+	if p.attributes != nil {
+		attrs, err := p.attributes.UserAttributes(ctx, nil, userID)
+		if err != nil {
+			return nil, fmt.Errorf("error reading attributes: %w", err)
+		}
+		export.Profile.Attributes = attrs
+	}
+	for _, session := range p.auth.UserSessions(userID) {
+		export.Sessions = append(export.Sessions, ExportSession{ExpiresAt: session.ExpiresAt})
+	}
+	events, err := p.events.UserEvents(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading events: %w", err)
+	}
+	for _, e := range events {
+		if e.UserID == userID && e.ActorID == nil && loginEvents[e.Event] {
+			export.LoginHistory = append(export.LoginHistory, e)
+		} else {
+			export.Audit = append(export.Audit, e)
+		}
+	}
+	return export, nil
+}
+
+// WriteZip writes the export as a ZIP archive with a JSON file for every part
+func (e *DataExport) WriteZip(w io.Writer) error {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"export.json", map[string]interface{}{"exported_at": e.ExportedAt, "user_id": e.Profile.ID}},
+		{"profile.json", e.Profile},
+		{"sessions.json", e.Sessions},
+		{"login_history.json", e.LoginHistory},
+		{"audit.json", e.Audit},
+	}
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: e.ExportedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Erase anonymizes the user right away, whatever its state, and logs it out.
+// The events stay without their details, so the audit trail keeps its ids.
+// The events go first: after a failure the erasure can simply be repeated.
+func (p *Privacy) Erase(ctx context.Context, userID int) error {
+	if _, err := p.users.GetUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := p.events.EraseUserEvents(ctx, userID); err != nil {
+		return fmt.Errorf("error erasing events: %w", err)
+	}
+	if err := p.users.EraseUser(ctx, userID); err != nil {
+		return err
+	}
+	p.auth.LogoutUser(userID)
+	return nil
+}
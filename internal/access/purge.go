@@ -0,0 +1,69 @@
+package access
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultDeletionGrace is how long an account stays pending deletion,
+// the user can log in to keep it until then
+const DefaultDeletionGrace = auth.DefaultDeletionGrace
+
+// Purger deletes the accounts whose grace period is over.
+// With anonymize the rows stay as StatusDeleted without anything personal in them,
+// the events of the purged users lose their details too.
+type Purger struct {
+	users     repository.UserStore
+	events    repository.EventLog
+	grace     time.Duration
+	anonymize bool
+
+	// OnPurged is called for every purged account, main publishes user_purged with it
+	OnPurged func(userID int, anonymized bool)
+}
+
+// events may be nil
+func NewPurger(users repository.UserStore, events repository.EventLog, grace time.Duration, anonymize bool) *Purger {
+	return &Purger{users: users, events: events, grace: grace, anonymize: anonymize}
+}
+
+// Purge runs once and returns the ids of the purged accounts
+func (p *Purger) Purge(ctx context.Context) ([]int, error) {
+	ids, err := p.users.PurgeUsers(ctx, p.grace, p.anonymize)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if p.events != nil {
+			if err := p.events.EraseUserEvents(ctx, id); err != nil {
+				log.Printf("Error erasing the events of purged user %d: %v", id, err)
+			}
+		}
+		if p.OnPurged != nil {
+			p.OnPurged(id, p.anonymize)
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("Purged %d accounts pending deletion for longer than %s", len(ids), p.grace)
+	}
+	return ids, nil
+}
+
+// Run purges every interval until ctx is done
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := p.Purge(ctx); err != nil {
+			log.Printf("Error purging accounts: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Route says what a request to a proxied service needs.
+// Path matches exactly, Prefix matches the path prefix, the longest match wins.
+// Public routes need no credentials, routes without Resource only need a valid session.
+type Route struct {
+	Path     string   `json:"path,omitempty"`
+	Prefix   string   `json:"prefix,omitempty"`
+	Methods  []string `json:"methods,omitempty"`
+	Public   bool     `json:"public,omitempty"`
+	Resource string   `json:"resource,omitempty"`
+	Action   string   `json:"action,omitempty"`
+}
+
+type Routes []Route
+
+type routesFile struct {
+	Routes Routes `json:"routes"`
+}
+
+// LoadRoutes reads {"routes": [...]} from a JSON file
+func LoadRoutes(path string) (Routes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f routesFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for i, r := range f.Routes {
+		if (r.Path == "") == (r.Prefix == "") {
+			return nil, fmt.Errorf("%s: route %d needs either path or prefix", path, i)
+		}
+		if (r.Resource == "") != (r.Action == "") {
+			return nil, fmt.Errorf("%s: route %d needs both resource and action", path, i)
+		}
+	}
+	return f.Routes, nil
+}
+
+// Match finds the route of a request, ok is false when no route covers it
+func (routes Routes) Match(method, path string) (route Route, ok bool) {
+	best := -1
+	for _, r := range routes {
+		if len(r.Methods) > 0 && !containsFold(r.Methods, method) {
+			continue
+		}
+		length := -1
+		switch {
+		case r.Path != "" && r.Path == path:
+			// an exact path beats any prefix
+			length = len(path) + 1
+		case r.Prefix != "" && matchPrefix(r.Prefix, path):
+			length = len(r.Prefix)
+		}
+		if length > best {
+			best, route, ok = length, r, true
+		}
+	}
+	return route, ok
+}
+
+// matchPrefix matches whole segments only: /admin covers /admin/users but not /administrator,
+// and /admin/ covers /admin too
+func matchPrefix(prefix, path string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return prefix != "/" && path == strings.TrimSuffix(prefix, "/")
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package access
+
+import (
+	"AuthDB/cmd/app/repository"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// UserAdmin manages user accounts for the admin API.
+// Changes that take rights away close the open sessions of the user.
+type UserAdmin struct {
+	users repository.UserStore
+	roles *RoleAdmin
+}
+
+func NewUserAdmin(users repository.UserStore, roles *RoleAdmin) *UserAdmin {
+	return &UserAdmin{users: users, roles: roles}
+}
+
+// Page limits of ListUsers
+const (
+	DefaultUserPageSize = 50
+	MaxUserPageSize     = 200
+	// the deepest UserFilter.Offset, past it the search narrows the list down
+	MaxUserOffset = 10000
+)
+
+// ListUsers is a page of users, the next one starts at page.Next
+func (a *UserAdmin) ListUsers(ctx context.Context, f repository.UserFilter) (repository.UserPage, error) {
+	if _, ok := repository.UserSortColumns[f.Sort]; f.Sort != "" && !ok {
+		return repository.UserPage{}, fmt.Errorf("%w: can't sort by %q", ErrInvalidArgument, f.Sort)
+	}
+	switch f.Status {
+	case "", repository.StatusActive, repository.StatusDisabled, repository.StatusPendingDeletion, repository.StatusDeleted:
+	default:
+		return repository.UserPage{}, fmt.Errorf("%w: unknown status %q", ErrInvalidArgument, f.Status)
+	}
+	if f.Limit < 0 || f.Limit > MaxUserPageSize {
+		return repository.UserPage{}, fmt.Errorf("%w: limit must be 1..%d", ErrInvalidArgument, MaxUserPageSize)
+	}
+	if f.CreatedFrom != nil && f.CreatedTo != nil && !f.CreatedFrom.Before(*f.CreatedTo) {
+		return repository.UserPage{}, fmt.Errorf("%w: created_from must be before created_to", ErrInvalidArgument)
+	}
+	if f.Offset < 0 || f.Offset > MaxUserOffset {
+		return repository.UserPage{}, fmt.Errorf("%w: offset must be 0..%d", ErrInvalidArgument, MaxUserOffset)
+	}
+	if f.Limit == 0 {
+		f.Limit = DefaultUserPageSize
+	}
+	f.Search = strings.TrimSpace(f.Search)
+	page, err := a.users.ListUsers(ctx, f)
+	if errors.Is(err, repository.ErrInvalidCursor) || errors.Is(err, repository.ErrEncryptedSort) {
+		return page, fmt.Errorf("%w: %w", ErrInvalidArgument, err)
+	}
+	return page, err
+}
+
+// Result limits of SearchUsers
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// SearchUsers is the users closest to the query, the closest first
+func (a *UserAdmin) SearchUsers(ctx context.Context, query string, limit int) ([]repository.UserMatch, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, fmt.Errorf("%w: the query is empty", ErrInvalidArgument)
+	}
+	if limit < 0 || limit > MaxSearchLimit {
+		return nil, fmt.Errorf("%w: limit must be 1..%d", ErrInvalidArgument, MaxSearchLimit)
+	}
+	if limit == 0 {
+		limit = DefaultSearchLimit
+	}
+	return a.users.SearchUsers(ctx, query, limit)
+}
+
+func (a *UserAdmin) GetUser(ctx context.Context, userID int) (repository.User, error) {
+	return a.users.GetUser(ctx, userID)
+}
+
+// CreateUser adds the user with the given roles, DefaultRole if there are none
+func (a *UserAdmin) CreateUser(ctx context.Context, username, email, password string, roles []string) (*repository.User, error) {
+	user, err := repository.NewUser(username, email, password)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) > 0 {
+		user.Roles = roles
+	}
+	if err := a.users.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpdateUser saves the fields of the patch, a new password ends every session of the user
+func (a *UserAdmin) UpdateUser(ctx context.Context, userID int, patch repository.UserPatch) (repository.User, error) {
+	user, err := a.users.UpdateUser(ctx, userID, patch)
+	if err != nil {
+		return user, err
+	}
+	if patch.Password != nil {
+		a.roles.auth.LogoutUser(userID)
+	}
+	return user, nil
+}
+
+// SetDisabled disables (and logs out) or enables the account
+func (a *UserAdmin) SetDisabled(ctx context.Context, userID int, disabled bool) error {
+	if err := a.users.SetUserDisabled(ctx, userID, disabled); err != nil {
+		return err
+	}
+	if disabled {
+		a.roles.auth.LogoutUser(userID)
+	}
+	return nil
+}
+
+// ForceLogout closes every session of the user
+func (a *UserAdmin) ForceLogout(ctx context.Context, userID int) error {
+	if _, err := a.users.GetUser(ctx, userID); err != nil {
+		return err
+	}
+	a.roles.auth.LogoutUser(userID)
+	return nil
+}
+
+// SetRoles replaces the roles assigned to the user directly
+func (a *UserAdmin) SetRoles(ctx context.Context, userID int, roles []string) error {
+	if err := a.users.SetUserRoles(ctx, userID, roles); err != nil {
+		return err
+	}
+	return a.roles.rolesChanged(ctx, userID)
+}
+
+// DeleteUser logs the user out and makes the account pending deletion on behalf of the admin by,
+// the Purger deletes it after the grace period unless RestoreUser is called first.
+// Logging in doesn't keep the account, unlike a deletion the user asked for.
+func (a *UserAdmin) DeleteUser(ctx context.Context, userID, by int) error {
+	if err := a.users.RequestDeletion(ctx, userID, by); err != nil {
+		return err
+	}
+	a.roles.auth.LogoutUser(userID)
+	return nil
+}
+
+// RestoreUser cancels the deletion of the account
+func (a *UserAdmin) RestoreUser(ctx context.Context, userID int) error {
+	return a.users.CancelDeletion(ctx, userID)
+}
@@ -0,0 +1,131 @@
+// Package adminpanel is the tables of the application in GoAdmin
+package adminpanel
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"errors"
+	"html"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/GoAdminGroup/go-admin/context"
+	"github.com/GoAdminGroup/go-admin/modules/db"
+	"github.com/GoAdminGroup/go-admin/plugins/admin/modules/parameter"
+	"github.com/GoAdminGroup/go-admin/plugins/admin/modules/table"
+	"github.com/GoAdminGroup/go-admin/template/types"
+)
+
+// UserTable lists the accounts through UserAdmin, so the emails are decrypted.
+// The search filter is SearchUsers: the closest first with the matches highlighted.
+// Read only, the changes go through the admin API.
+func UserTable(users *access.UserAdmin) table.Generator {
+	return func(ctx *context.Context) table.Table {
+		t := table.NewDefaultTable(ctx, table.Config{
+			Driver:     db.DriverPostgresql,
+			Connection: table.DefaultConnectionName,
+			PrimaryKey: table.PrimaryKey{Type: db.Int, Name: table.DefaultPrimaryKeyName},
+		})
+
+		info := t.GetInfo().SetTitle("Accounts").SetDescription("Users of the application").
+			HideNewButton().HideEditButton().HideDeleteButton()
+		info.AddField("ID", "id", db.Int).FieldSortable()
+		info.AddField("Search", "q", db.Varchar).FieldHide().
+			FieldFilterable(types.FilterType{Placeholder: "Username or email, typos are fine"})
+		info.AddField("Username", "username", db.Varchar).FieldSortable()
+		info.AddField("Email", "email", db.Varchar).FieldSortable()
+		info.AddField("Roles", "roles", db.Varchar)
+		info.AddField("Status", "status", db.Varchar)
+		info.AddField("Created", "created_at", db.Varchar).FieldSortable()
+
+		info.SetGetDataFn(func(param parameter.Parameters) ([]map[string]interface{}, int) {
+			if q := strings.TrimSpace(param.GetFieldValue("q")); q != "" {
+				return searchRows(ctx, users, q, param)
+			}
+			return listRows(ctx, users, param)
+		})
+		return t
+	}
+}
+
+// searchRows is one page of the matches, SearchUsers returns MaxSearchLimit of them at most
+func searchRows(ctx *context.Context, users *access.UserAdmin, q string, param parameter.Parameters) ([]map[string]interface{}, int) {
+	matches, err := users.SearchUsers(ctx.Request.Context(), q, access.MaxSearchLimit)
+	if err != nil {
+		log.Printf("GoAdmin user search: %v", err)
+		return []map[string]interface{}{}, 0
+	}
+	start := min((param.PageInt-1)*param.PageSizeInt, len(matches))
+	end := min(start+param.PageSizeInt, len(matches))
+	rows := make([]map[string]interface{}, 0, end-start)
+	for _, m := range matches[start:end] {
+		row := userRow(m.User)
+		for _, field := range []string{"username", "email"} {
+			row[field] = highlight(row[field].(string), field, m.Highlights)
+		}
+		rows = append(rows, row)
+	}
+	return rows, len(matches)
+}
+
+// listRows is the page of ListUsers by offset, the total is cut to the pages
+// within MaxUserOffset
+func listRows(ctx *context.Context, users *access.UserAdmin, param parameter.Parameters) ([]map[string]interface{}, int) {
+	filter := repository.UserFilter{Sort: param.SortField, Desc: param.SortType != "asc",
+		Limit: param.PageSizeInt, Offset: (param.PageInt - 1) * param.PageSizeInt, CountTotal: true}
+	if _, ok := repository.UserSortColumns[filter.Sort]; !ok {
+		filter.Sort = "id"
+	}
+	if filter.Offset < 0 || filter.Offset > access.MaxUserOffset {
+		return []map[string]interface{}{}, 0
+	}
+	page, err := users.ListUsers(ctx.Request.Context(), filter)
+	if errors.Is(err, repository.ErrEncryptedSort) {
+		filter.Sort = "id"
+		page, err = users.ListUsers(ctx.Request.Context(), filter)
+	}
+	if err != nil {
+		log.Printf("GoAdmin user list: %v", err)
+		return []map[string]interface{}{}, 0
+	}
+	rows := make([]map[string]interface{}, len(page.Users))
+	for i, u := range page.Users {
+		rows[i] = userRow(u)
+		rows[i]["username"] = html.EscapeString(u.Username)
+		rows[i]["email"] = html.EscapeString(u.Email)
+	}
+	return rows, min(*page.Total, access.MaxUserOffset+param.PageSizeInt)
+}
+
+// userRow is u with the username and the email as they are, the callers escape them
+func userRow(u repository.User) map[string]interface{} {
+	created := ""
+	if u.CreatedAt != nil {
+		created = u.CreatedAt.Format(time.DateTime)
+	}
+	return map[string]interface{}{
+		"id":         u.ID,
+		"username":   u.Username,
+		"email":      u.Email,
+		"roles":      html.EscapeString(strings.Join(u.Roles, ", ")),
+		"status":     string(u.Status),
+		"created_at": created,
+	}
+}
+
+// highlight escapes the value and wraps its highlights of field in <mark>
+func highlight(value, field string, highlights []repository.Highlight) string {
+	var b strings.Builder
+	last := 0
+	for _, h := range highlights {
+		if h.Field != field || h.Start < last || h.End > len(value) {
+			continue
+		}
+		b.WriteString(html.EscapeString(value[last:h.Start]))
+		b.WriteString("<mark>" + html.EscapeString(value[h.Start:h.End]) + "</mark>")
+		last = h.End
+	}
+	b.WriteString(html.EscapeString(value[last:]))
+	return b.String()
+}
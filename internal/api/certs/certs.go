@@ -0,0 +1,215 @@
+// Package certs loads the gRPC server certificate and the client CA bundle
+// and reloads them when the files change, so certificates can be rotated without a restart.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoCertificate = errors.New("certs: certificate and key files are required")
+
+// Config is read from the environment, see FromEnv
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile turns on mutual TLS: clients must present a certificate signed by one of these CAs
+	ClientCAFile string
+	// ClientCertOptional accepts clients without a certificate (they authenticate with a token instead),
+	// certificates that are presented are still verified
+	ClientCertOptional bool
+}
+
+// FromEnv reads GRPC_TLS_CERT, GRPC_TLS_KEY, GRPC_TLS_CLIENT_CA and GRPC_TLS_CLIENT_AUTH (require or optional).
+// ok is false when no certificate is configured and the server should stay in plaintext.
+func FromEnv() (cfg Config, ok bool, err error) {
+	cfg = Config{
+		CertFile:     os.Getenv("GRPC_TLS_CERT"),
+		KeyFile:      os.Getenv("GRPC_TLS_KEY"),
+		ClientCAFile: os.Getenv("GRPC_TLS_CLIENT_CA"),
+	}
+	switch mode := os.Getenv("GRPC_TLS_CLIENT_AUTH"); mode {
+	case "", "require":
+	case "optional":
+		cfg.ClientCertOptional = true
+	default:
+		return cfg, false, fmt.Errorf("unknown GRPC_TLS_CLIENT_AUTH %q", mode)
+	}
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		if cfg.ClientCAFile != "" {
+			return cfg, false, ErrNoCertificate
+		}
+		return cfg, false, nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return cfg, false, ErrNoCertificate
+	}
+	return cfg, true, nil
+}
+
+// Reloader holds the current certificate and CA pool.
+// Every handshake reads them under the lock, so a reload takes effect on the next connection.
+type Reloader struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// NewReloader loads the files once, a broken certificate fails startup
+func NewReloader(cfg Config) (*Reloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, ErrNoCertificate
+	}
+	r := &Reloader{cfg: cfg}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate, key and CA bundle again.
+// On error the previous ones stay in use.
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("certs: loading key pair: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("certs: reading client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("certs: no certificates in %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCA = pool
+	r.modTimes = r.stat()
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) stat() map[string]time.Time {
+	times := make(map[string]time.Time)
+	for _, f := range r.files() {
+		if info, err := os.Stat(f); err == nil {
+			times[f] = info.ModTime()
+		}
+	}
+	return times
+}
+
+func (r *Reloader) changed() bool {
+	current := r.stat()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, f := range r.files() {
+		if !current[f].Equal(r.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch polls the files every interval and reloads them when one changed, until ctx is done.
+// Polling also picks up the symlink swaps Kubernetes does for mounted secrets.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Printf("Keeping the old gRPC certificate: %v", err)
+				continue
+			}
+			log.Println("Reloaded gRPC certificate")
+		}
+	}
+}
+
+// TLSConfig returns a server config that always uses the latest certificate and CA pool
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			// the per-connection config replaces the outer one, so it has to offer h2 itself
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{"h2"},
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCA != nil {
+				cfg.ClientCAs = r.clientCA
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				if r.cfg.ClientCertOptional {
+					cfg.ClientAuth = tls.VerifyClientCertIfGiven
+				}
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// Identity returns the service identity of a verified client certificate:
+// the first URI SAN (e.g. spiffe://authdb/billing), else the first DNS SAN, else the common name
+func Identity(cert *x509.Certificate) string {
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// ParseIdentities parses GRPC_TLS_IDENTITIES, which maps client certificate identities to roles:
+// "spiffe://authdb/billing=service;reports.internal=service admin"
+func ParseIdentities(s string) (map[string][]string, error) {
+	identities := make(map[string][]string)
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, roles, ok := strings.Cut(entry, "=")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" || strings.TrimSpace(roles) == "" {
+			return nil, fmt.Errorf("certs: invalid identity %q, expected identity=role ...", entry)
+		}
+		identities[id] = strings.Fields(roles)
+	}
+	return identities, nil
+}
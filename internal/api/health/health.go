@@ -0,0 +1,95 @@
+// Package health reports the readiness of the dependencies through the standard grpc.health.v1 service.
+package health
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Probe checks one dependency, e.g. pinging the database
+type Probe func(ctx context.Context) error
+
+// Monitor runs the probes periodically and marks the server and every listed service
+// SERVING when all probes pass, NOT_SERVING otherwise
+type Monitor struct {
+	server   *health.Server
+	services []string
+	probes   map[string]Probe
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	failed map[string]error
+}
+
+func NewMonitor(server *health.Server, services []string, probes map[string]Probe) *Monitor {
+	return &Monitor{server: server, services: services, probes: probes, timeout: 2 * time.Second}
+}
+
+// Check runs every probe once and updates the serving status,
+// the result holds the probes that failed
+func (m *Monitor) Check(ctx context.Context) map[string]error {
+	names := make([]string, 0, len(m.probes))
+	for name := range m.probes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := make(map[string]error)
+	for _, name := range names {
+		probeCtx, cancel := context.WithTimeout(ctx, m.timeout)
+		err := m.probes[name](probeCtx)
+		cancel()
+		if err != nil {
+			failed[name] = err
+		}
+	}
+
+	status := healthpb.HealthCheckResponse_SERVING
+	if len(failed) > 0 {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	m.server.SetServingStatus("", status)
+	for _, service := range m.services {
+		m.server.SetServingStatus(service, status)
+	}
+	m.logChanges(names, failed)
+	return failed
+}
+
+// only transitions are logged, not every tick
+func (m *Monitor) logChanges(names []string, failed map[string]error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		_, was := m.failed[name]
+		err, is := failed[name]
+		switch {
+		case is && !was:
+			log.Printf("Health: %s is down: %v", name, err)
+		case was && !is:
+			log.Printf("Health: %s is back", name)
+		}
+	}
+	m.failed = failed
+}
+
+// Run checks right away and then every interval until ctx is done
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	m.Check(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check(ctx)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package interceptor
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"AuthDB/internal/api/certs"
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// MethodRule declares who may call a gRPC method: anyone if Public (bad credentials make
+// the caller anonymous), otherwise a caller with Resource:Action, or any caller if both are empty.
+type MethodRule struct {
+	Public   bool
+	Resource string
+	Action   string
+}
+
+// Rules maps full method names ("/package.Service/Method") to their rule,
+// "/package.Service/*" covers every method of the service.
+// Methods without a rule are denied.
+type Rules map[string]MethodRule
+
+func (r Rules) lookup(method string) (MethodRule, bool) {
+	if rule, ok := r[method]; ok {
+		return rule, true
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		rule, ok := r[method[:i]+"/*"]
+		return rule, ok
+	}
+	return MethodRule{}, false
+}
+
+// Auth authenticates the caller from the metadata and enforces the rules.
+// Users send "authorization: Bearer <token>", services "x-api-key: <key>"
+// or, over mutual TLS, a client certificate.
+type Auth struct {
+	auth       *auth.Service
+	repo       *repository.Repository
+	checker    *access.Checker
+	rules      Rules
+	identities map[string][]string
+}
+
+func NewAuth(authService *auth.Service, repo *repository.Repository, checker *access.Checker, rules Rules) *Auth {
+	return &Auth{auth: authService, repo: repo, checker: checker, rules: rules}
+}
+
+// TrustClientCertificates authenticates callers by their verified client certificate.
+// identities maps the certificate identity (see certs.Identity) to the roles of the service,
+// certificates with an unknown identity are rejected.
+func (a *Auth) TrustClientCertificates(identities map[string][]string) {
+	a.identities = identities
+}
+
+func (a *Auth) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *Auth) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (a *Auth) authorize(ctx context.Context, method string) (context.Context, error) {
+	rule, ok := a.rules.lookup(method)
+	if !ok {
+		return ctx, status.Errorf(codes.PermissionDenied, "method %s is not allowed", method)
+	}
+
+	principal, err := a.authenticate(ctx)
+	if err != nil && rule.Public {
+		// a stale token or an unknown key doesn't lock the caller out of the public methods,
+		// ValidateToken is called with tokens that may be bad
+		return ctx, nil
+	}
+	if err != nil {
+		return ctx, err
+	}
+	ctx = WithPrincipal(ctx, principal)
+
+	if rule.Public || rule.Resource == "" {
+		return ctx, nil
+	}
+	if d := a.checker.Check(ctx, principal, rule.Resource, rule.Action); !d.Allowed {
+		return ctx, status.Error(codes.PermissionDenied, d.Reason)
+	}
+	return ctx, nil
+}
+
+var errNoCredentials = status.Error(codes.Unauthenticated, "missing bearer token or api key")
+
+func (a *Auth) authenticate(ctx context.Context) (access.Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if keys := md.Get("x-api-key"); len(keys) > 0 {
+		return a.apiKey(ctx, keys[0])
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return a.clientCertificate(ctx)
+	}
+	scheme, credentials, _ := strings.Cut(values[0], " ")
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		claims, err := a.auth.Validate(credentials)
+		if err != nil {
+			return access.Principal{}, status.Error(codes.Unauthenticated, "Invalid token")
+		}
+		return access.Principal{UserID: claims.UserID, Username: claims.Username, Roles: claims.Roles}, nil
+	case "apikey":
+		return a.apiKey(ctx, credentials)
+	}
+	return access.Principal{}, status.Error(codes.Unauthenticated, "unsupported authorization scheme")
+}
+
+func (a *Auth) apiKey(ctx context.Context, key string) (access.Principal, error) {
+	apiKey, err := a.repo.FindAPIKey(ctx, nil, key)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return access.Principal{}, status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	if err != nil {
+		log.Printf("Error looking up api key: %v", err)
+		return access.Principal{}, status.Error(codes.Internal, "something went wrong, please try later")
+	}
+	return access.APIKeyPrincipal(apiKey.Name, apiKey.Roles), nil
+}
+
+func (a *Auth) clientCertificate(ctx context.Context) (access.Principal, error) {
+	if a.identities == nil {
+		return access.Principal{}, errNoCredentials
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return access.Principal{}, errNoCredentials
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	// only chains the server verified against the client CA count
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return access.Principal{}, errNoCredentials
+	}
+	id := certs.Identity(tlsInfo.State.VerifiedChains[0][0])
+	roles, ok := a.identities[id]
+	if !ok {
+		return access.Principal{}, status.Errorf(codes.Unauthenticated, "unknown client certificate %s", id)
+	}
+	return access.CertificatePrincipal(id, roles), nil
+}
+
+// serverStream replaces the context of a stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,35 @@
+// Package interceptor holds the gRPC server interceptors:
+// authentication, per-method authorization, logging, panic recovery and deadlines.
+package interceptor
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"context"
+)
+
+type principalKey struct{}
+
+// callerKey holds a *caller put there by Logging,
+// so the outer interceptor learns who the inner one authenticated
+type callerKey struct{}
+
+type caller struct {
+	principal *access.Principal
+}
+
+// WithPrincipal stores the authenticated caller in the context,
+// it is the session of the reads like on HTTP (repository.WithSession)
+func WithPrincipal(ctx context.Context, p access.Principal) context.Context {
+	if c, ok := ctx.Value(callerKey{}).(*caller); ok {
+		c.principal = &p
+	}
+	return repository.WithSession(context.WithValue(ctx, principalKey{}, p), p.String())
+}
+
+// PrincipalFromContext returns the caller the Auth interceptor authenticated,
+// ok is false for public methods called without credentials
+func PrincipalFromContext(ctx context.Context) (access.Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(access.Principal)
+	return p, ok
+}
@@ -0,0 +1,99 @@
+package interceptor
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Logging logs every call with its status code, caller and duration
+func Logging() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		c := &caller{}
+		resp, err := handler(context.WithValue(ctx, callerKey{}, c), req)
+		logCall(c, info.FullMethod, start, err)
+		return resp, err
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		c := &caller{}
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), callerKey{}, c)})
+		logCall(c, info.FullMethod, start, err)
+		return err
+	}
+	return unary, stream
+}
+
+func logCall(c *caller, method string, start time.Time, err error) {
+	who := "anonymous"
+	if c.principal != nil {
+		who = c.principal.String()
+	}
+	log.Printf("gRPC %s %s %s %v", method, status.Code(err), who, time.Since(start))
+}
+
+// Recovery turns a panic in a handler into codes.Internal instead of crashing the server
+func Recovery() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+	return unary, stream
+}
+
+func recovered(method string, r interface{}) error {
+	log.Printf("gRPC %s panic: %v\n%s", method, r, debug.Stack())
+	return status.Error(codes.Internal, "something went wrong, please try later")
+}
+
+// Deadline gives calls without a deadline the default one,
+// so a stuck database call can't hold a request forever
+func Deadline(timeout time.Duration) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if _, ok := ss.Context().Deadline(); !ok {
+			ctx, cancel := context.WithTimeout(ss.Context(), timeout)
+			defer cancel()
+			ss = &serverStream{ServerStream: ss, ctx: ctx}
+		}
+		return handler(srv, ss)
+	}
+	return unary, stream
+}
+
+// ServerOptions chains the interceptors: recovery first so it sees every panic,
+// then logging, the default deadline and finally authentication
+func ServerOptions(a *Auth, timeout time.Duration) []grpc.ServerOption {
+	recoverUnary, recoverStream := Recovery()
+	logUnary, logStream := Logging()
+	deadlineUnary, deadlineStream := Deadline(timeout)
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(recoverUnary, logUnary, deadlineUnary, a.Unary()),
+		grpc.ChainStreamInterceptor(recoverStream, logStream, deadlineStream, a.Stream()),
+	}
+}
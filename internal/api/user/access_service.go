@@ -1,17 +1,33 @@
 package user
 
 import (
-	"AuthDB/internal/helper"
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"AuthDB/internal/policy"
 	pb "AuthDB/pkg/user_v1"
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type AccessService struct {
 	pb.UnimplementedAuthServiceServer
+	auth    *auth.Service
+	checker *access.Checker
+}
+
+// The auth service is shared with the HTTP app,
+// so a session opened over gRPC is also valid for the web pages and vice versa
+func NewAccessService(authService *auth.Service, checker *access.Checker) *AccessService {
+	return &AccessService{auth: authService, checker: checker}
 }
 
 func Register(grpcServer *grpc.Server, service *AccessService) {
@@ -19,39 +35,183 @@ func Register(grpcServer *grpc.Server, service *AccessService) {
 }
 
 func (s *AccessService) CheckAccess(ctx context.Context, req *pb.AccessRequest) (*pb.AccessResponse, error) {
-	user, err := helper.GetUserByToken(req.Token)
+	claims, err := s.auth.Validate(req.Token)
 	if err != nil {
 		return &pb.AccessResponse{
 			HasAccess: false,
 			Message:   "Invalid token",
 		}, nil
 	}
+	principal := principalOf(claims)
 
-	if user.Role != req.RequiredRole {
-		return &pb.AccessResponse{
-			HasAccess: false,
-			Message:   "Access denied",
+	var decision access.Decision
+	if req.Resource == "" && req.Action == "" && req.RequiredRole != "" {
+		decision = s.checkRole(ctx, principal, req.RequiredRole)
+	} else {
+		check := access.Check{Resource: req.Resource, Action: req.Action, Attributes: req.Attributes}
+		decision = s.checker.CheckAll(ctx, principal, []access.Check{check})[0]
+	}
+
+	resp := &pb.AccessResponse{
+		HasAccess: decision.Allowed,
+		Message:   decision.Reason,
+	}
+	if req.Explain {
+		resp.Trace = traceToProto(decision.Trace)
+	}
+	return resp, nil
+}
+
+func (s *AccessService) BatchCheckAccess(ctx context.Context, req *pb.BatchAccessRequest) (*pb.BatchAccessResponse, error) {
+	claims, err := s.auth.Validate(req.Token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid token")
+	}
+	checks := make([]access.Check, len(req.Checks))
+	for i, c := range req.Checks {
+		checks[i] = access.Check{Resource: c.Resource, Action: c.Action, Attributes: c.Attributes}
+	}
+
+	resp := &pb.BatchAccessResponse{}
+	for _, d := range s.checker.CheckAll(ctx, principalOf(claims), checks) {
+		decision := &pb.AccessDecision{
+			Resource: d.Resource,
+			Action:   d.Action,
+			Allowed:  d.Allowed,
+			Reason:   d.Reason,
+		}
+		if req.Explain {
+			decision.Trace = traceToProto(d.Trace)
+		}
+		resp.Decisions = append(resp.Decisions, decision)
+	}
+	return resp, nil
+}
+
+func principalOf(claims *auth.Claims) access.Principal {
+	return access.Principal{UserID: claims.UserID, Username: claims.Username, Roles: claims.Roles}
+}
+
+func traceToProto(trace []policy.Trace) []*pb.PolicyTrace {
+	result := make([]*pb.PolicyTrace, len(trace))
+	for i, t := range trace {
+		result[i] = &pb.PolicyTrace{
+			PolicyId: t.PolicyID,
+			Effect:   string(t.Effect),
+			Applied:  t.Applied,
+			Detail:   t.Detail,
+		}
+	}
+	return result
+}
+
+// Old clients send only a role name.
+// The role passes when the user has it or inherits it, so does full access.
+func (s *AccessService) checkRole(ctx context.Context, p access.Principal, requiredRole string) access.Decision {
+	ok, err := s.checker.HasRole(ctx, p, requiredRole)
+	if err != nil {
+		log.Printf("Error loading roles of user %d: %v", p.UserID, err)
+		return access.Decision{Allowed: false, Reason: "role lookup failed"}
+	}
+	if ok {
+		return access.Decision{Allowed: true, Reason: "Access granted"}
+	}
+	d := s.checker.Check(ctx, p, repository.Wildcard, repository.Wildcard)
+	if !d.Allowed {
+		d.Reason = "Access denied"
+	}
+	return d
+}
+
+func (s *AccessService) Authenticate(ctx context.Context, req *pb.AuthenticateRequest) (*pb.AuthenticateResponse, error) {
+	if req.Username == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "You must provide a username and password")
+	}
+
+	session, err := s.auth.Login(ctx, req.Username, req.Password, req.RememberMe)
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound), errors.Is(err, auth.ErrInvalidCredentials):
+		// don't tell the caller which of the two was wrong
+		return nil, status.Error(codes.Unauthenticated, "invalid username or password")
+	case errors.Is(err, auth.ErrAccountLocked), errors.Is(err, auth.ErrAccountDisabled),
+		errors.Is(err, auth.ErrAccountDeleted):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		log.Printf("Error authenticating user: %v", err)
+		return nil, status.Error(codes.Internal, "something went wrong, please try later")
+	}
+
+	return &pb.AuthenticateResponse{
+		AccessToken: session.Token,
+		TokenType:   "Bearer",
+		ExpiresAt:   session.ExpiresAt.Unix(),
+		UserId:      int64(session.User.ID),
+	}, nil
+}
+
+func (s *AccessService) ValidateToken(ctx context.Context, req *pb.ValidateTokenRequest) (*pb.ValidateTokenResponse, error) {
+	claims, err := s.auth.Validate(req.Token)
+	if err != nil {
+		return &pb.ValidateTokenResponse{
+			Valid:   false,
+			Message: "Invalid token",
 		}, nil
 	}
 
-	return &pb.AccessResponse{
-		HasAccess: true,
-		Message:   "Access granted",
+	return &pb.ValidateTokenResponse{
+		Valid: true,
+		Claims: &pb.Claims{
+			UserId:    int64(claims.UserID),
+			Username:  claims.Username,
+			Roles:     claims.Roles,
+			ExpiresAt: claims.ExpiresAt.Unix(),
+		},
+		Message: "Token is valid",
 	}, nil
 }
 
-func StartGRPCServer(port string, accessService *AccessService) error {
-	grpcServer := grpc.NewServer()
+func (s *AccessService) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	if err := s.auth.Logout(req.Token); err != nil {
+		return &pb.LogoutResponse{Success: false}, nil
+	}
+	return &pb.LogoutResponse{Success: true}, nil
+}
+
+// NewGRPCServer registers the services on a new server without starting it.
+// roleService is optional, nil leaves RoleService unregistered.
+// opts usually carry the interceptors, see interceptor.ServerOptions
+func NewGRPCServer(accessService *AccessService, roleService *RoleService, opts ...grpc.ServerOption) *grpc.Server {
+	grpcServer := grpc.NewServer(opts...)
 
 	Register(grpcServer, accessService)
+	if roleService != nil {
+		RegisterRoleService(grpcServer, roleService)
+	}
+	return grpcServer
+}
 
+// StartGRPCServer serves until the listener fails,
+// main uses NewGRPCServer instead so it can stop the server gracefully
+func StartGRPCServer(port string, accessService *AccessService, roleService *RoleService, opts ...grpc.ServerOption) error {
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
-	}
-	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+		return fmt.Errorf("failed to listen: %v", err)
 	}
+	return NewGRPCServer(accessService, roleService, opts...).Serve(lis)
+}
 
-	return nil
+// GracefulStop waits for the running calls to finish,
+// calls still running after timeout are cancelled
+func GracefulStop(grpcServer *grpc.Server, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Println("gRPC graceful stop timed out, closing the remaining calls")
+		grpcServer.Stop()
+	}
 }
@@ -0,0 +1,94 @@
+package user
+
+import (
+	"AuthDB/internal/access"
+	"context"
+	"log"
+	"net/http"
+	"sort"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+)
+
+// ExtAuthz is the Envoy external authorizer (envoy.service.auth.v3.Authorization).
+// Envoy sends every request of the protected services here,
+// the session comes from the bearer token or the "token" cookie.
+type ExtAuthz struct {
+	authv3.UnimplementedAuthorizationServer
+	gate *access.Gate
+}
+
+// ExtAuthz shares sessions and decisions with the access service,
+// routes tell which permission each path needs
+func (s *AccessService) ExtAuthz(routes access.Routes) *ExtAuthz {
+	return &ExtAuthz{gate: access.NewGate(s.auth, s.checker, routes)}
+}
+
+func RegisterExtAuthz(grpcServer *grpc.Server, service *ExtAuthz) {
+	authv3.RegisterAuthorizationServer(grpcServer, service)
+}
+
+func (s *ExtAuthz) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	// Envoy lower-cases the header names
+	headers := httpReq.GetHeaders()
+
+	d := s.gate.Authorize(ctx, access.ProxyRequest{
+		Method: httpReq.GetMethod(),
+		Path:   httpReq.GetPath(),
+		Host:   httpReq.GetHost(),
+		Token:  access.SessionToken(headers["authorization"], headers["cookie"]),
+	})
+	if !d.Allowed() {
+		log.Printf("ext_authz: %s %s denied: %s", httpReq.GetMethod(), httpReq.GetPath(), d.Reason)
+		return denied(d), nil
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(code.Code_OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{
+				Headers:         headerOptions(d.Headers()),
+				HeadersToRemove: []string{access.HeaderUserID, access.HeaderUser, access.HeaderRoles},
+			},
+		},
+	}, nil
+}
+
+func denied(d access.GateDecision) *authv3.CheckResponse {
+	grpcCode, httpCode := code.Code_PERMISSION_DENIED, typev3.StatusCode_Forbidden
+	if d.Status == http.StatusUnauthorized {
+		grpcCode, httpCode = code.Code_UNAUTHENTICATED, typev3.StatusCode_Unauthorized
+	}
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(grpcCode), Message: d.Reason},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: httpCode},
+				Body:   http.StatusText(d.Status),
+			},
+		},
+	}
+}
+
+func headerOptions(headers map[string]string) []*corev3.HeaderValueOption {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	options := make([]*corev3.HeaderValueOption, len(keys))
+	for i, k := range keys {
+		options[i] = &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: k, Value: headers[k]},
+			AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+		}
+	}
+	return options
+}
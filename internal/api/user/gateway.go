@@ -0,0 +1,81 @@
+package user
+
+import (
+	"AuthDB/internal/access"
+	pb "AuthDB/pkg/user_v1"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// NewGateway serves AuthService and RoleService as JSON over HTTP (grpc-gateway),
+// the routes come from the google.api.http options in api/user_v1/user.proto.
+// Calls go to grpcServer through an in-memory connection without TLS, so grpcServer
+// is a plaintext one of its own with the interceptors of the public server (and no
+// grpc.Creds): they pass the same checks as the gRPC clients. The connection ends
+// when grpcServer stops.
+func NewGateway(ctx context.Context, grpcServer *grpc.Server) (http.Handler, error) {
+	lis := bufconn.Listen(1 << 20)
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Printf("gRPC gateway listener: %v", err)
+		}
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///gateway",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("gateway: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	gwmux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(gatewayHeader),
+		runtime.WithMetadata(sessionCookie),
+	)
+	if err := pb.RegisterAuthServiceHandler(ctx, gwmux, conn); err != nil {
+		return nil, fmt.Errorf("gateway: %w", err)
+	}
+	if err := pb.RegisterRoleServiceHandler(ctx, gwmux, conn); err != nil {
+		return nil, fmt.Errorf("gateway: %w", err)
+	}
+	if err := pb.RegisterUserServiceHandler(ctx, gwmux, conn); err != nil {
+		return nil, fmt.Errorf("gateway: %w", err)
+	}
+	return gwmux, nil
+}
+
+// Authorization is always forwarded by the gateway, X-Api-Key has to be let through
+func gatewayHeader(key string) (string, bool) {
+	if strings.EqualFold(key, "X-Api-Key") {
+		return "x-api-key", true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// Browsers calling the gateway send the session cookie instead of a bearer token
+func sessionCookie(_ context.Context, r *http.Request) metadata.MD {
+	if r.Header.Get("Authorization") != "" {
+		return nil
+	}
+	if token := access.SessionToken("", r.Header.Get("Cookie")); token != "" {
+		return metadata.Pairs("authorization", "Bearer "+token)
+	}
+	return nil
+}
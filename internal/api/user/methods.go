@@ -0,0 +1,34 @@
+package user
+
+import (
+	"AuthDB/internal/api/interceptor"
+	pb "AuthDB/pkg/user_v1"
+)
+
+// Who may call what. Methods missing here are denied by the auth interceptor.
+var MethodRules = interceptor.Rules{
+	// the token or the credentials travel in the request itself
+	"/access.AuthService/CheckAccess":      {Public: true},
+	"/access.AuthService/BatchCheckAccess": {Public: true},
+	"/access.AuthService/Authenticate":     {Public: true},
+	"/access.AuthService/ValidateToken":    {Public: true},
+	"/access.AuthService/Logout":           {Public: true},
+
+	"/access.RoleService/*": {Resource: "roles", Action: "manage"},
+	"/access.UserService/*": {Resource: "users", Action: "manage"},
+
+	// Envoy forwards the session of the end user inside the check request
+	"/envoy.service.auth.v3.Authorization/Check": {Public: true},
+
+	// probes and tooling, reflection is only registered when GRPC_REFLECTION is on
+	"/grpc.health.v1.Health/*":                    {Public: true},
+	"/grpc.reflection.v1.ServerReflection/*":      {Public: true},
+	"/grpc.reflection.v1alpha.ServerReflection/*": {Public: true},
+}
+
+// ServiceNames are reported by the health service next to the overall "" status
+var ServiceNames = []string{
+	pb.AuthService_ServiceDesc.ServiceName,
+	pb.RoleService_ServiceDesc.ServiceName,
+	pb.UserService_ServiceDesc.ServiceName,
+}
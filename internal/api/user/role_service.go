@@ -0,0 +1,165 @@
+package user
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	pb "AuthDB/pkg/user_v1"
+	"context"
+	"errors"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// The callers are authorized by the interceptors, see MethodRules
+type RoleService struct {
+	pb.UnimplementedRoleServiceServer
+	admin *access.RoleAdmin
+}
+
+func NewRoleService(admin *access.RoleAdmin) *RoleService {
+	return &RoleService{admin: admin}
+}
+
+func RegisterRoleService(grpcServer *grpc.Server, service *RoleService) {
+	pb.RegisterRoleServiceServer(grpcServer, service)
+}
+
+func (s *RoleService) ListRoles(ctx context.Context, _ *pb.ListRolesRequest) (*pb.ListRolesResponse, error) {
+	roles, err := s.admin.ListRoles(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &pb.ListRolesResponse{}
+	for _, r := range roles {
+		resp.Roles = append(resp.Roles, roleToProto(r))
+	}
+	return resp, nil
+}
+
+func (s *RoleService) CreateRole(ctx context.Context, req *pb.CreateRoleRequest) (*pb.Role, error) {
+	role := repository.Role{Name: req.Name, Description: req.Description, ParentID: optionalID(req.ParentId)}
+	if err := s.admin.CreateRole(ctx, &role); err != nil {
+		return nil, toStatus(err)
+	}
+	return roleToProto(role), nil
+}
+
+func (s *RoleService) DeleteRole(ctx context.Context, req *pb.DeleteRoleRequest) (*emptypb.Empty, error) {
+	if err := s.admin.DeleteRole(ctx, int(req.RoleId)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *RoleService) SetRoleParent(ctx context.Context, req *pb.SetRoleParentRequest) (*emptypb.Empty, error) {
+	if err := s.admin.SetRoleParent(ctx, int(req.RoleId), optionalID(req.ParentId)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *RoleService) ListPermissions(ctx context.Context, _ *pb.ListPermissionsRequest) (*pb.ListPermissionsResponse, error) {
+	permissions, err := s.admin.ListPermissions(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.ListPermissionsResponse{Permissions: permissionsToProto(permissions)}, nil
+}
+
+func (s *RoleService) CreatePermission(ctx context.Context, req *pb.CreatePermissionRequest) (*pb.Permission, error) {
+	p := repository.Permission{Resource: req.Resource, Action: req.Action, Description: req.Description}
+	if err := s.admin.CreatePermission(ctx, &p); err != nil {
+		return nil, toStatus(err)
+	}
+	return permissionToProto(p), nil
+}
+
+func (s *RoleService) GrantPermission(ctx context.Context, req *pb.RolePermissionRequest) (*emptypb.Empty, error) {
+	if err := s.admin.GrantPermission(ctx, int(req.RoleId), int(req.PermissionId)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *RoleService) RevokePermission(ctx context.Context, req *pb.RolePermissionRequest) (*emptypb.Empty, error) {
+	if err := s.admin.RevokePermission(ctx, int(req.RoleId), int(req.PermissionId)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *RoleService) AssignRole(ctx context.Context, req *pb.UserRoleRequest) (*emptypb.Empty, error) {
+	if err := s.admin.AssignRole(ctx, int(req.UserId), int(req.RoleId)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *RoleService) UnassignRole(ctx context.Context, req *pb.UserRoleRequest) (*emptypb.Empty, error) {
+	if err := s.admin.UnassignRole(ctx, int(req.UserId), int(req.RoleId)); err != nil {
+		return nil, toStatus(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *RoleService) GetUserRoles(ctx context.Context, req *pb.GetUserRolesRequest) (*pb.GetUserRolesResponse, error) {
+	userRoles, err := s.admin.UserRoles(ctx, int(req.UserId))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &pb.GetUserRolesResponse{
+		EffectiveRoles: userRoles.EffectiveRoles,
+		Permissions:    permissionsToProto(userRoles.Permissions),
+	}
+	for _, r := range userRoles.Roles {
+		resp.Roles = append(resp.Roles, roleToProto(r))
+	}
+	return resp, nil
+}
+
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, access.ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, repository.ErrRoleNotFound), errors.Is(err, repository.ErrReferenceNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, repository.ErrRoleExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, repository.ErrRoleCycle):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	log.Printf("Error managing roles: %v", err)
+	return status.Error(codes.Internal, "something went wrong, please try later")
+}
+
+func optionalID(id int64) *int {
+	if id == 0 {
+		return nil
+	}
+	v := int(id)
+	return &v
+}
+
+func roleToProto(r repository.Role) *pb.Role {
+	role := &pb.Role{Id: int64(r.ID), Name: r.Name, Description: r.Description}
+	if r.ParentID != nil {
+		role.ParentId = int64(*r.ParentID)
+	}
+	return role
+}
+
+func permissionToProto(p repository.Permission) *pb.Permission {
+	return &pb.Permission{Id: int64(p.ID), Resource: p.Resource, Action: p.Action, Description: p.Description}
+}
+
+func permissionsToProto(permissions []repository.Permission) []*pb.Permission {
+	result := make([]*pb.Permission, len(permissions))
+	for i, p := range permissions {
+		result[i] = permissionToProto(p)
+	}
+	return result
+}
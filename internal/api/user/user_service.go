@@ -0,0 +1,158 @@
+package user
+
+import (
+	"AuthDB/cmd/app/controller/helper"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	pb "AuthDB/pkg/user_v1"
+	"AuthDB/utils"
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The callers are authorized by the interceptors, see MethodRules
+type UserService struct {
+	pb.UnimplementedUserServiceServer
+	admin *access.UserAdmin
+}
+
+func NewUserService(admin *access.UserAdmin) *UserService {
+	return &UserService{admin: admin}
+}
+
+func RegisterUserService(grpcServer *grpc.Server, service *UserService) {
+	pb.RegisterUserServiceServer(grpcServer, service)
+}
+
+func (s *UserService) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	filter := repository.UserFilter{
+		Search:      req.Query,
+		Role:        req.Role,
+		Disabled:    req.Disabled,
+		Status:      repository.AccountStatus(req.Status),
+		EmailDomain: req.EmailDomain,
+		Sort:        strings.TrimPrefix(req.Sort, "-"),
+		Desc:        strings.HasPrefix(req.Sort, "-"),
+		Limit:       int(req.Limit),
+		Cursor:      req.Cursor,
+		CountTotal:  req.WithTotal,
+	}
+	if req.CreatedFrom != nil {
+		t := req.CreatedFrom.AsTime()
+		filter.CreatedFrom = &t
+	}
+	if req.CreatedTo != nil {
+		t := req.CreatedTo.AsTime()
+		filter.CreatedTo = &t
+	}
+	page, err := s.admin.ListUsers(ctx, filter)
+	if err != nil {
+		return nil, userStatus(err)
+	}
+	resp := &pb.ListUsersResponse{Users: make([]*pb.User, len(page.Users)), NextCursor: page.Next}
+	for i, u := range page.Users {
+		resp.Users[i] = userToProto(u)
+	}
+	if page.Total != nil {
+		total := int64(*page.Total)
+		resp.Total = &total
+	}
+	return resp, nil
+}
+
+func (s *UserService) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	matches, err := s.admin.SearchUsers(ctx, req.Query, int(req.Limit))
+	if err != nil {
+		return nil, userStatus(err)
+	}
+	resp := &pb.SearchUsersResponse{Matches: make([]*pb.UserMatch, len(matches))}
+	for i, m := range matches {
+		match := &pb.UserMatch{User: userToProto(m.User), Rank: m.Rank}
+		for _, h := range m.Highlights {
+			match.Highlights = append(match.Highlights, &pb.Highlight{Field: h.Field, Start: int32(h.Start), End: int32(h.End)})
+		}
+		resp.Matches[i] = match
+	}
+	return resp, nil
+}
+
+func (s *UserService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.User, error) {
+	user, err := s.admin.GetUser(ctx, int(req.UserId))
+	if err != nil {
+		return nil, userStatus(err)
+	}
+	return userToProto(user), nil
+}
+
+func (s *UserService) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.User, error) {
+	patch := repository.UserPatch{Version: int(req.Version)}
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if msg := helper.ValidateUsername(username); msg != "" {
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+		patch.Username = &username
+	}
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if msg := helper.ValidateEmail(email); msg != "" {
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+		patch.Email = &email
+	}
+	if req.Password != nil {
+		if msg := helper.ValidatePassword(*req.Password); msg != "" {
+			return nil, status.Error(codes.InvalidArgument, msg)
+		}
+		hash, err := utils.GenerateHash(*req.Password)
+		if err != nil {
+			return nil, userStatus(err)
+		}
+		patch.Password = &hash
+	}
+
+	user, err := s.admin.UpdateUser(ctx, int(req.UserId), patch)
+	if err != nil {
+		return nil, userStatus(err)
+	}
+	return userToProto(user), nil
+}
+
+func userStatus(err error) error {
+	switch {
+	case errors.Is(err, access.ErrInvalidArgument):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, repository.ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, repository.ErrUsernameTaken), errors.Is(err, repository.ErrEmailTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, repository.ErrVersionConflict):
+		// read the user again and retry
+		return status.Error(codes.Aborted, err.Error())
+	}
+	log.Printf("Error managing users: %v", err)
+	return status.Error(codes.Internal, "something went wrong, please try later")
+}
+
+func userToProto(u repository.User) *pb.User {
+	user := &pb.User{
+		Id:       int64(u.ID),
+		Username: u.Username,
+		Email:    u.Email,
+		Roles:    u.Roles,
+		Disabled: u.DisabledAt != nil,
+		Version:  int64(u.Version),
+		Status:   string(u.Status),
+	}
+	if u.CreatedAt != nil {
+		user.CreatedAt = timestamppb.New(*u.CreatedAt)
+	}
+	return user
+}
@@ -6,14 +6,13 @@ import (
 	"fmt"
 )
 
-func GetUserByToken(token string) (u *repository.User, err error) {
-	var rep repository.Repository
+func GetUserByToken(users repository.UserStore, token string) (u *repository.User, err error) {
 	userID, err := ParseToken(token)
 	if err != nil {
 		return nil, fmt.Errorf("invalid token: %w", err)
 	}
 
-	user, err := rep.FindUserByID(context.Background(), userID)
+	user, err := users.GetUser(context.Background(), userID)
 	if err != nil {
 		return nil, fmt.Errorf("user not found: %v", err)
 	}
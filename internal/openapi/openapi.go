@@ -0,0 +1,180 @@
+// Package openapi holds the OpenAPI document of the HTTP API (openapi.yaml)
+// and validates requests and responses of the JSON API against it.
+// The document is the source of truth: a route missing from it fails the tests.
+package openapi
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+//go:embed openapi.yaml
+var spec []byte
+
+// Load parses and validates the embedded document
+func Load() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("openapi: invalid document: %w", err)
+	}
+	return doc, nil
+}
+
+// Validator checks the requests and responses of the paths under Prefix.
+// The pages outside of it are only documented.
+type Validator struct {
+	Prefix string
+
+	doc    *openapi3.T
+	json   []byte
+	router routers.Router
+}
+
+func NewValidator(doc *openapi3.T, prefix string) (*Validator, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	return &Validator{Prefix: prefix, doc: doc, json: data, router: router}, nil
+}
+
+var (
+	defaultOnce      sync.Once
+	defaultValidator *Validator
+)
+
+// Default validates the JSON API (/api/) against the embedded document.
+// The document ships with the binary and is checked by the tests, so a broken one panics.
+func Default() *Validator {
+	defaultOnce.Do(func() {
+		doc, err := Load()
+		if err == nil {
+			defaultValidator, err = NewValidator(doc, "/api/")
+		}
+		if err != nil {
+			panic(err)
+		}
+	})
+	return defaultValidator
+}
+
+// Doc is the parsed document
+func (v *Validator) Doc() *openapi3.T {
+	return v.doc
+}
+
+// ServeHTTP serves the document as JSON (/openapi.json)
+func (v *Validator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(v.json)
+}
+
+func options() *openapi3filter.Options {
+	opts := &openapi3filter.Options{
+		// authentication is done by the handlers, the document only describes it
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+	// short messages for the clients, without the schema dump
+	opts.WithCustomSchemaErrorFunc(func(err *openapi3.SchemaError) string {
+		if path := err.JSONPointer(); len(path) > 0 {
+			return fmt.Sprintf("%s: %s", strings.Join(path, "."), err.Reason)
+		}
+		return err.Reason
+	})
+	return opts
+}
+
+// Middleware rejects requests that don't match the document through onError (status 400)
+// and logs responses that don't match it, the client still gets them
+func (v *Validator) Middleware(onError func(w http.ResponseWriter, status int, message string)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, v.Prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			route, pathParams, err := v.router.FindRoute(r)
+			if err != nil {
+				// unknown routes get the usual 404/405 of the router
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options(),
+			}
+			if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+				onError(w, http.StatusBadRequest, requestMessage(err))
+				return
+			}
+
+			rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			rec.flush()
+
+			err = openapi3filter.ValidateResponse(r.Context(), (&openapi3filter.ResponseValidationInput{
+				RequestValidationInput: input,
+				Status:                 rec.status,
+				Header:                 rec.Header(),
+				Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+			}).SetBodyBytes(rec.body.Bytes()))
+			if err != nil {
+				log.Printf("openapi: response of %s %s does not match the document: %v", r.Method, route.Path, err)
+			}
+		})
+	}
+}
+
+func requestMessage(err error) string {
+	if reqErr, ok := err.(*openapi3filter.RequestError); ok {
+		return reqErr.Error()
+	}
+	return err.Error()
+}
+
+// recorder keeps the response until the handler is done, so it can be validated
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(b)
+}
+
+func (r *recorder) flush() {
+	r.ResponseWriter.WriteHeader(r.status)
+	r.ResponseWriter.Write(r.body.Bytes())
+}
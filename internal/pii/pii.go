@@ -0,0 +1,210 @@
+// Package pii encrypts personal data before it goes to the database (envelope encryption).
+// Every record gets its own data key, the data key is stored wrapped by a key-encryption key (KEK)
+// from the key file. Equality lookups use a blind index, an HMAC of the normalized value,
+// so the database never sees the plaintext.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize of the KEKs, the data keys and the index key (AES-256)
+const KeySize = 32
+
+// prefix of the encrypted values, values without it are plaintext not encrypted yet
+const prefix = "pii1:"
+
+var ErrUnknownKey = errors.New("pii: unknown key-encryption key")
+
+// KeyFile is the JSON file with the keys, every key is KeySize bytes in base64.
+// Rotating means adding a key and making it Current, the old ones stay
+// until the rotation command re-encrypted every row.
+type KeyFile struct {
+	// id of the KEK the new data keys are wrapped with
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+	// the blind index key is never rotated, a new one would need every index rebuilt
+	IndexKey string `json:"index_key"`
+}
+
+// Cipher holds the keys of a KeyFile
+type Cipher struct {
+	current  string
+	keks     map[string]cipher.AEAD
+	indexKey []byte
+}
+
+// LoadKeyFile reads the keys from path (PII_KEY_FILE)
+func LoadKeyFile(path string) (*Cipher, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("pii: %w", err)
+	}
+	var kf KeyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("pii: invalid key file %s: %w", path, err)
+	}
+	return NewCipher(kf)
+}
+
+func NewCipher(kf KeyFile) (*Cipher, error) {
+	c := &Cipher{current: kf.Current, keks: make(map[string]cipher.AEAD)}
+	for id, encoded := range kf.Keys {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("pii: key %s: %w", id, err)
+		}
+		if c.keks[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := c.keks[kf.Current]; !ok {
+		return nil, fmt.Errorf("%w: current key %q is not in keys", ErrUnknownKey, kf.Current)
+	}
+	var err error
+	if c.indexKey, err = decodeKey(kf.IndexKey); err != nil {
+		return nil, fmt.Errorf("pii: index key: %w", err)
+	}
+	return c, nil
+}
+
+// GenerateKey is a new random key in base64 for the key file
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CurrentKeyID is the KEK of the new data keys, rows with another one need the rotation
+func (c *Cipher) CurrentKeyID() string {
+	return c.current
+}
+
+// Index is the blind index of the value, the same for every spelling of an email address
+func (c *Cipher) Index(value string) []byte {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return mac.Sum(nil)
+}
+
+// DataKey encrypts the values of one record
+type DataKey struct {
+	// the KEK that wrapped it
+	KeyID string
+	// what goes to the database
+	Wrapped []byte
+
+	aead cipher.AEAD
+}
+
+// NewDataKey is a random data key wrapped with the current KEK
+func (c *Cipher) NewDataKey() (*DataKey, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	kek := c.keks[c.current]
+	wrapped, err := seal(kek, key, []byte(c.current))
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{KeyID: c.current, Wrapped: wrapped, aead: aead}, nil
+}
+
+// DataKey unwraps the data key of a record
+func (c *Cipher) DataKey(keyID string, wrapped []byte) (*DataKey, error) {
+	kek, ok := c.keks[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	key, err := open(kek, wrapped, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("pii: can't unwrap the data key: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{KeyID: keyID, Wrapped: wrapped, aead: aead}, nil
+}
+
+// Encrypt returns the value to store, column binds it to the column
+// so a value copied to another column doesn't decrypt
+func (k *DataKey) Encrypt(column, value string) (string, error) {
+	sealed, err := seal(k.aead, []byte(value), []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *DataKey) Decrypt(column, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", fmt.Errorf("pii: invalid %s: %w", column, err)
+	}
+	plain, err := open(k.aead, sealed, []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("pii: can't decrypt %s: %w", column, err)
+	}
+	return string(plain), nil
+}
+
+// IsEncrypted tells the values written by Encrypt from the plaintext ones
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// nonce followed by the ciphertext
+func seal(aead cipher.AEAD, plain, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
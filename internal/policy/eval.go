@@ -0,0 +1,271 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subject is the user asking for access.
+// Attributes come from the user_attributes table (e.g. tenant_id).
+type Subject struct {
+	ID         int               `json:"id"`
+	Username   string            `json:"username"`
+	Roles      []string          `json:"roles"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Request is everything the conditions can look at:
+//
+//	subject.id, subject.username, subject.roles, subject.<attribute>
+//	resource.type, resource.<attribute>, action
+//	env.time (RFC3339), env.date (2006-01-02), env.hour (0-23), env.weekday (Mon..Sun)
+type Request struct {
+	Subject  Subject `json:"subject"`
+	Resource string  `json:"resource"`
+	Action   string  `json:"action"`
+	// resource attributes, e.g. owner_id or tenant_id
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// overrides of the env.* attributes, used by policy tests
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// Trace explains what one policy did with the request
+type Trace struct {
+	PolicyID string `json:"policy_id"`
+	Effect   Effect `json:"effect"`
+	Applied  bool   `json:"applied"`
+	Detail   string `json:"detail"`
+}
+
+// Result of the evaluation, Effect is empty when no policy applied
+type Result struct {
+	Effect   Effect
+	PolicyID string
+	Trace    []Trace
+}
+
+type Engine struct {
+	policies []Policy
+	mode     Mode
+	now      func() time.Time
+}
+
+func NewEngine(policies []Policy, mode Mode) *Engine {
+	if mode == "" {
+		mode = Enforce
+	}
+	return &Engine{policies: policies, mode: mode, now: time.Now}
+}
+
+func (e *Engine) Mode() Mode {
+	if e == nil {
+		return Off
+	}
+	return e.mode
+}
+
+func (e *Engine) Policies() []Policy {
+	return e.policies
+}
+
+// Evaluate runs every policy against the request, a deny wins over an allow
+func (e *Engine) Evaluate(req Request) Result {
+	var res Result
+	attrs := e.attributes(req)
+
+	for _, p := range e.policies {
+		applied, detail := p.applies(req, attrs)
+		res.Trace = append(res.Trace, Trace{PolicyID: p.ID, Effect: p.Effect, Applied: applied, Detail: detail})
+		if !applied || res.Effect == Deny {
+			continue
+		}
+		if p.Effect == Deny || res.Effect == "" {
+			res.Effect = p.Effect
+			res.PolicyID = p.ID
+		}
+	}
+	return res
+}
+
+func (p Policy) applies(req Request, attrs map[string]interface{}) (bool, string) {
+	if !matches(p.Resources, req.Resource) {
+		return false, "resource does not match"
+	}
+	if !matches(p.Actions, req.Action) {
+		return false, "action does not match"
+	}
+	if len(p.Roles) > 0 && !hasAny(req.Subject.Roles, p.Roles) {
+		return false, "subject has none of the roles " + strings.Join(p.Roles, ", ")
+	}
+	for _, c := range p.Conditions {
+		if ok, detail := c.holds(attrs); !ok {
+			return false, detail
+		}
+	}
+	return true, "all conditions hold"
+}
+
+func (e *Engine) attributes(req Request) map[string]interface{} {
+	now := e.now()
+	attrs := map[string]interface{}{
+		"subject.id":       strconv.Itoa(req.Subject.ID),
+		"subject.username": req.Subject.Username,
+		"subject.roles":    req.Subject.Roles,
+		"resource.type":    req.Resource,
+		"action":           req.Action,
+		"env.time":         now.Format(time.RFC3339),
+		"env.date":         now.Format("2006-01-02"),
+		"env.hour":         strconv.Itoa(now.Hour()),
+		"env.weekday":      now.Format("Mon"),
+	}
+	for k, v := range req.Subject.Attributes {
+		attrs["subject."+k] = v
+	}
+	for k, v := range req.Attributes {
+		attrs["resource."+k] = v
+	}
+	for k, v := range req.Env {
+		attrs["env."+k] = v
+	}
+	return attrs
+}
+
+func (c Condition) holds(attrs map[string]interface{}) (bool, string) {
+	left, ok := attrs[c.Attr]
+	if c.Op == "exists" {
+		want, _ := c.Value.(bool)
+		if c.Value == nil {
+			want = true
+		}
+		return ok == want, fmt.Sprintf("%s exists = %v", c.Attr, ok)
+	}
+	if !ok {
+		return false, fmt.Sprintf("%s is not set", c.Attr)
+	}
+
+	right := c.Value
+	if c.ValueAttr != "" {
+		if right, ok = attrs[c.ValueAttr]; !ok {
+			return false, fmt.Sprintf("%s is not set", c.ValueAttr)
+		}
+	}
+
+	if operators[c.Op](left, right) {
+		return true, ""
+	}
+	return false, fmt.Sprintf("%s (%v) %s %v does not hold", c.Attr, left, c.Op, right)
+}
+
+var operators = map[string]func(left, right interface{}) bool{
+	"eq":       equal,
+	"ne":       func(l, r interface{}) bool { return !equal(l, r) },
+	"in":       func(l, r interface{}) bool { return contains(r, l) },
+	"not_in":   func(l, r interface{}) bool { return !contains(r, l) },
+	"contains": func(l, r interface{}) bool { return contains(l, r) },
+	"gt":       compare(func(a, b float64) bool { return a > b }),
+	"gte":      compare(func(a, b float64) bool { return a >= b }),
+	"lt":       compare(func(a, b float64) bool { return a < b }),
+	"lte":      compare(func(a, b float64) bool { return a <= b }),
+	// [from, to), e.g. hours [9, 18] means 9:00 - 17:59
+	"between": func(l, r interface{}) bool {
+		bounds := list(r)
+		if len(bounds) != 2 {
+			return false
+		}
+		x, ok1 := number(l)
+		from, ok2 := number(bounds[0])
+		to, ok3 := number(bounds[1])
+		return ok1 && ok2 && ok3 && x >= from && x < to
+	},
+	"exists": nil, // handled in holds
+}
+
+func equal(l, r interface{}) bool {
+	if a, ok := number(l); ok {
+		if b, ok := number(r); ok {
+			return a == b
+		}
+	}
+	return text(l) == text(r)
+}
+
+// contains reports whether the list holds the value
+func contains(listValue, value interface{}) bool {
+	for _, item := range list(listValue) {
+		if equal(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func compare(cmp func(a, b float64) bool) func(l, r interface{}) bool {
+	return func(l, r interface{}) bool {
+		a, ok1 := number(l)
+		b, ok2 := number(r)
+		return ok1 && ok2 && cmp(a, b)
+	}
+}
+
+func list(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		return v
+	case []string:
+		result := make([]interface{}, len(v))
+		for i, s := range v {
+			result[i] = s
+		}
+		return result
+	case string:
+		// "a,b,c" from string attributes
+		var result []interface{}
+		for _, s := range strings.Split(v, ",") {
+			result = append(result, strings.TrimSpace(s))
+		}
+		return result
+	}
+	return nil
+}
+
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func text(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func matches(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == value {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAny(have, want []string) bool {
+	for _, h := range have {
+		for _, w := range want {
+			if h == w {
+				return true
+			}
+		}
+	}
+	return false
+}
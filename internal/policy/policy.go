@@ -0,0 +1,132 @@
+// Package policy evaluates attribute based rules on top of role permissions.
+//
+// Policies are JSON files, every file holds {"policies": [...]}.
+// A policy applies when the resource, action and (optional) roles match
+// and all of its conditions hold. A matching "deny" always wins,
+// otherwise a matching "allow" grants access that the roles alone would not.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// What the engine does with the decisions
+type Mode string
+
+const (
+	// policies decide together with the role permissions
+	Enforce Mode = "enforce"
+	// policies are evaluated and logged, only role permissions decide
+	DryRun Mode = "dry_run"
+	// policies are not evaluated
+	Off Mode = "off"
+)
+
+type Policy struct {
+	ID          string      `json:"id"`
+	Description string      `json:"description"`
+	Effect      Effect      `json:"effect"`
+	Resources   []string    `json:"resources"`
+	Actions     []string    `json:"actions"`
+	Roles       []string    `json:"roles,omitempty"`
+	Conditions  []Condition `json:"conditions,omitempty"`
+}
+
+// Condition compares an attribute with a constant Value
+// or with another attribute (ValueAttr), e.g.
+//
+//	{"attr": "resource.owner_id", "op": "eq", "value_attr": "subject.id"}
+//	{"attr": "env.hour", "op": "between", "value": [9, 18]}
+type Condition struct {
+	Attr      string      `json:"attr"`
+	Op        string      `json:"op"`
+	Value     interface{} `json:"value,omitempty"`
+	ValueAttr string      `json:"value_attr,omitempty"`
+}
+
+type file struct {
+	Policies []Policy `json:"policies"`
+}
+
+// Load reads every *.json file of dir except the *_test.json ones
+func Load(dir string) ([]Policy, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var policies []Policy
+	seen := make(map[string]string)
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.json") {
+			continue
+		}
+		loaded, err := LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range loaded {
+			if other, ok := seen[p.ID]; ok {
+				return nil, fmt.Errorf("%s: policy %q is already defined in %s", path, p.ID, other)
+			}
+			seen[p.ID] = path
+		}
+		policies = append(policies, loaded...)
+	}
+	return policies, nil
+}
+
+func LoadFile(path string) ([]Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, p := range f.Policies {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return f.Policies, nil
+}
+
+// Validate checks the policy before it is used
+func (p Policy) Validate() error {
+	if p.ID == "" {
+		return fmt.Errorf("policy without id")
+	}
+	if p.Effect != Allow && p.Effect != Deny {
+		return fmt.Errorf("policy %q: effect must be %q or %q", p.ID, Allow, Deny)
+	}
+	if len(p.Resources) == 0 || len(p.Actions) == 0 {
+		return fmt.Errorf("policy %q: resources and actions are required", p.ID)
+	}
+	for _, c := range p.Conditions {
+		if c.Attr == "" {
+			return fmt.Errorf("policy %q: condition without attr", p.ID)
+		}
+		if _, ok := operators[c.Op]; !ok {
+			return fmt.Errorf("policy %q: unknown operator %q", p.ID, c.Op)
+		}
+		if c.Value != nil && c.ValueAttr != "" {
+			return fmt.Errorf("policy %q: condition on %s has both value and value_attr", p.ID, c.Attr)
+		}
+	}
+	return nil
+}
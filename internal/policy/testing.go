@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// NotApplicable is the expected outcome when no policy applies
+const NotApplicable = "not_applicable"
+
+// TestCase is one entry of a *_test.json file next to the policies:
+//
+//	{"tests": [{"name": "...", "request": {...}, "expect": "deny"}]}
+type TestCase struct {
+	Name    string  `json:"name"`
+	Request Request `json:"request"`
+	// allow, deny or not_applicable
+	Expect string `json:"expect"`
+	// id of the policy that should decide, optional
+	ExpectPolicy string `json:"expect_policy,omitempty"`
+}
+
+type TestFailure struct {
+	Case   TestCase
+	Result Result
+	Error  string
+}
+
+func (f TestFailure) String() string {
+	return fmt.Sprintf("%s: %s", f.Case.Name, f.Error)
+}
+
+// LoadTests reads every *_test.json file of dir
+func LoadTests(dir string) ([]TestCase, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*_test.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var cases []TestCase
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var f struct {
+			Tests []TestCase `json:"tests"`
+		}
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		cases = append(cases, f.Tests...)
+	}
+	return cases, nil
+}
+
+// RunTests evaluates every case and returns the ones that failed
+func (e *Engine) RunTests(cases []TestCase) []TestFailure {
+	var failures []TestFailure
+	for _, c := range cases {
+		res := e.Evaluate(c.Request)
+		got := string(res.Effect)
+		if got == "" {
+			got = NotApplicable
+		}
+		switch {
+		case got != c.Expect:
+			failures = append(failures, TestFailure{Case: c, Result: res,
+				Error: fmt.Sprintf("expected %s, got %s", c.Expect, got)})
+		case c.ExpectPolicy != "" && res.PolicyID != c.ExpectPolicy:
+			failures = append(failures, TestFailure{Case: c, Result: res,
+				Error: fmt.Sprintf("expected policy %s to decide, got %s", c.ExpectPolicy, res.PolicyID)})
+		}
+	}
+	return failures
+}
@@ -0,0 +1,124 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- GoAdmin's own schema (data/admin.pgsql of go-admin), versioned apart from the app
+-- in goose_admin_db_version. The tables createmaindb of the app set made first are
+-- brought to this shape by the next migration.
+
+create table if not exists goadmin_users (
+    id serial primary key,
+    username varchar(100) not null,
+    password varchar(100) not null,
+    name varchar(100) not null,
+    avatar varchar(255),
+    remember_token varchar(100),
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+
+create table if not exists goadmin_roles (
+    id serial primary key,
+    name varchar not null,
+    slug varchar not null,
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+
+create table if not exists goadmin_permissions (
+    id serial primary key,
+    name varchar(50) not null,
+    slug varchar(50) not null,
+    http_method varchar(255),
+    http_path text not null,
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+
+create table if not exists goadmin_menu (
+    id serial primary key,
+    parent_id int not null default 0,
+    type int default 0,
+    "order" int not null default 0,
+    title varchar(50) not null,
+    header varchar(100),
+    plugin_name varchar(150) not null default '',
+    icon varchar(50) not null,
+    uri varchar(3000) not null,
+    uuid varchar(150) not null default '',
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+
+create table if not exists goadmin_role_users (
+    role_id int not null,
+    user_id int not null,
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+
+create table if not exists goadmin_role_permissions (
+    role_id int not null,
+    permission_id int not null,
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+
+create table if not exists goadmin_user_permissions (
+    user_id int not null,
+    permission_id int not null,
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+
+create table if not exists goadmin_role_menu (
+    role_id int not null,
+    menu_id int not null,
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+
+create table if not exists goadmin_operation_log (
+    id serial primary key,
+    user_id int not null,
+    path varchar(255) not null,
+    method varchar(10) not null,
+    ip varchar(15) not null,
+    input text not null,
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+
+create table if not exists goadmin_site (
+    id serial primary key,
+    key varchar(100) not null,
+    value text not null,
+    type int default 0,
+    description varchar(3000),
+    state int default 0,
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+
+create table if not exists goadmin_session (
+    id serial primary key,
+    sid varchar(50) not null,
+    "values" varchar(3000) not null,
+    created_at timestamp default now(),
+    updated_at timestamp default now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists goadmin_session;
+drop table if exists goadmin_site;
+drop table if exists goadmin_operation_log;
+drop table if exists goadmin_role_menu;
+drop table if exists goadmin_user_permissions;
+drop table if exists goadmin_role_permissions;
+drop table if exists goadmin_role_users;
+drop table if exists goadmin_menu;
+drop table if exists goadmin_permissions;
+drop table if exists goadmin_roles;
+drop table if exists goadmin_users;
+-- +goose StatementEnd
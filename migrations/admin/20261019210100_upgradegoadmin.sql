@@ -0,0 +1,67 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- The goadmin_* tables of createmaindb in the app set are not GoAdmin's schema,
+-- GoAdmin failed on the missing columns. That migration is released and stays as it is,
+-- its tables are brought to GoAdmin's shape here. Nothing to do if creategoadmin made them.
+
+alter table goadmin_users add column if not exists name varchar(100) not null default '';
+alter table goadmin_users add column if not exists avatar varchar(255);
+alter table goadmin_users add column if not exists remember_token varchar(100);
+
+-- the role was a column of the user, GoAdmin reads goadmin_role_users
+do $$
+begin
+    if exists (select 1 from information_schema.columns
+               where table_name = 'goadmin_users' and column_name = 'role_id') then
+        insert into goadmin_role_users (role_id, user_id)
+            select role_id, id from goadmin_users where role_id is not null;
+        alter table goadmin_users drop column role_id;
+    end if;
+end $$;
+
+alter table goadmin_permissions drop column if exists permission;
+-- "GET,PUT,POST,DELETE" didn't fit
+alter table goadmin_permissions alter column http_method type varchar(255);
+update goadmin_permissions set name = slug where name is null;
+update goadmin_permissions set http_path = '' where http_path is null;
+alter table goadmin_permissions alter column name set not null;
+alter table goadmin_permissions alter column http_path type text;
+alter table goadmin_permissions alter column http_path set not null;
+
+update goadmin_menu set parent_id = 0 where parent_id is null;
+alter table goadmin_menu alter column parent_id set default 0;
+alter table goadmin_menu alter column parent_id set not null;
+alter table goadmin_menu add column if not exists type int default 0;
+alter table goadmin_menu add column if not exists "order" int not null default 0;
+alter table goadmin_menu add column if not exists header varchar(100);
+alter table goadmin_menu add column if not exists plugin_name varchar(150) not null default '';
+alter table goadmin_menu add column if not exists uuid varchar(150) not null default '';
+update goadmin_menu set icon = '' where icon is null;
+update goadmin_menu set uri = '' where uri is null;
+alter table goadmin_menu alter column icon set not null;
+alter table goadmin_menu alter column uri set not null;
+
+alter table goadmin_site alter column value type text;
+alter table goadmin_site add column if not exists type int default 0;
+alter table goadmin_site add column if not exists description varchar(3000);
+alter table goadmin_site add column if not exists updated_at timestamp default now();
+do $$
+begin
+    if exists (select 1 from information_schema.columns
+               where table_name = 'goadmin_site' and column_name = 'state' and data_type = 'boolean') then
+        alter table goadmin_site alter column state drop default;
+        alter table goadmin_site alter column state type int using state::int;
+        alter table goadmin_site alter column state set default 0;
+    end if;
+end $$;
+
+alter table goadmin_role_users add column if not exists created_at timestamp default now();
+alter table goadmin_role_users add column if not exists updated_at timestamp default now();
+alter table goadmin_role_permissions add column if not exists updated_at timestamp default now();
+alter table goadmin_user_permissions add column if not exists updated_at timestamp default now();
+alter table goadmin_role_menu add column if not exists updated_at timestamp default now();
+-- +goose StatementEnd
+
+-- +goose Down
+-- the old columns were never used, there is nothing to go back to
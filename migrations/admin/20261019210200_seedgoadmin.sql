@@ -0,0 +1,77 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- The roles, permissions and menu GoAdmin ships with (data/admin.pgsql), without them
+-- AddAdminUser had no role to give and the admin panel had no menu.
+-- There are no unique keys on these tables, "where not exists" keeps the seed repeatable.
+
+insert into goadmin_roles (name, slug)
+    select v.name, v.slug from (values
+        ('Administrator', 'administrator'),
+        ('Operator', 'operator')
+    ) as v(name, slug)
+    where not exists (select 1 from goadmin_roles r where r.slug = v.slug);
+
+insert into goadmin_permissions (name, slug, http_method, http_path)
+    select v.name, v.slug, v.http_method, v.http_path from (values
+        ('All permission', '*', '', '*'),
+        ('Dashboard', 'dashboard', 'GET,PUT,POST,DELETE', '/')
+    ) as v(name, slug, http_method, http_path)
+    where not exists (select 1 from goadmin_permissions p where p.slug = v.slug);
+
+insert into goadmin_menu (parent_id, type, "order", title, icon, uri)
+    select 0, 1, v.ord, v.title, v.icon, v.uri from (values
+        (1, 'Dashboard', 'fa-bar-chart', '/'),
+        (2, 'Admin', 'fa-tasks', '')
+    ) as v(ord, title, icon, uri)
+    where not exists (select 1 from goadmin_menu m where m.parent_id = 0 and m.title = v.title);
+
+insert into goadmin_menu (parent_id, type, "order", title, icon, uri)
+    select admin.id, 1, v.ord, v.title, v.icon, v.uri
+    from (values
+        (2, 'Users', 'fa-users', '/info/manager'),
+        (3, 'Roles', 'fa-user', '/info/roles'),
+        (4, 'Permission', 'fa-ban', '/info/permission'),
+        (5, 'Menu', 'fa-bars', '/menu'),
+        (6, 'Operation log', 'fa-history', '/info/op')
+    ) as v(ord, title, icon, uri)
+    join goadmin_menu admin on admin.parent_id = 0 and admin.title = 'Admin'
+    where not exists (select 1 from goadmin_menu m where m.parent_id = admin.id and m.uri = v.uri);
+
+insert into goadmin_role_permissions (role_id, permission_id)
+    select r.id, p.id from (values
+        ('administrator', '*'),
+        ('administrator', 'dashboard'),
+        ('operator', 'dashboard')
+    ) as v(role, permission)
+    join goadmin_roles r on r.slug = v.role
+    join goadmin_permissions p on p.slug = v.permission
+    where not exists (select 1 from goadmin_role_permissions rp
+                      where rp.role_id = r.id and rp.permission_id = p.id);
+
+insert into goadmin_role_menu (role_id, menu_id)
+    select r.id, m.id from (values
+        ('administrator', 'Admin'),
+        ('administrator', 'Dashboard'),
+        ('operator', 'Dashboard')
+    ) as v(role, menu)
+    join goadmin_roles r on r.slug = v.role
+    join goadmin_menu m on m.parent_id = 0 and m.title = v.menu
+    where not exists (select 1 from goadmin_role_menu rm
+                      where rm.role_id = r.id and rm.menu_id = m.id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+delete from goadmin_role_menu where role_id in
+    (select id from goadmin_roles where slug in ('administrator', 'operator'));
+delete from goadmin_role_permissions where role_id in
+    (select id from goadmin_roles where slug in ('administrator', 'operator'));
+delete from goadmin_role_users where role_id in
+    (select id from goadmin_roles where slug in ('administrator', 'operator'));
+delete from goadmin_menu where parent_id in
+    (select id from goadmin_menu where parent_id = 0 and title = 'Admin');
+delete from goadmin_menu where parent_id = 0 and title in ('Admin', 'Dashboard');
+delete from goadmin_permissions where slug in ('*', 'dashboard');
+delete from goadmin_roles where slug in ('administrator', 'operator');
+-- +goose StatementEnd
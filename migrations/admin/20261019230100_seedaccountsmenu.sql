@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- the accounts of the application with their search, next to the GoAdmin users;
+-- the roles see it with the Admin menu
+insert into goadmin_menu (parent_id, type, "order", title, icon, uri)
+    select admin.id, 1, 1, 'Accounts', 'fa-address-book', '/info/user'
+    from goadmin_menu admin
+    where admin.parent_id = 0 and admin.title = 'Admin'
+        and not exists (select 1 from goadmin_menu m where m.parent_id = admin.id and m.uri = '/info/user');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+delete from goadmin_menu where uri = '/info/user';
+-- +goose StatementEnd
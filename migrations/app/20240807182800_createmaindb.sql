@@ -1,6 +1,9 @@
 -- +goose Up
 -- +goose StatementBegin
 
+-- "if not exists" everywhere: the databases set up by the old docker init scripts
+-- already have these tables but no goose version yet
+
 -- Users table
 create table if not exists users (
     id bigserial primary key,
@@ -12,7 +15,7 @@ create table if not exists users (
 );
 
 -- Session table for GoAdmin
-create table goadmin_session (
+create table if not exists goadmin_session (
     id bigserial primary key,
     sid varchar(255) not null unique,
     values text not null,
@@ -21,7 +24,7 @@ create table goadmin_session (
 );
 
 -- Site Settings Table for GoAdmin
-create table goadmin_site (
+create table if not exists goadmin_site (
     id bigserial primary key,
     key varchar(255) not null,
     value varchar(255) not null,
@@ -30,7 +33,7 @@ create table goadmin_site (
 );
 
 -- Table for GoAdmin user roles
-create table goadmin_roles (
+create table if not exists goadmin_roles (
     id serial primary key,
     name varchar(50) not null,
     slug varchar(50) not null,
@@ -51,7 +54,7 @@ create table if not exists goadmin_users (
 );
 
 -- Table linking users and roles
-create table goadmin_role_users (
+create table if not exists goadmin_role_users (
     id serial primary key,
     role_id int not null references goadmin_roles(id) on delete cascade,
     user_id int not null references goadmin_users(id) on delete cascade
@@ -77,7 +80,7 @@ create table if not exists goadmin_user_permissions (
 );
 
 -- Table linking roles and permissions
-create table goadmin_role_permissions (
+create table if not exists goadmin_role_permissions (
     id serial primary key,
     role_id int not null references goadmin_roles(id) on delete cascade,
     permission_id int not null references goadmin_permissions(id) on delete cascade,
@@ -100,3 +103,18 @@ create table if not exists goadmin_role_menu (
     created_at timestamp default CURRENT_TIMESTAMP
 )
 -- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists goadmin_role_menu;
+drop table if exists goadmin_menu;
+drop table if exists goadmin_role_permissions;
+drop table if exists goadmin_user_permissions;
+drop table if exists goadmin_permissions;
+drop table if exists goadmin_role_users;
+drop table if exists goadmin_users;
+drop table if exists goadmin_roles;
+drop table if exists goadmin_site;
+drop table if exists goadmin_session;
+drop table if exists users;
+-- +goose StatementEnd
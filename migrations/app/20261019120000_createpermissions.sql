@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- What can be done: action on resource, "*" matches anything
+create table if not exists permissions (
+    id serial primary key,
+    resource varchar(100) not null,
+    action varchar(50) not null,
+    description varchar(255),
+    created_at timestamp default CURRENT_TIMESTAMP,
+    unique (resource, action)
+);
+
+-- Which permissions a role (users.role) has
+create table if not exists role_permissions (
+    role varchar(50) not null,
+    permission_id int not null references permissions(id) on delete cascade,
+    created_at timestamp default CURRENT_TIMESTAMP,
+    primary key (role, permission_id)
+);
+
+insert into permissions (resource, action, description) values
+    ('*', '*', 'Full access'),
+    ('profile', 'read', 'Read own profile'),
+    ('profile', 'update', 'Update own profile'),
+    ('profile', 'delete', 'Delete own account'),
+    ('users', 'read', 'List users')
+on conflict (resource, action) do nothing;
+
+insert into role_permissions (role, permission_id)
+select 'admin', id from permissions where resource = '*' and action = '*'
+on conflict do nothing;
+
+insert into role_permissions (role, permission_id)
+select 'user', id from permissions where resource in ('profile', 'users') and action <> '*'
+on conflict do nothing;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists role_permissions;
+drop table if exists permissions;
+-- +goose StatementEnd
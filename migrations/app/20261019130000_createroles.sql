@@ -0,0 +1,83 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Roles, a role inherits every permission of its parent
+create table if not exists roles (
+    id serial primary key,
+    name varchar(50) unique not null,
+    description varchar(255),
+    parent_id int references roles(id) on delete set null,
+    created_at timestamp default CURRENT_TIMESTAMP,
+    check (parent_id <> id)
+);
+
+insert into roles (name, description) values
+    ('user', 'Regular user'),
+    ('admin', 'Administrator')
+on conflict (name) do nothing;
+
+-- admin can do everything a user can
+update roles set parent_id = (select id from roles where name = 'user') where name = 'admin';
+
+-- every role that is already in use becomes a real role
+insert into roles (name)
+select distinct role from users
+union
+select distinct role from role_permissions
+on conflict (name) do nothing;
+
+-- role_permissions now points to roles instead of holding the name
+alter table role_permissions add column role_id int references roles(id) on delete cascade;
+update role_permissions rp set role_id = r.id from roles r where r.name = rp.role;
+alter table role_permissions drop constraint role_permissions_pkey;
+alter table role_permissions drop column role;
+alter table role_permissions alter column role_id set not null;
+alter table role_permissions add primary key (role_id, permission_id);
+
+-- A user can have many roles
+create table if not exists user_roles (
+    user_id bigint not null references users(id) on delete cascade,
+    role_id int not null references roles(id) on delete cascade,
+    created_at timestamp default CURRENT_TIMESTAMP,
+    primary key (user_id, role_id)
+);
+
+insert into user_roles (user_id, role_id)
+select u.id, r.id from users u join roles r on r.name = u.role
+on conflict do nothing;
+
+alter table users drop column role;
+
+insert into permissions (resource, action, description) values
+    ('roles', 'manage', 'Manage roles and permissions')
+on conflict (resource, action) do nothing;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table users add column role varchar(50) not null default 'user';
+
+-- a user keeps one role, the one with the most permissions wins
+update users u set role = coalesce((
+    select r.name from user_roles ur
+    join roles r on r.id = ur.role_id
+    left join role_permissions rp on rp.role_id = r.id
+    where ur.user_id = u.id
+    group by r.name
+    order by count(rp.permission_id) desc, r.name
+    limit 1
+), 'user');
+
+drop table if exists user_roles;
+
+alter table role_permissions add column role varchar(50);
+update role_permissions rp set role = r.name from roles r where r.id = rp.role_id;
+alter table role_permissions drop constraint role_permissions_pkey;
+alter table role_permissions drop column role_id;
+alter table role_permissions alter column role set not null;
+alter table role_permissions add primary key (role, permission_id);
+
+delete from permissions where resource = 'roles' and action = 'manage';
+
+drop table if exists roles;
+-- +goose StatementEnd
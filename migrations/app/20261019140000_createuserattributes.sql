@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Free form attributes of a user for the access policies (subject.<key>),
+-- e.g. tenant_id
+create table if not exists user_attributes (
+    user_id bigint not null references users(id) on delete cascade,
+    key varchar(100) not null,
+    value varchar(255) not null,
+    primary key (user_id, key)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists user_attributes;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- API keys of other services calling the gRPC API,
+-- only the sha256 of the key is stored
+create table if not exists api_keys (
+    id serial primary key,
+    name varchar(100) unique not null,
+    key_hash char(64) unique not null,
+    roles text[] not null default '{}',
+    created_at timestamp default CURRENT_TIMESTAMP,
+    revoked_at timestamp
+);
+
+insert into roles (name, description) values ('service', 'Other services calling the API')
+on conflict (name) do nothing;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists api_keys;
+delete from roles where name = 'service';
+-- +goose StatementEnd
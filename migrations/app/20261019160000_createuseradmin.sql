@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Disabled accounts can't log in until an admin enables them again
+alter table users add column if not exists disabled_at timestamp;
+
+insert into permissions (resource, action, description) values
+    ('users', 'manage', 'Administer user accounts')
+on conflict (resource, action) do nothing;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+delete from permissions where resource = 'users' and action = 'manage';
+
+alter table users drop column if exists disabled_at;
+-- +goose StatementEnd
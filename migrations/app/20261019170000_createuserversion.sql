@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Every change of a user increments the version, an update naming an older one is rejected
+alter table users add column if not exists version integer not null default 1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table users drop column if exists version;
+-- +goose StatementEnd
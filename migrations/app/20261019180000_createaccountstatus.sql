@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- active, disabled, pending_deletion (until the grace period is over) or deleted (anonymized)
+alter table users add column if not exists status varchar(20) not null default 'active'
+    check (status in ('active', 'disabled', 'pending_deletion', 'deleted'));
+alter table users add column if not exists deletion_requested_at timestamp;
+update users set status = 'disabled' where disabled_at is not null;
+
+-- the purge job looks for the accounts whose grace period is over
+create index if not exists users_deletion_requested_at_idx on users (deletion_requested_at)
+    where status = 'pending_deletion';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index if exists users_deletion_requested_at_idx;
+alter table users drop column if exists deletion_requested_at;
+alter table users drop column if exists status;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Login history and audit trail of a user, the same events also go to Kafka.
+-- user_id is who the event is about, actor_id the admin who did it (null for the user's own logins).
+-- Erasing a user keeps the rows and empties details, the ids point to the anonymized user.
+create table if not exists user_events (
+    id bigserial primary key,
+    user_id bigint not null references users(id) on delete cascade,
+    actor_id bigint references users(id) on delete set null,
+    event varchar(100) not null,
+    details jsonb not null default '{}',
+    created_at timestamp not null default CURRENT_TIMESTAMP
+);
+
+create index if not exists user_events_user_id_idx on user_events (user_id, created_at);
+create index if not exists user_events_actor_id_idx on user_events (actor_id) where actor_id is not null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists user_events;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- With PII_KEY_FILE the email is stored encrypted with the data key of the row,
+-- data_key is that key wrapped by the key-encryption key key_id.
+-- email_index is the blind index (HMAC) for the lookups and the uniqueness.
+-- Rows without key_id are plaintext until `go run ./cmd/piikeys rotate` encrypts them.
+alter table users alter column email type text;
+alter table users add column if not exists email_index bytea;
+alter table users add column if not exists data_key bytea;
+alter table users add column if not exists key_id varchar(64);
+
+create unique index if not exists users_email_index_key on users (email_index) where email_index is not null;
+-- the rotation looks for the rows of the old keys
+create index if not exists users_key_id_idx on users (key_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index if exists users_key_id_idx;
+drop index if exists users_email_index_key;
+alter table users drop column if exists key_id;
+alter table users drop column if exists data_key;
+alter table users drop column if exists email_index;
+alter table users alter column email type varchar(255);
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- blind index of the email domain for the domain filter of the user listing,
+-- set with the email index; `go run ./cmd/piikeys rotate` fills it for the rows encrypted before
+alter table users add column if not exists email_domain_index bytea;
+create index if not exists users_email_domain_index_idx on users (email_domain_index);
+create index if not exists users_email_domain_idx on users (lower(split_part(email, '@', 2)));
+
+-- keyset pagination: the sort key and the id of the last user of the page
+create index if not exists users_username_id_idx on users (lower(username), id);
+create index if not exists users_email_id_idx on users (lower(email), id);
+create index if not exists users_created_at_id_idx on users (created_at, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index if exists users_created_at_id_idx;
+drop index if exists users_email_id_idx;
+drop index if exists users_username_id_idx;
+drop index if exists users_email_domain_idx;
+drop index if exists users_email_domain_index_idx;
+alter table users drop column if exists email_domain_index;
+-- +goose StatementEnd
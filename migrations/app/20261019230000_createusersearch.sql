@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- trigram similarity and the trigram indexes of SearchUsers, trusted since Postgres 13:
+-- the owner of the database can create it
+create extension if not exists pg_trgm;
+
+-- the words of the username and of the plaintext email for the prefix search,
+-- the ciphertext of an encrypted email is left out
+alter table users add column if not exists search_vector tsvector generated always as (
+    to_tsvector('simple', regexp_replace(coalesce(username, '') || ' ' ||
+        case when key_id is null then coalesce(email, '') else '' end, '[^[:alnum:]]+', ' ', 'g'))
+) stored;
+create index if not exists users_search_vector_idx on users using gin (search_vector);
+
+-- the like and <% of SearchUsers
+create index if not exists users_username_trgm_idx on users using gin (lower(username) gin_trgm_ops);
+-- the email the same way as the query: null when it is encrypted
+create index if not exists users_email_trgm_idx on users using gin ((case when key_id is null then lower(email) end) gin_trgm_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- pg_trgm stays, it may have been there before
+drop index if exists users_email_trgm_idx;
+drop index if exists users_username_trgm_idx;
+drop index if exists users_search_vector_idx;
+alter table users drop column if exists search_vector;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- who asked for the deletion: the user itself, or an admin whose deletion only RestoreUser undoes.
+-- The pending deletions from before are nobody's, logging in doesn't cancel them.
+alter table users add column if not exists deletion_requested_by integer
+    references users (id) on delete set null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table users drop column if exists deletion_requested_by;
+-- +goose StatementEnd
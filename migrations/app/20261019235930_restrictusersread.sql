@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- users:read lists every account, a regular user has profile:* for the own one only.
+-- admin keeps it through *:*, support through the support-tenant-users policy
+-- or by granting it to the role.
+delete from role_permissions
+where role_id = (select id from roles where name = 'user')
+    and permission_id = (select id from permissions where resource = 'users' and action = 'read');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+insert into role_permissions (role_id, permission_id)
+select r.id, p.id from roles r, permissions p
+where r.name = 'user' and p.resource = 'users' and p.action = 'read'
+on conflict do nothing;
+-- +goose StatementEnd
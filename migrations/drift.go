@@ -0,0 +1,127 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pressly/goose/v3"
+)
+
+// Columns the code and GoAdmin query. A table changed by hand or left behind by an
+// old version shows up here, before a request fails with "column does not exist".
+var expectedColumns = map[string][]string{
+	"users": {"id", "username", "email", "password", "created_at", "disabled_at", "version",
+		"status", "deletion_requested_at", "deletion_requested_by", "email_index", "data_key", "key_id", "email_domain_index", "search_vector"},
+	"roles":            {"id", "name"},
+	"user_roles":       {"user_id", "role_id"},
+	"permissions":      {"id", "resource", "action"},
+	"role_permissions": {"role_id", "permission_id"},
+	"user_attributes":  {"user_id"},
+	"api_keys":         {"id"},
+	"user_events":      {"id", "user_id", "actor_id", "event", "details", "created_at"},
+
+	"goadmin_users":            {"id", "username", "password", "name", "avatar", "remember_token"},
+	"goadmin_roles":            {"id", "name", "slug"},
+	"goadmin_permissions":      {"id", "name", "slug", "http_method", "http_path"},
+	"goadmin_menu":             {"id", "parent_id", "type", "order", "title", "header", "plugin_name", "icon", "uri", "uuid"},
+	"goadmin_role_users":       {"role_id", "user_id"},
+	"goadmin_role_permissions": {"role_id", "permission_id"},
+	"goadmin_user_permissions": {"user_id", "permission_id"},
+	"goadmin_role_menu":        {"role_id", "menu_id"},
+	"goadmin_operation_log":    {"id", "user_id", "path", "method", "ip", "input"},
+	"goadmin_site":             {"id", "key", "value", "type", "description", "state"},
+	"goadmin_session":          {"id", "sid", "values"},
+}
+
+// Drift is what Check found between the database and this build
+type Drift struct {
+	// migrations not applied yet, by set
+	Pending map[string][]string
+	// "table" or "table.column"
+	Missing []string
+}
+
+func (d *Drift) Error() string {
+	var b strings.Builder
+	b.WriteString("the database schema doesn't match this build")
+	for _, set := range Sets {
+		if pending := d.Pending[set.Name]; len(pending) > 0 {
+			fmt.Fprintf(&b, "\n  %s: %d pending migrations: %s", set.Name, len(pending), strings.Join(pending, ", "))
+		}
+	}
+	if len(d.Missing) > 0 {
+		fmt.Fprintf(&b, "\n  missing: %s", strings.Join(d.Missing, ", "))
+	}
+	if len(d.Pending) > 0 {
+		b.WriteString("\nrun `migrate up` or start with MIGRATE_ON_START=true")
+	}
+	return b.String()
+}
+
+// Check compares the database with the embedded migrations and the columns in use,
+// the error is a *Drift listing everything at once
+func Check(ctx context.Context, db *sql.DB) error {
+	drift := &Drift{Pending: make(map[string][]string)}
+	for _, set := range Sets {
+		provider, err := NewProvider(db, set)
+		if err != nil {
+			return err
+		}
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %w", set.Name, err)
+		}
+		for _, s := range statuses {
+			if s.State == goose.StatePending {
+				drift.Pending[set.Name] = append(drift.Pending[set.Name], s.Source.Path)
+			}
+		}
+	}
+
+	found := make(map[string]map[string]bool)
+	rows, err := db.QueryContext(ctx, `select table_name, column_name from information_schema.columns
+		where table_schema = current_schema()`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return err
+		}
+		if found[table] == nil {
+			found[table] = make(map[string]bool)
+		}
+		found[table][column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	drift.Missing = missingColumns(found)
+
+	if len(drift.Pending) == 0 && len(drift.Missing) == 0 {
+		return nil
+	}
+	return drift
+}
+
+func missingColumns(found map[string]map[string]bool) []string {
+	var missing []string
+	for table, columns := range expectedColumns {
+		if found[table] == nil {
+			missing = append(missing, table)
+			continue
+		}
+		for _, column := range columns {
+			if !found[table][column] {
+				missing = append(missing, table+"."+column)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,138 @@
+// Package migrations embeds the SQL migrations and applies them with goose.
+// There are two sets with their own version tables: app (the schema of this service)
+// and admin (GoAdmin's tables and their seed data).
+// Every command holds a Postgres advisory lock, so replicas starting at the same time
+// don't race: the first one migrates, the others wait and find nothing pending.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+
+	_ "github.com/lib/pq"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/database"
+	"github.com/pressly/goose/v3/lock"
+)
+
+//go:embed app/*.sql admin/*.sql
+var FS embed.FS
+
+// Set is a directory of migrations versioned in its own table
+type Set struct {
+	Name  string
+	Table string
+}
+
+var (
+	App   = Set{Name: "app", Table: "goose_db_version"}
+	Admin = Set{Name: "admin", Table: "goose_admin_db_version"}
+	// Sets in the order they are applied
+	Sets = []Set{App, Admin}
+)
+
+// SetByName is the set of the migrate command line
+func SetByName(name string) (Set, error) {
+	for _, set := range Sets {
+		if set.Name == name {
+			return set, nil
+		}
+	}
+	return Set{}, fmt.Errorf("unknown migration set %q, use app or admin", name)
+}
+
+// Open connects database/sql to dbURL, goose doesn't work with the pgx pool
+func Open(dbURL string) (*sql.DB, error) {
+	return sql.Open("postgres", dbURL)
+}
+
+// NewProvider is a goose provider of the embedded migrations of set holding the advisory lock
+func NewProvider(db *sql.DB, set Set) (*goose.Provider, error) {
+	fsys, err := fs.Sub(FS, set.Name)
+	if err != nil {
+		return nil, err
+	}
+	store, err := database.NewStore(database.DialectPostgres, set.Table)
+	if err != nil {
+		return nil, err
+	}
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, err
+	}
+	return goose.NewProvider("", db, fsys, goose.WithStore(store), goose.WithSessionLocker(locker))
+}
+
+// Up applies every pending migration of every set
+func Up(ctx context.Context, db *sql.DB) ([]*goose.MigrationResult, error) {
+	var results []*goose.MigrationResult
+	for _, set := range Sets {
+		provider, err := NewProvider(db, set)
+		if err != nil {
+			return results, err
+		}
+		applied, err := provider.Up(ctx)
+		results = append(results, applied...)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", set.Name, err)
+		}
+	}
+	return results, nil
+}
+
+// Run is the migrate command on set: up, down (the last migration), status or redo
+// (down and up of the last migration). The results are written to out.
+func Run(ctx context.Context, db *sql.DB, set Set, command string, out io.Writer) error {
+	provider, err := NewProvider(db, set)
+	if err != nil {
+		return err
+	}
+	var results []*goose.MigrationResult
+	switch command {
+	case "up":
+		results, err = provider.Up(ctx)
+		if err == nil && len(results) == 0 {
+			fmt.Fprintf(out, "%s: no migrations to apply\n", set.Name)
+		}
+	case "down":
+		var res *goose.MigrationResult
+		if res, err = provider.Down(ctx); res != nil {
+			results = append(results, res)
+		}
+	case "redo":
+		var res *goose.MigrationResult
+		if res, err = provider.Down(ctx); res != nil {
+			results = append(results, res)
+		}
+		if err == nil {
+			// the version rolled back, not the next pending one
+			res, err = provider.ApplyVersion(ctx, res.Source.Version, true)
+			if res != nil {
+				results = append(results, res)
+			}
+		}
+	case "status":
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.State == goose.StateApplied {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(out, "%-19s %s/%s\n", appliedAt, set.Name, s.Source.Path)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, use up, down, status or redo", command)
+	}
+	for _, res := range results {
+		fmt.Fprintln(out, res)
+	}
+	return err
+}
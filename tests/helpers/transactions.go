@@ -19,7 +19,7 @@ func clearDatabase(t *testing.T, pool *pgxpool.Pool) {
 	if err := UpMigrations(t); err != nil {
 		t.Fatalf("Failed to up migrations: %v", err)
 	}
-	_, err := pool.Exec(context.Background(), "TRUNCATE users RESTART IDENTITY")
+	_, err := pool.Exec(context.Background(), "TRUNCATE users RESTART IDENTITY CASCADE")
 	if err != nil {
 		t.Fatalf("Failed to clear database: %v", err)
 	}
@@ -56,3 +56,16 @@ func RunWithTransactions(t *testing.T, fn func(tx pgx.Tx) error) {
 
 	err = fn(tx)
 }
+
+// RunWithPool runs fn on a cleared DB without a transaction,
+// for tests that go on after a failed statement
+func RunWithPool(t *testing.T, fn func(pool *pgxpool.Pool)) {
+	pool, err := repository.InitDBConn(context.Background(), DBURL)
+	if err != nil {
+		log.Fatalf("Error initializing Test DB connection: %v\n", err)
+	}
+	defer pool.Close()
+
+	clearDatabase(t, pool)
+	fn(pool)
+}
@@ -1,29 +1,21 @@
 package helpers
 
 import (
-	"database/sql"
+	"AuthDB/migrations"
+	"context"
 	"log"
-	"os"
-	"path/filepath"
 	"testing"
-
-	"github.com/pressly/goose/v3"
 )
 
+// UpMigrations applies the embedded migrations to the test database, the same ones as the app
 func UpMigrations(t *testing.T) error {
-	testDB, err := sql.Open("postgres", DBURL)
+	testDB, err := migrations.Open(DBURL)
 	if err != nil {
 		log.Fatalf("Failed to connect to test database: %v", err)
 	}
+	defer testDB.Close()
 
-	currentDir, err := os.Getwd()
-	if err != nil {
-		log.Fatalf("Failed to get current directory: %v", err)
-	}
-	migrationDir := filepath.Join(currentDir, "../../migrations")
-
-	err = goose.Up(testDB, migrationDir)
-	if err != nil {
+	if _, err := migrations.Up(context.Background(), testDB); err != nil {
 		log.Fatalf("Failed to apply migrations: %v", err)
 	}
 	return nil
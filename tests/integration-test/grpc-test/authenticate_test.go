@@ -0,0 +1,68 @@
+package grpctest
+
+import (
+	"AuthDB/internal/api/user"
+	pb "AuthDB/pkg/user_v1"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticate / ValidateToken / Logout without a database behind them
+
+func TestAuthServiceRejectsBadInput(t *testing.T) {
+	port := ":50053"
+	accessService := newAccessService()
+
+	go func() {
+		err := user.StartGRPCServer(port, accessService, nil)
+		require.NoError(t, err)
+	}()
+
+	time.Sleep(time.Second * 1)
+
+	conn, err := grpc.Dial(port, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(2*time.Second))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := pb.NewAuthServiceClient(conn)
+	ctx := context.Background()
+
+	_, err = client.Authenticate(ctx, &pb.AuthenticateRequest{Username: "testuser"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	validateResp, err := client.ValidateToken(ctx, &pb.ValidateTokenRequest{Token: "not-a-token"})
+	require.NoError(t, err)
+	require.False(t, validateResp.Valid)
+	require.Nil(t, validateResp.Claims)
+
+	logoutResp, err := client.Logout(ctx, &pb.LogoutRequest{Token: "not-a-token"})
+	require.NoError(t, err)
+	require.False(t, logoutResp.Success)
+}
+
+func TestBatchCheckAccessRequiresToken(t *testing.T) {
+	port := ":50054"
+	go func() {
+		err := user.StartGRPCServer(port, newAccessService(), nil)
+		require.NoError(t, err)
+	}()
+
+	time.Sleep(time.Second * 1)
+
+	conn, err := grpc.Dial(port, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(2*time.Second))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := pb.NewAuthServiceClient(conn)
+	_, err = client.BatchCheckAccess(context.Background(), &pb.BatchAccessRequest{
+		Token:  "not-a-token",
+		Checks: []*pb.AccessCheck{{Resource: "profile", Action: "read"}},
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,59 @@
+package grpctest
+
+import (
+	"AuthDB/internal/access"
+	"context"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+// Envoy ext_authz Check without a database behind it
+
+func checkRequest(method, path string, headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{
+			Http: &authv3.AttributeContext_HttpRequest{Method: method, Path: path, Host: "app.local", Headers: headers},
+		},
+	}}
+}
+
+func TestExtAuthzCheck(t *testing.T) {
+	extAuthz := newAccessService().ExtAuthz(access.Routes{
+		{Prefix: "/public/", Public: true},
+		{Prefix: "/app/"},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// public route, nothing to inject
+	resp, err := extAuthz.Check(ctx, checkRequest("GET", "/public/logo.png?v=2", nil))
+	require.NoError(t, err)
+	require.Equal(t, int32(code.Code_OK), resp.Status.Code)
+	require.Empty(t, resp.GetOkResponse().Headers)
+	require.Contains(t, resp.GetOkResponse().HeadersToRemove, access.HeaderUserID)
+
+	// protected route without a session
+	resp, err = extAuthz.Check(ctx, checkRequest("GET", "/app/home", nil))
+	require.NoError(t, err)
+	require.Equal(t, int32(code.Code_UNAUTHENTICATED), resp.Status.Code)
+	require.Equal(t, typev3.StatusCode_Unauthorized, resp.GetDeniedResponse().Status.Code)
+
+	// invalid bearer token and invalid cookie
+	resp, err = extAuthz.Check(ctx, checkRequest("GET", "/app/home", map[string]string{"authorization": "Bearer nope"}))
+	require.NoError(t, err)
+	require.Equal(t, int32(code.Code_UNAUTHENTICATED), resp.Status.Code)
+	resp, err = extAuthz.Check(ctx, checkRequest("GET", "/app/home", map[string]string{"cookie": "token=nope"}))
+	require.NoError(t, err)
+	require.Equal(t, int32(code.Code_UNAUTHENTICATED), resp.Status.Code)
+
+	// no route at all
+	resp, err = extAuthz.Check(ctx, checkRequest("GET", "/other", nil))
+	require.NoError(t, err)
+	require.Equal(t, int32(code.Code_PERMISSION_DENIED), resp.Status.Code)
+	require.Equal(t, typev3.StatusCode_Forbidden, resp.GetDeniedResponse().Status.Code)
+}
@@ -0,0 +1,109 @@
+package grpctest
+
+import (
+	"AuthDB/internal/api/certs"
+	"AuthDB/internal/api/interceptor"
+	"AuthDB/internal/api/user"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// REST transcoding through grpc-gateway
+
+func TestGateway(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := interceptor.ServerOptions(interceptor.NewAuth(nil, nil, nil, user.MethodRules), time.Second)
+	grpcServer := user.NewGRPCServer(newAccessService(), user.NewRoleService(nil), opts...)
+	defer user.GracefulStop(grpcServer, time.Second)
+
+	gateway, err := user.NewGateway(ctx, grpcServer)
+	require.NoError(t, err)
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	// public method, the JSON body is the request message
+	resp, err := http.Post(server.URL+"/v1/auth:validate", "application/json", strings.NewReader(`{"token":"not-a-jwt"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var validated struct {
+		Valid   bool   `json:"valid"`
+		Message string `json:"message"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&validated))
+	require.False(t, validated.Valid)
+	require.Equal(t, "Invalid token", validated.Message)
+
+	// RoleService goes through the auth interceptor like over gRPC
+	resp, err = http.Get(server.URL + "/v1/roles")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// the Authorization header is forwarded as metadata
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/v1/users/7/roles", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Basic Zm9vOmJhcg==")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/v1/unknown")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// With TLS (here mutual TLS) on the public server the gateway still works,
+// it calls a plaintext server of its own with the same interceptors
+func TestGatewayTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	ca := newCA(t, "test ca")
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newServerCert(t, ca, "server").write(t, dir, "server")
+	reloader, err := certs.NewReloader(certs.Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	require.NoError(t, err)
+
+	opts := interceptor.ServerOptions(interceptor.NewAuth(nil, nil, nil, user.MethodRules), time.Second)
+	tlsServer := user.NewGRPCServer(newAccessService(), user.NewRoleService(nil),
+		append(opts, grpc.Creds(credentials.NewTLS(reloader.TLSConfig())))...)
+	defer user.GracefulStop(tlsServer, time.Second)
+	validate := func(gateway http.Handler) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/auth:validate", strings.NewReader(`{"token":"not-a-jwt"}`))
+		req.Header.Set("Content-Type", "application/json")
+		gateway.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the in-memory connection has no client certificate, the handshake fails
+	gateway, err := user.NewGateway(ctx, tlsServer)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, validate(gateway))
+
+	gatewayServer := user.NewGRPCServer(newAccessService(), user.NewRoleService(nil), opts...)
+	defer user.GracefulStop(gatewayServer, time.Second)
+	gateway, err = user.NewGateway(ctx, gatewayServer)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, validate(gateway))
+
+	// and the interceptors still check the calls
+	rec := httptest.NewRecorder()
+	gateway.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/roles", nil))
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
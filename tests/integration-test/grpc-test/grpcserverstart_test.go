@@ -1,24 +1,33 @@
 package grpctest
 
 import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
 	"AuthDB/internal/api/user"
+	pb "AuthDB/pkg/user_v1"
 	"context"
 	"testing"
 	"time"
-	pb "AuthDB/pkg/user_v1"
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 )
 
+// the service without a database behind it
+func newAccessService() *user.AccessService {
+	repo := repository.NewRepository(nil)
+	return user.NewAccessService(auth.NewService(repository.NewMemoryUserStore()), access.NewChecker(repo, nil))
+}
+
 // gRPC StartServer func test
 
 func TestStartGRPCServer(t *testing.T) {
 	port := ":50052"
-	accessService := &user.AccessService{}
+	accessService := newAccessService()
 
 	go func() {
-		err := user.StartGRPCServer(port, accessService)
+		err := user.StartGRPCServer(port, accessService, nil)
 		require.NoError(t, err)
 	}()
 
@@ -0,0 +1,67 @@
+package grpctest
+
+import (
+	apphealth "AuthDB/internal/api/health"
+	"AuthDB/internal/api/interceptor"
+	"AuthDB/internal/api/user"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// grpc.health.v1 and graceful stop
+
+func TestHealthAndGracefulStop(t *testing.T) {
+	port := ":50056"
+	accessService := newAccessService()
+
+	opts := interceptor.ServerOptions(interceptor.NewAuth(nil, nil, nil, user.MethodRules), time.Second)
+	grpcServer := user.NewGRPCServer(accessService, nil, opts...)
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	var dbErr error
+	monitor := apphealth.NewMonitor(healthServer, user.ServiceNames, map[string]apphealth.Probe{
+		"database": func(ctx context.Context) error { return dbErr },
+	})
+
+	lis, err := net.Listen("tcp", port)
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- grpcServer.Serve(lis) }()
+
+	conn, err := grpc.Dial(port, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	// the health service is public, no credentials needed
+	require.Empty(t, monitor.Check(context.Background()))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check("access.AuthService"))
+
+	dbErr = errors.New("connection refused")
+	require.Contains(t, monitor.Check(context.Background()), "database")
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("access.RoleService"))
+
+	healthServer.Shutdown()
+	user.GracefulStop(grpcServer, 2*time.Second)
+	require.NoError(t, <-served)
+}
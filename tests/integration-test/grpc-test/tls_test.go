@@ -0,0 +1,190 @@
+package grpctest
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"AuthDB/internal/api/certs"
+	"AuthDB/internal/api/interceptor"
+	"AuthDB/internal/api/user"
+	pb "AuthDB/pkg/user_v1"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// TLS and mutual TLS for the gRPC server
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func issue(t *testing.T, tmpl *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func newCA(t *testing.T, name string) *testCert {
+	return issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func newServerCert(t *testing.T, ca *testCert, name string) *testCert {
+	return issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca)
+}
+
+func newClientCert(t *testing.T, ca *testCert, uri string) *testCert {
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	return issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		URIs:        []*url.URL{u},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	}, ca)
+}
+
+func TestMutualTLS(t *testing.T) {
+	port := ":50055"
+	dir := t.TempDir()
+
+	ca := newCA(t, "test ca")
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newServerCert(t, ca, "first").write(t, dir, "server")
+
+	reloader, err := certs.NewReloader(certs.Config{
+		CertFile:           certFile,
+		KeyFile:            keyFile,
+		ClientCAFile:       caFile,
+		ClientCertOptional: true,
+	})
+	require.NoError(t, err)
+
+	// ValidateToken needs an authenticated caller here, so the certificate is what lets it through
+	repo := repository.NewRepository(nil)
+	authService := auth.NewService(repository.NewMemoryUserStore())
+	grpcAuth := interceptor.NewAuth(authService, repo, access.NewChecker(repo, nil), interceptor.Rules{
+		"/access.AuthService/ValidateToken": {},
+	})
+	grpcAuth.TrustClientCertificates(map[string][]string{"spiffe://authdb/billing": {"service"}})
+	opts := append(interceptor.ServerOptions(grpcAuth, time.Second),
+		grpc.Creds(credentials.NewTLS(reloader.TLSConfig())))
+
+	go func() {
+		err := user.StartGRPCServer(port, user.NewAccessService(authService, access.NewChecker(repo, nil)), nil, opts...)
+		require.NoError(t, err)
+	}()
+	time.Sleep(time.Second * 1)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	call := func(clientCerts ...tls.Certificate) (*tls.ConnectionState, error) {
+		var state tls.ConnectionState
+		creds := credentials.NewTLS(&tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: clientCerts,
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				state = cs
+				return nil
+			},
+		})
+		conn, err := grpc.Dial("localhost"+port, grpc.WithTransportCredentials(creds))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_, err = pb.NewAuthServiceClient(conn).ValidateToken(ctx, &pb.ValidateTokenRequest{Token: "x"})
+		return &state, err
+	}
+
+	// a known client certificate authenticates the service
+	state, err := call(newClientCert(t, ca, "spiffe://authdb/billing").tlsCert())
+	require.NoError(t, err)
+	require.Equal(t, "first", state.PeerCertificates[0].Subject.CommonName)
+
+	// a certificate signed by the CA but with an unknown identity is rejected
+	_, err = call(newClientCert(t, ca, "spiffe://authdb/unknown").tlsCert())
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// no certificate and no token
+	_, err = call()
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// a certificate from another CA fails the handshake
+	_, err = call(newClientCert(t, newCA(t, "other ca"), "spiffe://authdb/billing").tlsCert())
+	require.Error(t, err)
+
+	// rotate the server certificate, new connections see it without a restart
+	newServerCert(t, ca, "second").write(t, dir, "server")
+	require.NoError(t, reloader.Reload())
+	state, err = call(newClientCert(t, ca, "spiffe://authdb/billing").tlsCert())
+	require.NoError(t, err)
+	require.Equal(t, "second", state.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestParseIdentities(t *testing.T) {
+	ids, err := certs.ParseIdentities("spiffe://authdb/billing=service; reports.internal=service admin;")
+	require.NoError(t, err)
+	require.Equal(t, []string{"service"}, ids["spiffe://authdb/billing"])
+	require.Equal(t, []string{"service", "admin"}, ids["reports.internal"])
+
+	_, err = certs.ParseIdentities("no-roles=")
+	require.Error(t, err)
+}
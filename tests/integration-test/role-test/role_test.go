@@ -0,0 +1,118 @@
+package roletest
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/tests/helpers"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Role tests (using test db)
+func TestNewUserGetsDefaultRole(t *testing.T) {
+	helpers.RunWithTransactions(t, func(tx pgx.Tx) error {
+		ctx := context.Background()
+		repo := &repository.Repository{}
+		user, err := repository.NewUser("testuser", "testuser@example.com", "qwerty123")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if err := user.Add(ctx, tx); err != nil {
+			t.Fatalf("Failed to add user: %v", err)
+		}
+
+		u, err := repo.GetByID(ctx, tx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user by id: %v", err)
+		}
+		if len(u.Roles) != 1 || u.Roles[0] != repository.DefaultRole {
+			t.Errorf("Expected roles [%s], got %v", repository.DefaultRole, u.Roles)
+		}
+		return nil
+	})
+}
+
+func TestRoleInheritance(t *testing.T) {
+	helpers.RunWithTransactions(t, func(tx pgx.Tx) error {
+		ctx := context.Background()
+		repo := &repository.Repository{}
+		user := &repository.User{
+			Username: "testuser",
+			Password: "qwerty123",
+			Email:    "testuser@example.com",
+		}
+		if err := user.Add(ctx, tx); err != nil {
+			t.Fatalf("Failed to add user: %v", err)
+		}
+
+		base, err := repo.GetRoleByName(ctx, tx, repository.DefaultRole)
+		if err != nil {
+			t.Fatalf("Failed to get default role: %v", err)
+		}
+		support := &repository.Role{Name: "support", ParentID: &base.ID}
+		if err := repo.CreateRole(ctx, tx, support); err != nil {
+			t.Fatalf("Failed to create role: %v", err)
+		}
+		perm := &repository.Permission{Resource: "tickets", Action: "read"}
+		if err := repo.CreatePermission(ctx, tx, perm); err != nil {
+			t.Fatalf("Failed to create permission: %v", err)
+		}
+		if err := repo.GrantPermission(ctx, tx, support.ID, perm.ID); err != nil {
+			t.Fatalf("Failed to grant permission: %v", err)
+		}
+		if err := repo.AssignRole(ctx, tx, user.ID, support.ID); err != nil {
+			t.Fatalf("Failed to assign role: %v", err)
+		}
+
+		roles, err := repo.EffectiveRoles(ctx, tx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get effective roles: %v", err)
+		}
+		if len(roles) != 2 {
+			t.Errorf("Expected user and support roles, got %v", roles)
+		}
+
+		// the same for a service with the support role
+		roles, err = repo.RolesEffective(ctx, tx, []string{"support"})
+		if err != nil {
+			t.Fatalf("Failed to get effective roles: %v", err)
+		}
+		if want := []string{"support", repository.DefaultRole}; len(roles) != 2 || !containsAll(roles, want) {
+			t.Errorf("Expected %v, got %v", want, roles)
+		}
+
+		permissions, err := repo.UserPermissions(ctx, tx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user permissions: %v", err)
+		}
+		var tickets, profile bool
+		for _, p := range permissions {
+			tickets = tickets || p.Allows("tickets", "read")
+			profile = profile || p.Allows("profile", "read")
+		}
+		if !tickets || !profile {
+			t.Errorf("Expected own and inherited permissions, got %+v", permissions)
+		}
+
+		// user -> support -> user would be a cycle
+		if err := repo.SetRoleParent(ctx, tx, base.ID, &support.ID); !errors.Is(err, repository.ErrRoleCycle) {
+			t.Errorf("Expected ErrRoleCycle, got %v", err)
+		}
+		return nil
+	})
+}
+
+func containsAll(values, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, v := range values {
+			found = found || v == w
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package usertest
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/pii"
+	"AuthDB/tests/helpers"
+	"AuthDB/tests/storetest"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func piiCipher(t *testing.T, kf *pii.KeyFile, id string) *pii.Cipher {
+	t.Helper()
+	key, _ := pii.GenerateKey()
+	if kf.Keys == nil {
+		kf.Keys = map[string]string{}
+		kf.IndexKey, _ = pii.GenerateKey()
+	}
+	kf.Keys[id], kf.Current = key, id
+	c, err := pii.NewCipher(*kf)
+	if err != nil {
+		t.Fatalf("Failed to load the keys: %v", err)
+	}
+	return c
+}
+
+// the store behaves the same with the emails encrypted
+func TestPostgresUserStoreEncrypted(t *testing.T) {
+	cipher := piiCipher(t, &pii.KeyFile{}, "k1")
+	storetest.TestUserStore(t, func(t *testing.T, fn func(store repository.UserStore)) {
+		helpers.RunWithPool(t, func(pool *pgxpool.Pool) {
+			fn(repository.NewPostgresUserStore(pool, cipher))
+		})
+	})
+}
+
+func TestRotatePII(t *testing.T) {
+	helpers.RunWithPool(t, func(pool *pgxpool.Pool) {
+		ctx := context.Background()
+		rawEmail := func(id int) string {
+			var email string
+			if err := pool.QueryRow(ctx, `select email from users where id = $1`, id).Scan(&email); err != nil {
+				t.Fatalf("Failed to read the email: %v", err)
+			}
+			return email
+		}
+
+		// written before the encryption was turned on
+		plain := repository.NewPostgresUserStore(pool, nil)
+		for _, name := range []string{"alice", "bobby", "carol"} {
+			if err := plain.CreateUser(ctx, &repository.User{Username: name, Email: name + "@example.com", Password: "hash"}); err != nil {
+				t.Fatalf("Failed to create %s: %v", name, err)
+			}
+		}
+
+		var kf pii.KeyFile
+		store := repository.NewPostgresUserStore(pool, piiCipher(t, &kf, "k1"))
+		// the plaintext rows are still found before the rotation
+		if u, err := store.GetUserByEmail(ctx, "alice@example.com"); err != nil || u.ID != 1 {
+			t.Errorf("Expected alice by her plaintext email, got %v", err)
+		}
+
+		// the order of the ciphertext means nothing
+		if _, err := store.ListUsers(ctx, repository.UserFilter{Sort: "email"}); !errors.Is(err, repository.ErrEncryptedSort) {
+			t.Errorf("Expected ErrEncryptedSort, got %v", err)
+		}
+
+		rotate := func(store *repository.PostgresUserStore) int {
+			total := 0
+			for {
+				n, err := store.RotatePII(ctx, 2)
+				if err != nil {
+					t.Fatalf("Failed to rotate: %v", err)
+				}
+				if n == 0 {
+					return total
+				}
+				total += n
+			}
+		}
+		if n := rotate(store); n != 3 {
+			t.Errorf("Expected 3 rows encrypted, got %d", n)
+		}
+		first := rawEmail(1)
+		if !pii.IsEncrypted(first) {
+			t.Fatalf("Expected the email encrypted in the table, got %q", first)
+		}
+		u, err := store.GetUserByEmail(ctx, "ALICE@example.com")
+		if err != nil || u.Email != "alice@example.com" || u.Version != 1 {
+			t.Errorf("Expected alice by the blind index with the version unchanged, got %+v, %v", u, err)
+		}
+		if err := store.CreateUser(ctx, &repository.User{Username: "alice2", Email: "alice@example.com", Password: "hash"}); err == nil {
+			t.Errorf("Expected the blind index to keep the emails unique")
+		}
+
+		// a new key-encryption key, the old one stays until the rotation is done
+		rotated := repository.NewPostgresUserStore(pool, piiCipher(t, &kf, "k2"))
+		if n := rotate(rotated); n != 3 {
+			t.Errorf("Expected 3 rows re-encrypted, got %d", n)
+		}
+		if rawEmail(1) == first {
+			t.Errorf("Expected the row re-encrypted with a new data key")
+		}
+		delete(kf.Keys, "k1")
+		c, _ := pii.NewCipher(kf)
+		if u, err := repository.NewPostgresUserStore(pool, c).GetUser(ctx, 3); err != nil || u.Email != "carol@example.com" {
+			t.Errorf("Expected carol readable without the old key, got %+v, %v", u, err)
+		}
+	})
+}
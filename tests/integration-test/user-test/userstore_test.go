@@ -0,0 +1,135 @@
+package usertest
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/tests/helpers"
+	"AuthDB/tests/storetest"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func TestPostgresUserStore(t *testing.T) {
+	storetest.TestUserStore(t, func(t *testing.T, fn func(store repository.UserStore)) {
+		helpers.RunWithPool(t, func(pool *pgxpool.Pool) {
+			fn(repository.NewPostgresUserStore(pool, nil))
+		})
+	})
+}
+
+// the primary is its own replica here: no lag, the same rows
+func TestClusterUserStore(t *testing.T) {
+	storetest.TestUserStore(t, func(t *testing.T, fn func(store repository.UserStore)) {
+		helpers.RunWithPool(t, func(pool *pgxpool.Pool) {
+			cluster, err := repository.NewCluster(context.Background(), pool, helpers.DBURL)
+			if err != nil {
+				t.Fatalf("Failed to make the cluster: %v", err)
+			}
+			defer cluster.Close()
+			cluster.Check(context.Background())
+			fn(repository.NewPostgresUserStore(cluster, nil))
+		})
+	})
+}
+
+func TestClusterStickiness(t *testing.T) {
+	helpers.RunWithPool(t, func(pool *pgxpool.Pool) {
+		ctx := context.Background()
+		cluster, err := repository.NewCluster(ctx, pool, helpers.DBURL)
+		if err != nil {
+			t.Fatalf("Failed to make the cluster: %v", err)
+		}
+		defer cluster.Close()
+		cluster.StickyFor = 100 * time.Millisecond
+		cluster.Check(ctx)
+		if cluster.Healthy() != 1 {
+			t.Fatalf("Expected the replica to be healthy")
+		}
+
+		alice, bob := repository.WithSession(ctx, "user 1"), repository.WithSession(ctx, "user 2")
+		if cluster.Reader(alice) == pool {
+			t.Errorf("Expected the reads on the replica")
+		}
+		store := repository.NewPostgresUserStore(cluster, nil)
+		if err := store.CreateUser(alice, &repository.User{Username: "alice", Email: "alice@example.com", Password: "hash"}); err != nil {
+			t.Fatalf("Failed to create: %v", err)
+		}
+		if cluster.Reader(alice) != pool {
+			t.Errorf("Expected the reads of alice on the primary after her write")
+		}
+		if cluster.Reader(bob) == pool {
+			t.Errorf("Expected the reads of bob on the replica")
+		}
+		if cluster.Reader(repository.OnPrimary(bob)) != pool {
+			t.Errorf("Expected the reads of bob on the primary with OnPrimary")
+		}
+		if _, err := store.GetUserByUsername(alice, "alice"); err != nil {
+			t.Errorf("Failed to read the write: %v", err)
+		}
+		time.Sleep(cluster.StickyFor)
+		if cluster.Reader(alice) == pool {
+			t.Errorf("Expected the reads of alice back on the replica")
+		}
+	})
+}
+
+func TestPostgresEventLog(t *testing.T) {
+	storetest.TestEventLog(t, func(t *testing.T, fn func(users repository.UserStore, events repository.EventLog)) {
+		helpers.RunWithPool(t, func(pool *pgxpool.Pool) {
+			fn(repository.NewPostgresUserStore(pool, nil), repository.NewPostgresEventLog(pool))
+		})
+	})
+}
+
+func TestWithTx(t *testing.T) {
+	helpers.RunWithPool(t, func(pool *pgxpool.Pool) {
+		ctx := context.Background()
+		store := repository.NewPostgresUserStore(pool, nil)
+		newUser := func(name string) *repository.User {
+			return &repository.User{Username: name, Email: name + "@example.com", Password: "hash"}
+		}
+		failed := errors.New("failed")
+
+		err := store.WithTx(ctx, func(ctx context.Context) error {
+			if err := store.CreateUser(ctx, newUser("alice")); err != nil {
+				return err
+			}
+			// the savepoint is rolled back, alice stays
+			err := store.WithTx(ctx, func(ctx context.Context) error {
+				if err := store.CreateUser(ctx, newUser("bob")); err != nil {
+					return err
+				}
+				return store.CreateUser(ctx, newUser("bob"))
+			})
+			if !errors.Is(err, repository.ErrUserExists) {
+				t.Errorf("Expected ErrUserExists, got %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+		for name, want := range map[string]bool{"alice": true, "bob": false} {
+			if exists, _ := store.UserExists(ctx, name, ""); exists != want {
+				t.Errorf("%s exists: %v, want %v", name, exists, want)
+			}
+		}
+
+		err = store.WithTx(ctx, func(ctx context.Context) error {
+			if err := store.CreateUser(ctx, newUser("carol")); err != nil {
+				return err
+			}
+			return failed
+		}, repository.Isolation(pgx.Serializable))
+		if !errors.Is(err, failed) {
+			t.Errorf("Expected the error of fn, got %v", err)
+		}
+		if exists, _ := store.UserExists(ctx, "carol", ""); exists {
+			t.Errorf("carol must be rolled back")
+		}
+	})
+}
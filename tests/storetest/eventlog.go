@@ -0,0 +1,117 @@
+package storetest
+
+import (
+	"AuthDB/cmd/app/repository"
+	"context"
+	"errors"
+	"testing"
+)
+
+// WithEventLog runs fn with an empty user store and the event log next to it
+type WithEventLog func(t *testing.T, fn func(users repository.UserStore, events repository.EventLog))
+
+// TestEventLog is the suite every repository.EventLog has to pass
+func TestEventLog(t *testing.T, withLog WithEventLog) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, users repository.UserStore, events repository.EventLog)
+	}{
+		{"RecordAndList", testRecordEvents},
+		{"Erase", testEraseEvents},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withLog(t, func(users repository.UserStore, events repository.EventLog) { tt.fn(t, users, events) })
+		})
+	}
+}
+
+func record(t *testing.T, events repository.EventLog, userID int, actorID *int, event string, details map[string]interface{}) {
+	t.Helper()
+	e := &repository.UserEvent{UserID: userID, ActorID: actorID, Event: event, Details: details}
+	if err := events.RecordEvent(context.Background(), e); err != nil {
+		t.Fatalf("Failed to record %s: %v", event, err)
+	}
+	if e.ID == 0 || e.CreatedAt.IsZero() {
+		t.Errorf("Expected ID and CreatedAt to be set, got %+v", e)
+	}
+}
+
+func eventNames(events []repository.UserEvent) []string {
+	names := []string{}
+	for _, e := range events {
+		names = append(names, e.Event)
+	}
+	return names
+}
+
+func testRecordEvents(t *testing.T, users repository.UserStore, events repository.EventLog) {
+	ctx := context.Background()
+	alice := create(t, users, "alice")
+	bob := create(t, users, "bob")
+
+	record(t, events, alice.ID, nil, "login", map[string]interface{}{"remember_me": "yes"})
+	record(t, events, bob.ID, &alice.ID, "admin_user_disabled", nil)
+	record(t, events, bob.ID, nil, "login_failed", nil)
+
+	got, err := events.UserEvents(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	// what alice did to bob is in her history too
+	if names := eventNames(got); len(names) != 2 || names[0] != "login" || names[1] != "admin_user_disabled" {
+		t.Fatalf("Expected login and admin_user_disabled, got %v", names)
+	}
+	if got[0].Details["remember_me"] != "yes" || got[0].ActorID != nil {
+		t.Errorf("Expected the details of the login, got %+v", got[0])
+	}
+	if got[1].ActorID == nil || *got[1].ActorID != alice.ID || got[1].UserID != bob.ID || got[1].Details == nil {
+		t.Errorf("Expected alice as the actor, got %+v", got[1])
+	}
+
+	got, _ = events.UserEvents(ctx, bob.ID)
+	if names := eventNames(got); len(names) != 2 || names[0] != "admin_user_disabled" || names[1] != "login_failed" {
+		t.Errorf("Expected admin_user_disabled and login_failed, got %v", names)
+	}
+}
+
+func testEraseEvents(t *testing.T, users repository.UserStore, events repository.EventLog) {
+	ctx := context.Background()
+	alice := create(t, users, "alice")
+	bob := create(t, users, "bob")
+	record(t, events, alice.ID, nil, "login", map[string]interface{}{"remember_me": "yes"})
+	record(t, events, bob.ID, &alice.ID, "admin_user_updated", map[string]interface{}{"fields": "email"})
+	record(t, events, alice.ID, &bob.ID, "admin_user_created", map[string]interface{}{"roles": "user"})
+
+	if err := events.EraseUserEvents(ctx, alice.ID); err != nil {
+		t.Fatalf("Failed to erase: %v", err)
+	}
+	if err := users.EraseUser(ctx, alice.ID); err != nil {
+		t.Fatalf("Failed to erase the user: %v", err)
+	}
+	if err := users.EraseUser(ctx, alice.ID); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for an erased user, got %v", err)
+	}
+
+	// the rows stay for the audit trail, only what is about alice is gone
+	got, _ := events.UserEvents(ctx, alice.ID)
+	if len(got) != 3 {
+		t.Fatalf("Expected the 3 events to stay, got %d", len(got))
+	}
+	for _, e := range got {
+		if e.UserID == alice.ID && len(e.Details) != 0 {
+			t.Errorf("Expected no details left in %s, got %v", e.Event, e.Details)
+		}
+		if e.UserID == bob.ID && e.Details["fields"] != "email" {
+			t.Errorf("Expected the event about bob to keep its details, got %v", e.Details)
+		}
+	}
+
+	page, _ := users.ListUsers(ctx, repository.UserFilter{Status: repository.StatusDeleted})
+	if deleted := page.Users; len(deleted) != 1 || deleted[0].ID != alice.ID || deleted[0].Email == alice.Email {
+		t.Errorf("Expected alice anonymized, got %+v", deleted)
+	}
+	if _, err := users.GetUser(ctx, bob.ID); err != nil {
+		t.Errorf("bob must stay: %v", err)
+	}
+}
@@ -0,0 +1,646 @@
+// Package storetest is the suite every repository.UserStore has to pass
+package storetest
+
+import (
+	"AuthDB/cmd/app/repository"
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// WithStore runs fn with an empty store
+type WithStore func(t *testing.T, fn func(store repository.UserStore))
+
+func TestUserStore(t *testing.T, withStore WithStore) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store repository.UserStore)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"Duplicates", testDuplicates},
+		{"UnknownRole", testUnknownRole},
+		{"Update", testUpdate},
+		{"List", testList},
+		{"ListPages", testListPages},
+		{"Search", testSearch},
+		{"Disable", testDisable},
+		{"SetRoles", testSetRoles},
+		{"Delete", testDelete},
+		{"Version", testVersion},
+		{"Deletion", testDeletion},
+		{"Purge", testPurge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStore(t, func(store repository.UserStore) { tt.fn(t, store) })
+		})
+	}
+}
+
+func create(t *testing.T, store repository.UserStore, username string, roles ...string) *repository.User {
+	t.Helper()
+	u := &repository.User{Username: username, Email: username + "@example.com", Password: "hash", Roles: roles}
+	if err := store.CreateUser(context.Background(), u); err != nil {
+		t.Fatalf("Failed to create %s: %v", username, err)
+	}
+	return u
+}
+
+func testCreateAndGet(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	u := create(t, store, "alice")
+	if u.ID == 0 || u.CreatedAt == nil {
+		t.Fatalf("Expected ID and CreatedAt to be set, got %d %v", u.ID, u.CreatedAt)
+	}
+
+	for name, get := range map[string]func() (repository.User, error){
+		"id":       func() (repository.User, error) { return store.GetUser(ctx, u.ID) },
+		"username": func() (repository.User, error) { return store.GetUserByUsername(ctx, "alice") },
+		"email":    func() (repository.User, error) { return store.GetUserByEmail(ctx, "alice@example.com") },
+	} {
+		got, err := get()
+		if err != nil {
+			t.Fatalf("Failed to get by %s: %v", name, err)
+		}
+		if got.ID != u.ID || got.Username != "alice" || got.Email != "alice@example.com" || got.Password != "hash" {
+			t.Errorf("Get by %s: got %+v", name, got)
+		}
+		if !reflect.DeepEqual(got.Roles, []string{repository.DefaultRole}) {
+			t.Errorf("Get by %s: expected the default role, got %v", name, got.Roles)
+		}
+		if got.DisabledAt != nil {
+			t.Errorf("Get by %s: a new user must not be disabled", name)
+		}
+	}
+
+	if _, err := store.GetUser(ctx, u.ID+1000); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := store.GetUserByUsername(ctx, "nobody"); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := store.GetUserByEmail(ctx, "nobody@example.com"); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func testDuplicates(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	create(t, store, "alice")
+
+	sameName := &repository.User{Username: "alice", Email: "other@example.com", Password: "hash"}
+	if err := store.CreateUser(ctx, sameName); !errors.Is(err, repository.ErrUserExists) {
+		t.Errorf("Expected ErrUserExists for the username, got %v", err)
+	}
+	sameEmail := &repository.User{Username: "other", Email: "alice@example.com", Password: "hash"}
+	if err := store.CreateUser(ctx, sameEmail); !errors.Is(err, repository.ErrUserExists) {
+		t.Errorf("Expected ErrUserExists for the email, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		username, email string
+		exists          bool
+	}{
+		{"alice", "", true},
+		{"", "alice@example.com", true},
+		{"bob", "bob@example.com", false},
+	} {
+		exists, err := store.UserExists(ctx, tt.username, tt.email)
+		if err != nil {
+			t.Fatalf("Failed to check %q %q: %v", tt.username, tt.email, err)
+		}
+		if exists != tt.exists {
+			t.Errorf("UserExists(%q, %q) = %v, want %v", tt.username, tt.email, exists, tt.exists)
+		}
+	}
+}
+
+func testUnknownRole(t *testing.T, store repository.UserStore) {
+	u := &repository.User{Username: "alice", Email: "alice@example.com", Password: "hash", Roles: []string{"no-such-role"}}
+	if err := store.CreateUser(context.Background(), u); !errors.Is(err, repository.ErrRoleNotFound) {
+		t.Errorf("Expected ErrRoleNotFound, got %v", err)
+	}
+	if exists, _ := store.UserExists(context.Background(), "alice", ""); exists {
+		t.Errorf("The user must not be created")
+	}
+}
+
+func str(s string) *string { return &s }
+
+func testUpdate(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	u := create(t, store, "alice")
+	create(t, store, "bob")
+
+	got, err := store.UpdateUser(ctx, u.ID, repository.UserPatch{Username: str("alicia"), Email: str("alicia@example.com")})
+	if err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if got.ID != u.ID || got.Username != "alicia" || got.Email != "alicia@example.com" || got.Password != "hash" {
+		t.Errorf("Expected the new username and email, got %+v", got)
+	}
+	if !reflect.DeepEqual(got.Roles, []string{repository.DefaultRole}) {
+		t.Errorf("Expected the roles to stay, got %v", got.Roles)
+	}
+
+	got, err = store.UpdateUser(ctx, u.ID, repository.UserPatch{Password: str("newhash")})
+	if err != nil {
+		t.Fatalf("Failed to update the password: %v", err)
+	}
+	if got.Username != "alicia" || got.Password != "newhash" {
+		t.Errorf("Expected only the password to change, got %+v", got)
+	}
+	if got, err = store.UpdateUser(ctx, u.ID, repository.UserPatch{}); err != nil || got.Username != "alicia" {
+		t.Errorf("An empty patch must return the user, got %+v %v", got, err)
+	}
+
+	tests := []struct {
+		name  string
+		id    int
+		patch repository.UserPatch
+		err   error
+	}{
+		{"username taken", u.ID, repository.UserPatch{Username: str("bob")}, repository.ErrUsernameTaken},
+		{"email taken", u.ID, repository.UserPatch{Email: str("bob@example.com")}, repository.ErrEmailTaken},
+		// nothing is saved when one field fails
+		{"both at once", u.ID, repository.UserPatch{Username: str("carol"), Email: str("bob@example.com")}, repository.ErrEmailTaken},
+		{"missing user", u.ID + 1000, repository.UserPatch{Username: str("ghost")}, repository.ErrUserNotFound},
+		{"missing user, empty patch", u.ID + 1000, repository.UserPatch{}, repository.ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := store.UpdateUser(ctx, tt.id, tt.patch); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+	if got, _ = store.GetUser(ctx, u.ID); got.Username != "alicia" || got.Email != "alicia@example.com" {
+		t.Errorf("The failed updates must not change anything, got %+v", got)
+	}
+}
+
+func usernames(users []repository.User) []string {
+	names := []string{}
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+	return names
+}
+
+func testList(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	create(t, store, "carol")
+	alice := create(t, store, "alice", "admin")
+	create(t, store, "bob")
+	create(t, store, "dave")
+	if err := store.SetUserDisabled(ctx, alice.ID, true); err != nil {
+		t.Fatalf("Failed to disable: %v", err)
+	}
+	yes, no := true, false
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		filter repository.UserFilter
+		want   []string
+		total  int
+	}{
+		{"all by id", repository.UserFilter{}, []string{"carol", "alice", "bob", "dave"}, 4},
+		{"by username", repository.UserFilter{Sort: "username"}, []string{"alice", "bob", "carol", "dave"}, 4},
+		{"descending page", repository.UserFilter{Sort: "username", Desc: true, Limit: 2}, []string{"dave", "carol"}, 4},
+		{"search ignores case", repository.UserFilter{Search: "AR"}, []string{"carol"}, 1},
+		// the whole address, encrypted emails are found by nothing else
+		{"search email", repository.UserFilter{Search: "BOB@example.com"}, []string{"bob"}, 1},
+		{"role", repository.UserFilter{Role: "admin"}, []string{"alice"}, 1},
+		{"disabled", repository.UserFilter{Disabled: &yes}, []string{"alice"}, 1},
+		{"enabled", repository.UserFilter{Disabled: &no, Limit: 1}, []string{"carol"}, 3},
+		{"email domain", repository.UserFilter{EmailDomain: "@EXAMPLE.com", Sort: "username"}, []string{"alice", "bob", "carol", "dave"}, 4},
+		{"other email domain", repository.UserFilter{EmailDomain: "example.org"}, []string{}, 0},
+		{"created before", repository.UserFilter{CreatedTo: &past}, []string{}, 0},
+		{"created after", repository.UserFilter{CreatedFrom: &past, CreatedTo: &future}, []string{"carol", "alice", "bob", "dave"}, 4},
+		{"nothing matches", repository.UserFilter{Search: "zzz"}, []string{}, 0},
+	}
+	for _, tt := range tests {
+		tt.filter.CountTotal = true
+		page, err := store.ListUsers(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: failed to list: %v", tt.name, err)
+		}
+		if got := usernames(page.Users); !reflect.DeepEqual(got, tt.want) || page.Total == nil || *page.Total != tt.total {
+			t.Errorf("%s: got %v of %v, want %v of %d", tt.name, got, page.Total, tt.want, tt.total)
+		}
+	}
+
+	if page, _ := store.ListUsers(ctx, repository.UserFilter{}); page.Total != nil {
+		t.Errorf("Expected no total without CountTotal, got %d", *page.Total)
+	}
+	bob, _ := store.GetUserByUsername(ctx, "bob")
+	justBob := bob.CreatedAt.Add(time.Microsecond)
+	page, _ := store.ListUsers(ctx, repository.UserFilter{CreatedFrom: bob.CreatedAt, CreatedTo: &justBob})
+	if got := usernames(page.Users); !containsName(got, "bob") {
+		t.Errorf("Expected bob created in [CreatedAt, CreatedAt+1µs), got %v", got)
+	}
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// the pages walked with the cursors are the whole listing, in every order
+func testListPages(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	for _, name := range []string{"mallory", "Bob", "alice", "dave", "carol", "erin", "frank"} {
+		create(t, store, name)
+	}
+	// the same username key, id decides
+	other := &repository.User{Username: "ALICE2", Email: "alice2@example.org", Password: "hash"}
+	if err := store.CreateUser(ctx, other); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+
+	for _, sort := range []string{"", "id", "username", "email", "created_at"} {
+		for _, desc := range []bool{false, true} {
+			all, err := store.ListUsers(ctx, repository.UserFilter{Sort: sort, Desc: desc})
+			if sort == "email" && errors.Is(err, repository.ErrEncryptedSort) {
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: failed to list: %v", sort, err)
+			}
+			if all.Next != "" {
+				t.Errorf("%s: expected no next page without a limit", sort)
+			}
+			var walked []string
+			filter := repository.UserFilter{Sort: sort, Desc: desc, Limit: 3}
+			for pages := 0; ; pages++ {
+				if pages > len(all.Users) {
+					t.Fatalf("%s desc=%v: the cursors don't end", sort, desc)
+				}
+				page, err := store.ListUsers(ctx, filter)
+				if err != nil {
+					t.Fatalf("%s desc=%v: failed to list page %d: %v", sort, desc, pages, err)
+				}
+				if len(page.Users) == 0 {
+					t.Fatalf("%s desc=%v: empty page %d", sort, desc, pages)
+				}
+				walked = append(walked, usernames(page.Users)...)
+				if page.Next == "" {
+					break
+				}
+				filter.Cursor = page.Next
+			}
+			if want := usernames(all.Users); !reflect.DeepEqual(walked, want) {
+				t.Errorf("%s desc=%v: walked %v, want %v", sort, desc, walked, want)
+			}
+		}
+	}
+
+	page, _ := store.ListUsers(ctx, repository.UserFilter{Sort: "username", Limit: 2})
+	if got := usernames(page.Users); !reflect.DeepEqual(got, []string{"alice", "ALICE2"}) {
+		t.Errorf("Expected the usernames ignoring case, got %v", got)
+	}
+	// the GoAdmin table pages by offset
+	byOffset, _ := store.ListUsers(ctx, repository.UserFilter{Sort: "username", Limit: 2, Offset: 2})
+	if got := usernames(byOffset.Users); !reflect.DeepEqual(got, []string{"Bob", "carol"}) || byOffset.Next == "" {
+		t.Errorf("Expected Bob and carol with a next page, got %v", got)
+	}
+	if past, _ := store.ListUsers(ctx, repository.UserFilter{Limit: 2, Offset: 100}); len(past.Users) != 0 || past.Next != "" {
+		t.Errorf("Expected nothing past the end, got %v", usernames(past.Users))
+	}
+	// a cursor only works for the order it was made for
+	for _, f := range []repository.UserFilter{
+		{Sort: "created_at", Cursor: page.Next},
+		{Sort: "username", Desc: true, Cursor: page.Next},
+		{Cursor: "not a cursor"},
+	} {
+		if _, err := store.ListUsers(ctx, f); !errors.Is(err, repository.ErrInvalidCursor) {
+			t.Errorf("Expected ErrInvalidCursor for %+v, got %v", f, err)
+		}
+	}
+}
+
+func testSearch(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	for _, name := range []string{"bali", "alicia", "ali", "alice", "zed", "margaret"} {
+		create(t, store, name)
+	}
+	other := &repository.User{Username: "support", Email: "help@ali.example.org", Password: "hash"}
+	if err := store.CreateUser(ctx, other); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	gone := create(t, store, "alina")
+	if err := store.EraseUser(ctx, gone.ID); err != nil {
+		t.Fatalf("Failed to erase: %v", err)
+	}
+
+	found := func(query string, limit int) []repository.UserMatch {
+		t.Helper()
+		matches, err := store.SearchUsers(ctx, query, limit)
+		if err != nil {
+			t.Fatalf("Failed to search %q: %v", query, err)
+		}
+		return matches
+	}
+	names := func(matches []repository.UserMatch) []string {
+		var users []repository.User
+		for _, m := range matches {
+			users = append(users, m.User)
+		}
+		return usernames(users)
+	}
+
+	matches := found("ALI", 0)
+	got := names(matches)
+	if len(got) != 5 || got[0] != "ali" || containsName(got, "zed") || containsName(got, "alina") {
+		t.Fatalf("Expected ali first, then alice, alicia, bali and support, got %v", got)
+	}
+	for _, name := range []string{"alice", "alicia", "bali", "support"} {
+		if !containsName(got, name) {
+			t.Errorf("Expected %s in %v", name, got)
+		}
+	}
+	for i := 1; i < len(matches); i++ {
+		if matches[i].Rank > matches[i-1].Rank {
+			t.Errorf("Expected the closest first, got %v", matches)
+		}
+	}
+	// starting with the query beats containing it
+	if i, j := slices.Index(got, "alice"), slices.Index(got, "bali"); i > j {
+		t.Errorf("Expected alice before bali, got %v", got)
+	}
+	want := []repository.Highlight{{Field: "username", Start: 0, End: 3}, {Field: "email", Start: 0, End: 3}}
+	if !reflect.DeepEqual(matches[0].Highlights, want) {
+		t.Errorf("Expected highlights %v, got %v", want, matches[0].Highlights)
+	}
+
+	if got := names(found("ali", 2)); len(got) != 2 || got[0] != "ali" {
+		t.Errorf("Expected 2 matches with the limit, got %v", got)
+	}
+	// a typo, found by similarity without highlights
+	matches = found("margret", 0)
+	if got := names(matches); len(got) != 1 || got[0] != "margaret" || len(matches[0].Highlights) != 0 {
+		t.Errorf("Expected margaret without highlights, got %v %v", got, matches)
+	}
+	// the beginning of words of the email
+	matches = found("help ali", 0)
+	if got := names(matches); len(got) != 1 || got[0] != "support" {
+		t.Errorf("Expected support by the words of its email, got %v", got)
+	}
+	if got := names(found("help@ali.example.org", 0)); len(got) != 1 || got[0] != "support" {
+		t.Errorf("Expected support by the whole address, got %v", got)
+	}
+	for _, query := range []string{"", "   ", "qqqq"} {
+		if got := found(query, 0); len(got) != 0 {
+			t.Errorf("Expected nothing for %q, got %v", query, names(got))
+		}
+	}
+}
+
+func testDisable(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	u := create(t, store, "alice")
+
+	if err := store.SetUserDisabled(ctx, u.ID, true); err != nil {
+		t.Fatalf("Failed to disable: %v", err)
+	}
+	got, _ := store.GetUser(ctx, u.ID)
+	if got.DisabledAt == nil {
+		t.Fatalf("Expected DisabledAt to be set")
+	}
+	// disabling again keeps the first time
+	disabledAt := *got.DisabledAt
+	if err := store.SetUserDisabled(ctx, u.ID, true); err != nil {
+		t.Fatalf("Failed to disable again: %v", err)
+	}
+	if got, _ = store.GetUser(ctx, u.ID); got.DisabledAt == nil || !got.DisabledAt.Equal(disabledAt) {
+		t.Errorf("Expected DisabledAt %v, got %v", disabledAt, got.DisabledAt)
+	}
+
+	if err := store.SetUserDisabled(ctx, u.ID, false); err != nil {
+		t.Fatalf("Failed to enable: %v", err)
+	}
+	if got, _ = store.GetUser(ctx, u.ID); got.DisabledAt != nil {
+		t.Errorf("Expected DisabledAt to be cleared")
+	}
+	if err := store.SetUserDisabled(ctx, u.ID+1000, true); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func testSetRoles(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	u := create(t, store, "alice")
+
+	for _, roles := range [][]string{{"admin"}, {repository.DefaultRole, "admin"}, {}} {
+		if err := store.SetUserRoles(ctx, u.ID, roles); err != nil {
+			t.Fatalf("Failed to set %v: %v", roles, err)
+		}
+		got, _ := store.GetUser(ctx, u.ID)
+		want := map[int][]string{0: {}, 1: {"admin"}, 2: {"admin", repository.DefaultRole}}[len(roles)]
+		if !reflect.DeepEqual(got.Roles, want) {
+			t.Errorf("Set %v: expected %v, got %v", roles, want, got.Roles)
+		}
+	}
+
+	if err := store.SetUserRoles(ctx, u.ID, []string{"admin", "no-such-role"}); !errors.Is(err, repository.ErrRoleNotFound) {
+		t.Errorf("Expected ErrRoleNotFound, got %v", err)
+	}
+	if err := store.SetUserRoles(ctx, u.ID+1000, []string{"admin"}); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func testDelete(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	u := create(t, store, "alice")
+	bob := create(t, store, "bob")
+
+	if err := store.DeleteUser(ctx, u.ID); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := store.GetUser(ctx, u.ID); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound after delete, got %v", err)
+	}
+	if err := store.DeleteUser(ctx, u.ID); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for the second delete, got %v", err)
+	}
+	if _, err := store.GetUser(ctx, bob.ID); err != nil {
+		t.Errorf("The other user must stay: %v", err)
+	}
+	// the name is free again
+	create(t, store, "alice")
+}
+
+func testVersion(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	u := create(t, store, "alice")
+	if u.Version != 1 {
+		t.Fatalf("Expected version 1, got %d", u.Version)
+	}
+
+	got, err := store.UpdateUser(ctx, u.ID, repository.UserPatch{Username: str("alicia"), Version: 1})
+	if err != nil {
+		t.Fatalf("Failed to update version 1: %v", err)
+	}
+	if got.Version != 2 {
+		t.Errorf("Expected version 2, got %d", got.Version)
+	}
+	// someone who read version 1 loses
+	if _, err := store.UpdateUser(ctx, u.ID, repository.UserPatch{Username: str("alison"), Version: 1}); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got %v", err)
+	}
+	if _, err := store.UpdateUser(ctx, u.ID, repository.UserPatch{Version: 1}); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict for an empty patch, got %v", err)
+	}
+	if _, err := store.UpdateUser(ctx, u.ID+1000, repository.UserPatch{Username: str("ghost"), Version: 1}); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if got, _ = store.GetUser(ctx, u.ID); got.Username != "alicia" || got.Version != 2 {
+		t.Errorf("The rejected updates must not change anything, got %+v", got)
+	}
+
+	// every other change counts too
+	if err := store.SetUserDisabled(ctx, u.ID, true); err != nil {
+		t.Fatalf("Failed to disable: %v", err)
+	}
+	if err := store.SetUserRoles(ctx, u.ID, []string{"admin"}); err != nil {
+		t.Fatalf("Failed to set roles: %v", err)
+	}
+	if got, _ = store.GetUser(ctx, u.ID); got.Version != 4 {
+		t.Errorf("Expected version 4, got %d", got.Version)
+	}
+	page, _ := store.ListUsers(ctx, repository.UserFilter{})
+	if users := page.Users; len(users) != 1 || users[0].Version != 4 {
+		t.Errorf("Expected ListUsers to return the version, got %+v", page.Users)
+	}
+	// without a version the last write wins
+	if got, err = store.UpdateUser(ctx, u.ID, repository.UserPatch{Email: str("a@example.com")}); err != nil || got.Version != 5 {
+		t.Errorf("Expected version 5, got %d %v", got.Version, err)
+	}
+}
+
+func testDeletion(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	u := create(t, store, "alice")
+
+	if err := store.CancelDeletion(ctx, u.ID); !errors.Is(err, repository.ErrNotPendingDeletion) {
+		t.Errorf("Expected ErrNotPendingDeletion, got %v", err)
+	}
+	if err := store.RequestDeletion(ctx, u.ID, u.ID); err != nil {
+		t.Fatalf("Failed to request the deletion: %v", err)
+	}
+	got, err := store.GetUser(ctx, u.ID)
+	if err != nil || got.Status != repository.StatusPendingDeletion || got.DeletionRequestedAt == nil {
+		t.Fatalf("Expected the user pending deletion, got %+v, %v", got, err)
+	}
+	// asking again keeps the first request
+	if err := store.RequestDeletion(ctx, u.ID, u.ID); err != nil {
+		t.Fatalf("Failed to request the deletion again: %v", err)
+	}
+	again, _ := store.GetUser(ctx, u.ID)
+	if again.DeletionRequestedAt == nil || !again.DeletionRequestedAt.Equal(*got.DeletionRequestedAt) {
+		t.Errorf("Expected the request time to stay %v, got %v", got.DeletionRequestedAt, again.DeletionRequestedAt)
+	}
+	if !again.SelfDeletion() {
+		t.Errorf("Expected the deletion requested by alice, got %v", again.DeletionRequestedBy)
+	}
+	// an admin asking takes it over, alice asking again doesn't take it back
+	admin := create(t, store, "admin")
+	if err := store.RequestDeletion(ctx, u.ID, admin.ID); err != nil {
+		t.Fatalf("Failed to request the deletion as admin: %v", err)
+	}
+	store.RequestDeletion(ctx, u.ID, u.ID)
+	again, _ = store.GetUser(ctx, u.ID)
+	if again.SelfDeletion() || again.DeletionRequestedBy == nil || *again.DeletionRequestedBy != admin.ID {
+		t.Errorf("Expected the deletion requested by the admin, got %v", again.DeletionRequestedBy)
+	}
+
+	// disabling doesn't cancel it, cancelling keeps the account disabled
+	if err := store.SetUserDisabled(ctx, u.ID, true); err != nil {
+		t.Fatalf("Failed to disable: %v", err)
+	}
+	if got, _ := store.GetUser(ctx, u.ID); got.Status != repository.StatusPendingDeletion {
+		t.Errorf("Expected the user still pending deletion, got %s", got.Status)
+	}
+	if err := store.CancelDeletion(ctx, u.ID); err != nil {
+		t.Fatalf("Failed to cancel the deletion: %v", err)
+	}
+	got, _ = store.GetUser(ctx, u.ID)
+	if got.Status != repository.StatusDisabled || got.DeletionRequestedAt != nil || got.DeletionRequestedBy != nil {
+		t.Errorf("Expected the user disabled again, got %s", got.Status)
+	}
+	store.SetUserDisabled(ctx, u.ID, false)
+	if got, _ := store.GetUser(ctx, u.ID); got.Status != repository.StatusActive {
+		t.Errorf("Expected the user active, got %s", got.Status)
+	}
+
+	if err := store.RequestDeletion(ctx, u.ID+100, u.ID); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if err := store.CancelDeletion(ctx, u.ID+100); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func testPurge(t *testing.T, store repository.UserStore) {
+	ctx := context.Background()
+	alice := create(t, store, "alice")
+	bob := create(t, store, "bob")
+	carol := create(t, store, "carol")
+	create(t, store, "dave")
+	for _, u := range []*repository.User{alice, bob, carol} {
+		if err := store.RequestDeletion(ctx, u.ID, u.ID); err != nil {
+			t.Fatalf("Failed to request the deletion: %v", err)
+		}
+	}
+	if err := store.CancelDeletion(ctx, carol.ID); err != nil {
+		t.Fatalf("Failed to cancel the deletion: %v", err)
+	}
+
+	ids, err := store.PurgeUsers(ctx, time.Hour, false)
+	if err != nil || len(ids) != 0 {
+		t.Fatalf("Expected nothing purged within the grace period, got %v, %v", ids, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	ids, err = store.PurgeUsers(ctx, 0, true)
+	if err != nil || !reflect.DeepEqual(ids, []int{alice.ID, bob.ID}) {
+		t.Fatalf("Expected alice and bob purged, got %v, %v", ids, err)
+	}
+	if _, err := store.GetUser(ctx, alice.ID); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for a purged user, got %v", err)
+	}
+	if exists, _ := store.UserExists(ctx, "alice", "alice@example.com"); exists {
+		t.Errorf("Expected the name of an anonymized user to be free")
+	}
+	page, _ := store.ListUsers(ctx, repository.UserFilter{})
+	if got := usernames(page.Users); !reflect.DeepEqual(got, []string{"carol", "dave"}) {
+		t.Errorf("Expected the deleted users not listed, got %v", got)
+	}
+	page, _ = store.ListUsers(ctx, repository.UserFilter{Status: repository.StatusDeleted})
+	if deleted := page.Users; len(deleted) != 2 || deleted[0].Username == "alice" || deleted[0].Email == alice.Email || len(deleted[0].Roles) != 0 {
+		t.Errorf("Expected the anonymized users, got %+v", deleted)
+	}
+	if ids, _ := store.PurgeUsers(ctx, 0, true); len(ids) != 0 {
+		t.Errorf("Expected nothing left to purge, got %v", ids)
+	}
+
+	if err := store.RequestDeletion(ctx, carol.ID, carol.ID); err != nil {
+		t.Fatalf("Failed to request the deletion: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	ids, err = store.PurgeUsers(ctx, 0, false)
+	if err != nil || !reflect.DeepEqual(ids, []int{carol.ID}) {
+		t.Fatalf("Expected carol purged, got %v, %v", ids, err)
+	}
+	if err := store.DeleteUser(ctx, carol.ID); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected carol to be gone, got %v", err)
+	}
+	create(t, store, "carol")
+}
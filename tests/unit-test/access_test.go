@@ -0,0 +1,52 @@
+package unittest
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"context"
+	"testing"
+)
+
+// Permission matching tests
+func TestPermissionAllows(t *testing.T) {
+	cases := []struct {
+		perm             repository.Permission
+		resource, action string
+		want             bool
+	}{
+		{repository.Permission{Resource: "*", Action: "*"}, "users", "delete", true},
+		{repository.Permission{Resource: "profile", Action: "*"}, "profile", "update", true},
+		{repository.Permission{Resource: "profile", Action: "read"}, "profile", "update", false},
+		{repository.Permission{Resource: "profile", Action: "read"}, "users", "read", false},
+		{repository.Permission{Resource: "*", Action: "read"}, "users", "read", true},
+	}
+	for _, c := range cases {
+		if got := c.perm.Allows(c.resource, c.action); got != c.want {
+			t.Errorf("%s:%s allows %s:%s = %v, want %v",
+				c.perm.Resource, c.perm.Action, c.resource, c.action, got, c.want)
+		}
+	}
+}
+
+func TestCheckRequiresResourceAndAction(t *testing.T) {
+	checker := access.NewChecker(repository.NewRepository(nil), nil)
+
+	d := checker.Check(context.Background(), access.Principal{UserID: 1, Roles: []string{"user"}}, "", "read")
+	if d.Allowed {
+		t.Errorf("Check without resource should be denied")
+	}
+	if d.Reason == "" {
+		t.Errorf("Denied decision should have a reason")
+	}
+}
+
+func TestServicePrincipalsByOrigin(t *testing.T) {
+	key := access.APIKeyPrincipal("billing", []string{"admin"})
+	cert := access.CertificatePrincipal("billing", []string{"user"})
+	if key.Service == cert.Service || key.String() == cert.String() {
+		t.Errorf("Expected an API key and a certificate of the same name to differ, got %q and %q", key, cert)
+	}
+	if key.Username != "billing" || cert.Username != "billing" {
+		t.Errorf("Expected the bare names as usernames, got %q and %q", key.Username, cert.Username)
+	}
+}
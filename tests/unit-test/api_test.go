@@ -0,0 +1,202 @@
+package unittest
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/controller"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// JSON API checks that happen before the database is touched
+
+func newAPIRouter() *mux.Router {
+	repo := repository.NewRepository(nil)
+	users := repository.NewMemoryUserStore()
+	authService := auth.NewService(users)
+	checker := access.NewChecker(repo, nil)
+	app := controller.NewApp(context.Background(), nil, users, repository.NewMemoryEventLog(), authService, checker, nil, access.NewGate(authService, checker, nil))
+	r := mux.NewRouter()
+	app.Routes(r)
+	return r
+}
+
+type envelope struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func apiCall(t *testing.T, r http.Handler, method, path, body string, header http.Header) (int, envelope) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	var env envelope
+	if rec.Code >= 400 {
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: expected a JSON error, got %q", method, path, ct)
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&env); err != nil {
+			t.Errorf("%s %s: invalid error envelope: %v", method, path, err)
+		}
+	}
+	return rec.Code, env
+}
+
+func TestAPIErrors(t *testing.T) {
+	r := newAPIRouter()
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		header       http.Header
+		status       int
+		code         string
+		message      string
+	}{
+		{"not acceptable", "GET", "/api/v1/me", "", http.Header{"Accept": {"text/html"}}, http.StatusNotAcceptable, "not_acceptable", ""},
+		{"form body", "POST", "/api/v1/login", "username=a", http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}, http.StatusUnsupportedMediaType, "unsupported_media_type", ""},
+		{"no token", "GET", "/api/v1/me", "", nil, http.StatusUnauthorized, "unauthenticated", ""},
+		{"bad token", "DELETE", "/api/v1/me", "", http.Header{"Authorization": {"Bearer nope"}}, http.StatusUnauthorized, "unauthenticated", ""},
+		{"admin api without token", "GET", "/api/v1/admin/roles", "", nil, http.StatusUnauthorized, "unauthenticated", ""},
+		{"invalid json", "POST", "/api/v1/signup", "{", nil, http.StatusBadRequest, "invalid_argument", ""},
+		{"unknown field", "POST", "/api/v1/signup", `{"login": "x"}`, nil, http.StatusBadRequest, "invalid_argument", ""},
+		{"missing fields", "POST", "/api/v1/signup", `{"username": "someone"}`, nil, http.StatusBadRequest, "invalid_argument", "Not all fields are filled in"},
+		{"weak password", "POST", "/api/v1/signup", `{"username": "someone", "email": "a@b.c", "password": "12345"}`, nil, http.StatusBadRequest, "invalid_argument", "The password should not contain only numbers or letters"},
+		{"password mismatch", "POST", "/api/v1/signup", `{"username": "someone", "email": "a@b.c", "password": "abc123", "repassword": "abc124"}`, nil, http.StatusBadRequest, "invalid_argument", "Password mismatch"},
+		{"short username", "POST", "/api/v1/signup", `{"username": "abc", "email": "a@b.c", "password": "abc123"}`, nil, http.StatusBadRequest, "invalid_argument", "Minimum username length - 4 characters"},
+		{"invalid email", "POST", "/api/v1/signup", `{"username": "someone", "email": "Someone <a@b.c>", "password": "abc123"}`, nil, http.StatusBadRequest, "invalid_argument", "Invalid email address"},
+		{"login without password", "POST", "/api/v1/login", `{"username": "someone"}`, nil, http.StatusBadRequest, "invalid_argument", "You must provide a username and password"},
+		{"admin users without token", "GET", "/api/v1/admin/users", "", nil, http.StatusUnauthorized, "unauthenticated", ""},
+		{"admin users unknown sort", "GET", "/api/v1/admin/users?sort=password", "", nil, http.StatusBadRequest, "invalid_argument", ""},
+		{"admin users page too large", "GET", "/api/v1/admin/users?limit=1000", "", nil, http.StatusBadRequest, "invalid_argument", ""},
+		{"admin search without query", "GET", "/api/v1/admin/users/search", "", nil, http.StatusBadRequest, "invalid_argument", ""},
+		{"admin search too many", "GET", "/api/v1/admin/users/search?q=ali&limit=500", "", nil, http.StatusBadRequest, "invalid_argument", ""},
+		{"admin search without token", "GET", "/api/v1/admin/users/search?q=ali", "", nil, http.StatusUnauthorized, "unauthenticated", ""},
+		{"admin create user unknown field", "POST", "/api/v1/admin/users", `{"role": "admin"}`, nil, http.StatusBadRequest, "invalid_argument", ""},
+		{"admin disable without token", "POST", "/api/v1/admin/users/7/disable", "", nil, http.StatusUnauthorized, "unauthenticated", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, env := apiCall(t, r, tt.method, tt.path, tt.body, tt.header)
+			if status != tt.status || env.Error.Code != tt.code {
+				t.Errorf("got %d %q, want %d %q", status, env.Error.Code, tt.status, tt.code)
+			}
+			if tt.message != "" && env.Error.Message != tt.message {
+				t.Errorf("got message %q, want %q", env.Error.Message, tt.message)
+			}
+		})
+	}
+}
+
+func TestMeETag(t *testing.T) {
+	repo := repository.NewRepository(nil)
+	users := repository.NewMemoryUserStore()
+	authService := auth.NewService(users)
+	checker := access.NewChecker(repo, nil)
+	app := controller.NewApp(context.Background(), nil, users, repository.NewMemoryEventLog(), authService, checker, nil, access.NewGate(authService, checker, nil))
+	r := mux.NewRouter()
+	app.Routes(r)
+
+	user, err := repository.NewUser("alice", "alice@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if err := users.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	session, err := authService.Login(context.Background(), "alice", "secret123", false)
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/me", nil)
+		req.Header.Set("Authorization", "Bearer "+session.Token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := get()
+	var body struct {
+		Version int `json:"version"`
+	}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` || body.Version != 1 {
+		t.Fatalf("Expected ETag \"1\" and version 1, got %d %q %d", rec.Code, rec.Header().Get("ETag"), body.Version)
+	}
+
+	if _, err := users.UpdateUser(context.Background(), user.ID, repository.UserPatch{Email: &user.Email}); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if rec := get(); rec.Header().Get("ETag") != `"2"` {
+		t.Errorf("Expected the ETag to follow the version, got %q", rec.Header().Get("ETag"))
+	}
+}
+
+// racingStore is a signup that lost the race: UserExists saw nothing, CreateUser finds the user
+type racingStore struct {
+	*repository.MemoryUserStore
+}
+
+func (racingStore) UserExists(ctx context.Context, username, email string) (bool, error) {
+	return false, nil
+}
+
+func TestAPISignupRace(t *testing.T) {
+	users := racingStore{repository.NewMemoryUserStore()}
+	authService := auth.NewService(users)
+	checker := access.NewChecker(repository.NewRepository(nil), nil)
+	app := controller.NewApp(context.Background(), nil, users, repository.NewMemoryEventLog(), authService, checker, nil, access.NewGate(authService, checker, nil))
+	r := mux.NewRouter()
+	app.Routes(r)
+
+	body := `{"username": "someone", "email": "someone@example.com", "password": "abc123"}`
+	if status, _ := apiCall(t, r, "POST", "/api/v1/signup", body, nil); status != http.StatusCreated {
+		t.Fatalf("Expected the first signup created, got %d", status)
+	}
+	status, env := apiCall(t, r, "POST", "/api/v1/signup", body, nil)
+	if status != http.StatusConflict || env.Error.Code != "conflict" {
+		t.Errorf("Expected 409 conflict for the second signup, got %d %s", status, env.Error.Code)
+	}
+}
+
+func TestAPISignupRecordsEvent(t *testing.T) {
+	users := repository.NewMemoryUserStore()
+	events := repository.NewMemoryEventLog()
+	authService := auth.NewService(users)
+	checker := access.NewChecker(repository.NewRepository(nil), nil)
+	app := controller.NewApp(context.Background(), nil, users, events, authService, checker, nil, access.NewGate(authService, checker, nil))
+	r := mux.NewRouter()
+	app.Routes(r)
+
+	body := `{"username": "someone", "email": "someone@example.com", "password": "abc123"}`
+	if status, _ := apiCall(t, r, "POST", "/api/v1/signup", body, nil); status != http.StatusCreated {
+		t.Fatalf("Expected the signup created, got %d", status)
+	}
+	user, err := users.GetUserByUsername(context.Background(), "someone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, _ := events.UserEvents(context.Background(), user.ID)
+	if len(recorded) != 1 || recorded[0].Event != "signup" {
+		t.Errorf("Expected the signup event, got %+v", recorded)
+	}
+}
@@ -0,0 +1,63 @@
+package unittest
+
+import (
+	"AuthDB/cmd/app/repository"
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// nothing listens on port 1, the connections are refused right away
+const unreachableDB = "postgres://postgres@127.0.0.1:1/none?sslmode=disable&connect_timeout=1"
+
+func lazyPool(t *testing.T, url string) *pgxpool.Pool {
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %v", url, err)
+	}
+	cfg.LazyConnect = true
+	pool, err := pgxpool.ConnectConfig(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Failed to make the pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestClusterWithoutReplicas(t *testing.T) {
+	primary := lazyPool(t, unreachableDB)
+	cluster, err := repository.NewCluster(context.Background(), primary)
+	if err != nil {
+		t.Fatalf("Failed to make the cluster: %v", err)
+	}
+	if reader := cluster.Reader(context.Background()); reader != primary {
+		t.Errorf("Expected the reads on the primary, got %T", reader)
+	}
+}
+
+func TestClusterUnreachableReplica(t *testing.T) {
+	ctx := context.Background()
+	primary := lazyPool(t, unreachableDB)
+	// an unreachable replica doesn't stop the start
+	cluster, err := repository.NewCluster(ctx, primary, unreachableDB)
+	if err != nil {
+		t.Fatalf("Failed to make the cluster: %v", err)
+	}
+	defer cluster.Close()
+
+	// not checked yet
+	if reader := cluster.Reader(ctx); reader != primary || cluster.Healthy() != 0 {
+		t.Errorf("Expected the reads on the primary before the first check, got %T", reader)
+	}
+	cluster.Check(ctx)
+	if reader := cluster.Reader(ctx); reader != primary || cluster.Healthy() != 0 {
+		t.Errorf("Expected the reads on the primary, got %T with %d healthy replicas", reader, cluster.Healthy())
+	}
+}
+
+func TestClusterBadReplicaURL(t *testing.T) {
+	if _, err := repository.NewCluster(context.Background(), lazyPool(t, unreachableDB), "postgres://%zz"); err == nil {
+		t.Error("Expected an error for a replica URL that doesn't parse")
+	}
+}
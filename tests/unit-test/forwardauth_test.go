@@ -0,0 +1,113 @@
+package unittest
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/controller"
+	"AuthDB/cmd/app/controller/helper"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// nginx auth_request endpoint and the login redirect
+
+func TestSafeRedirect(t *testing.T) {
+	allowed := []string{"reports.example.com"}
+	tests := []struct {
+		target, want string
+	}{
+		{"", "/"},
+		{"/orders?id=1", "/orders?id=1"},
+		{"https://reports.example.com/x", "https://reports.example.com/x"},
+		{"https://REPORTS.example.com/x", "https://REPORTS.example.com/x"},
+		{"//evil.com", "/"},
+		{"/\\evil.com", "/"},
+		{"https://evil.com/", "/"},
+		{"https://reports.example.com.evil.com/", "/"},
+		{"https://user@reports.example.com/", "/"},
+		{"javascript:alert(1)", "/"},
+		{"orders", "/"},
+	}
+	for _, tt := range tests {
+		if got := helper.SafeRedirect(tt.target, allowed); got != tt.want {
+			t.Errorf("SafeRedirect(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyAuth(t *testing.T) {
+	repo := repository.NewRepository(nil)
+	users := repository.NewMemoryUserStore()
+	authService := auth.NewService(users)
+	checker := access.NewChecker(repo, nil)
+	gate := access.NewGate(authService, checker, access.Routes{
+		{Prefix: "/public/", Public: true},
+		{Prefix: "/reports/"},
+	})
+	app := controller.NewApp(context.Background(), nil, users, repository.NewMemoryEventLog(), authService, checker, nil, gate)
+
+	verify := func(uri string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/auth/verify", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("X-Original-URI", uri)
+		rec := httptest.NewRecorder()
+		app.VerifyAuth(rec, req)
+		return rec
+	}
+
+	if rec := verify("/public/app.css", nil); rec.Code != http.StatusOK || rec.Header().Get(access.HeaderUser) != "" {
+		t.Errorf("Public route: got %d, user %q", rec.Code, rec.Header().Get(access.HeaderUser))
+	}
+	if rec := verify("/public/app.css", http.Header{"Cookie": {"token=expired"}}); rec.Code != http.StatusOK || rec.Header().Get(access.HeaderUser) != "" {
+		t.Errorf("Invalid cookie on public route: got %d, user %q", rec.Code, rec.Header().Get(access.HeaderUser))
+	}
+	if rec := verify("/reports/2026", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("No session: expected 401, got %d", rec.Code)
+	}
+	if rec := verify("/reports/2026", http.Header{"Cookie": {"token=invalid"}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Invalid cookie: expected 401, got %d", rec.Code)
+	}
+	if rec := verify("/reports/2026", http.Header{"Authorization": {"Bearer invalid"}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Invalid bearer token: expected 401, got %d", rec.Code)
+	}
+	// nginx and the upstream resolve these to /reports/2026, the public route must not cover them
+	for _, uri := range []string{"/public/../reports/2026", "/public/%2e%2e/reports/2026", "/public/..%2Freports/2026", "/public/%2E./reports"} {
+		if rec := verify(uri, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", uri, rec.Code)
+		}
+	}
+	if rec := verify("/public//./app.css?v=2", nil); rec.Code != http.StatusOK {
+		t.Errorf("Cleaned public path: expected 200, got %d", rec.Code)
+	}
+	if rec := verify("/reports/./2026", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Cleaned protected path: expected 401, got %d", rec.Code)
+	}
+	// nginx sends the path it routes on, decoded
+	if rec := verify("/public/app.css", http.Header{"X-Original-Path": {"/reports/2026"}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("X-Original-Path: expected 401, got %d", rec.Code)
+	}
+	if rec := verify("/", http.Header{"X-Original-Path": {"/public/a?b.css"}}); rec.Code != http.StatusOK {
+		t.Errorf("Decoded ? in X-Original-Path: expected 200, got %d", rec.Code)
+	}
+	if rec := verify("/unknown", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("No route: expected 403, got %d", rec.Code)
+	}
+}
+
+func TestLoginRedirect(t *testing.T) {
+	users := repository.NewMemoryUserStore()
+	app := controller.NewApp(context.Background(), nil, users, repository.NewMemoryEventLog(), auth.NewService(users), nil, nil, nil)
+
+	req := httptest.NewRequest("GET", "/auth/login", nil)
+	req.Header.Set("X-Original-URI", "/reports/2026?from=jan&to=mar")
+	rec := httptest.NewRecorder()
+	app.LoginRedirect(rec, req)
+	if want := "/login?next=%2Freports%2F2026%3Ffrom%3Djan%26to%3Dmar"; rec.Code != http.StatusFound || rec.Header().Get("Location") != want {
+		t.Errorf("Expected 302 to %s, got %d to %s", want, rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,88 @@
+package unittest
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"AuthDB/internal/api/interceptor"
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC interceptor tests (no database needed)
+
+func newAuthInterceptor() grpc.UnaryServerInterceptor {
+	repo := repository.NewRepository(nil)
+	rules := interceptor.Rules{
+		"/test.Service/Public":  {Public: true},
+		"/test.Service/Private": {},
+		"/test.Admin/*":         {Resource: "admin", Action: "manage"},
+	}
+	return interceptor.NewAuth(auth.NewService(repository.NewMemoryUserStore()), repo, access.NewChecker(repo, nil), rules).Unary()
+}
+
+func call(i grpc.UnaryServerInterceptor, ctx context.Context, method string) error {
+	_, err := i(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil })
+	return err
+}
+
+func TestAuthInterceptorRules(t *testing.T) {
+	i := newAuthInterceptor()
+	ctx := context.Background()
+
+	if err := call(i, ctx, "/test.Service/Public"); err != nil {
+		t.Errorf("Public method without credentials: %v", err)
+	}
+	if code := status.Code(call(i, ctx, "/test.Service/Private")); code != codes.Unauthenticated {
+		t.Errorf("Private method without credentials: expected Unauthenticated, got %s", code)
+	}
+	if code := status.Code(call(i, ctx, "/test.Admin/Anything")); code != codes.Unauthenticated {
+		t.Errorf("Wildcard rule without credentials: expected Unauthenticated, got %s", code)
+	}
+	if code := status.Code(call(i, ctx, "/test.Service/Unknown")); code != codes.PermissionDenied {
+		t.Errorf("Method without rule: expected PermissionDenied, got %s", code)
+	}
+
+	bad := metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer not-a-token"))
+	if code := status.Code(call(i, bad, "/test.Service/Private")); code != codes.Unauthenticated {
+		t.Errorf("Invalid token: expected Unauthenticated, got %s", code)
+	}
+	// public methods take the caller as anonymous
+	if err := call(i, bad, "/test.Service/Public"); err != nil {
+		t.Errorf("Invalid token on public method: %v", err)
+	}
+	scheme := metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Basic dXNlcg=="))
+	if err := call(i, scheme, "/test.Service/Public"); err != nil {
+		t.Errorf("Unsupported scheme on public method: %v", err)
+	}
+}
+
+func TestRecoveryInterceptor(t *testing.T) {
+	recovery, _ := interceptor.Recovery()
+	_, err := recovery(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Panic"},
+		func(ctx context.Context, req interface{}) (interface{}, error) { panic("boom") })
+	if status.Code(err) != codes.Internal {
+		t.Errorf("Expected Internal after panic, got %v", err)
+	}
+}
+
+func TestDeadlineInterceptor(t *testing.T) {
+	deadline, _ := interceptor.Deadline(time.Second)
+	_, err := deadline(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Slow"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("Expected the default deadline to be set")
+			}
+			return nil, nil
+		})
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package unittest
+
+import (
+	"AuthDB/migrations"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	for _, set := range migrations.Sets {
+		embedded, err := fs.Glob(migrations.FS, set.Name+"/*.sql")
+		if err != nil {
+			t.Fatalf("Failed to list the embedded migrations: %v", err)
+		}
+		onDisk, err := filepath.Glob(filepath.Join("../../migrations", set.Name, "*.sql"))
+		if err != nil {
+			t.Fatalf("Failed to list the migrations: %v", err)
+		}
+		for i := range onDisk {
+			onDisk[i] = set.Name + "/" + filepath.Base(onDisk[i])
+		}
+		if len(embedded) == 0 || !reflect.DeepEqual(embedded, onDisk) {
+			t.Fatalf("Expected %v embedded, got %v", onDisk, embedded)
+		}
+
+		// migrate down and redo need the way back
+		for _, name := range embedded {
+			data, _ := fs.ReadFile(migrations.FS, name)
+			if !strings.Contains(string(data), "-- +goose Down") {
+				t.Errorf("%s has no Down section", name)
+			}
+		}
+	}
+}
+
+func TestMigrationSets(t *testing.T) {
+	admin, err := migrations.SetByName("admin")
+	if err != nil || admin.Table == migrations.App.Table {
+		t.Errorf("Expected the admin set in its own version table, got %+v (%v)", admin, err)
+	}
+	if _, err := migrations.SetByName("goadmin"); err == nil {
+		t.Errorf("Expected an error for an unknown set")
+	}
+
+	// GoAdmin's tables are not created by the app set anymore, but by the released
+	// createmaindb the admin set upgrades
+	appFiles, _ := fs.Glob(migrations.FS, "app/*.sql")
+	for _, name := range appFiles {
+		if name == "app/20240807182800_createmaindb.sql" {
+			continue
+		}
+		data, _ := fs.ReadFile(migrations.FS, name)
+		if strings.Contains(string(data), "goadmin_") {
+			t.Errorf("%s touches the GoAdmin tables", name)
+		}
+	}
+}
+
+func TestDriftError(t *testing.T) {
+	drift := &migrations.Drift{
+		Pending: map[string][]string{"admin": {"20261019210200_seedgoadmin.sql"}},
+		Missing: []string{"goadmin_users.name"},
+	}
+	msg := drift.Error()
+	for _, want := range []string{"admin: 1 pending migrations: 20261019210200_seedgoadmin.sql", "missing: goadmin_users.name", "migrate up"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Expected %q in %q", want, msg)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package unittest
+
+import (
+	"AuthDB/internal/openapi"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// The OpenAPI document is the source of truth for the HTTP API
+
+// "/roles/{roleID:[0-9]+}" -> "/roles/{roleID}"
+var muxVariable = regexp.MustCompile(`\{(\w+):[^}]+\}`)
+
+func TestEveryRouteIsInTheSpec(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("Invalid OpenAPI document: %v", err)
+	}
+
+	err = newAPIRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			// a subrouter, its routes are walked on their own
+			return nil
+		}
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		path := muxVariable.ReplaceAllString(tmpl, "{$1}")
+
+		item := doc.Paths.Value(path)
+		if item == nil && strings.HasSuffix(path, "/") {
+			// PathPrefix routes like /public/ serve everything below them
+			for p, candidate := range doc.Paths.Map() {
+				if strings.HasPrefix(p, path) {
+					item = candidate
+					break
+				}
+			}
+		}
+		if item == nil {
+			t.Errorf("Route %s is missing from internal/openapi/openapi.yaml", path)
+			return nil
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			// any method, at least one has to be described
+			if len(item.Operations()) == 0 {
+				t.Errorf("Route %s has no operation in the spec", path)
+			}
+			return nil
+		}
+		for _, method := range methods {
+			if item.GetOperation(method) == nil {
+				t.Errorf("Route %s %s is missing from internal/openapi/openapi.yaml", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPIEndpoint(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newAPIRouter().ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var doc struct {
+		OpenAPI string                 `json:"openapi"`
+		Paths   map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if doc.OpenAPI == "" || doc.Paths["/api/v1/me"] == nil {
+		t.Errorf("Unexpected document: %+v", doc)
+	}
+}
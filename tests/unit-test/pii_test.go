@@ -0,0 +1,104 @@
+package unittest
+
+import (
+	"AuthDB/internal/pii"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func newKeyFile(t *testing.T, ids ...string) pii.KeyFile {
+	t.Helper()
+	kf := pii.KeyFile{Current: ids[len(ids)-1], Keys: map[string]string{}}
+	for _, id := range ids {
+		key, err := pii.GenerateKey()
+		if err != nil {
+			t.Fatalf("Failed to generate a key: %v", err)
+		}
+		kf.Keys[id] = key
+	}
+	kf.IndexKey, _ = pii.GenerateKey()
+	return kf
+}
+
+func TestPIIEncryptDecrypt(t *testing.T) {
+	c, err := pii.NewCipher(newKeyFile(t, "k1"))
+	if err != nil {
+		t.Fatalf("Failed to load the keys: %v", err)
+	}
+	key, err := c.NewDataKey()
+	if err != nil {
+		t.Fatalf("Failed to create a data key: %v", err)
+	}
+	enc, err := key.Encrypt("email", "alice@example.com")
+	if err != nil || !pii.IsEncrypted(enc) || bytes.Contains([]byte(enc), []byte("alice")) {
+		t.Fatalf("Expected an encrypted value, got %q, %v", enc, err)
+	}
+	if again, _ := key.Encrypt("email", "alice@example.com"); again == enc {
+		t.Errorf("Expected a new nonce for every value")
+	}
+
+	unwrapped, err := c.DataKey(key.KeyID, key.Wrapped)
+	if err != nil {
+		t.Fatalf("Failed to unwrap: %v", err)
+	}
+	if got, err := unwrapped.Decrypt("email", enc); err != nil || got != "alice@example.com" {
+		t.Errorf("Expected the email back, got %q, %v", got, err)
+	}
+	if _, err := unwrapped.Decrypt("phone", enc); err == nil {
+		t.Errorf("A value must not decrypt as another column")
+	}
+	if got, _ := unwrapped.Decrypt("email", "plain@example.com"); got != "plain@example.com" {
+		t.Errorf("Expected plaintext to stay as it is, got %q", got)
+	}
+
+	other, _ := c.NewDataKey()
+	if _, err := other.Decrypt("email", enc); err == nil {
+		t.Errorf("The data key of another record must not decrypt it")
+	}
+}
+
+func TestPIIRotation(t *testing.T) {
+	kf := newKeyFile(t, "k1")
+	old, _ := pii.NewCipher(kf)
+	key, _ := old.NewDataKey()
+
+	k2, _ := pii.GenerateKey()
+	kf.Keys["k2"], kf.Current = k2, "k2"
+	rotated, err := pii.NewCipher(kf)
+	if err != nil {
+		t.Fatalf("Failed to load the keys: %v", err)
+	}
+	if _, err := rotated.DataKey(key.KeyID, key.Wrapped); err != nil {
+		t.Errorf("The old key must still unwrap its data keys: %v", err)
+	}
+	if fresh, _ := rotated.NewDataKey(); fresh.KeyID != "k2" {
+		t.Errorf("Expected new data keys wrapped with k2, got %s", fresh.KeyID)
+	}
+	if !bytes.Equal(old.Index("Alice@Example.com "), rotated.Index("alice@example.com")) {
+		t.Errorf("The blind index must not change with the rotation or the spelling")
+	}
+
+	delete(kf.Keys, "k1")
+	withoutOld, _ := pii.NewCipher(kf)
+	if _, err := withoutOld.DataKey(key.KeyID, key.Wrapped); !errors.Is(err, pii.ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+	// a wrapped key can't claim another key id
+	if _, err := rotated.DataKey("k2", key.Wrapped); err == nil {
+		t.Errorf("Expected the data key of k1 not to unwrap with k2")
+	}
+}
+
+func TestPIIInvalidKeyFile(t *testing.T) {
+	kf := newKeyFile(t, "k1")
+	kf.Current = "k9"
+	if _, err := pii.NewCipher(kf); !errors.Is(err, pii.ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey for a missing current key, got %v", err)
+	}
+	kf = newKeyFile(t, "k1")
+	kf.Keys["k1"] = "c2hvcnQ="
+	if _, err := pii.NewCipher(kf); err == nil {
+		t.Errorf("Expected a short key to be rejected")
+	}
+}
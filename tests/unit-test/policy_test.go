@@ -0,0 +1,79 @@
+package unittest
+
+import (
+	"AuthDB/internal/policy"
+	"testing"
+)
+
+// Policy tests
+
+// The *_test.json cases shipped with the policies must pass
+func TestShippedPolicies(t *testing.T) {
+	dir := "../../configs/policies"
+	policies, err := policy.Load(dir)
+	if err != nil {
+		t.Fatalf("Failed to load policies: %v", err)
+	}
+	cases, err := policy.LoadTests(dir)
+	if err != nil {
+		t.Fatalf("Failed to load policy tests: %v", err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("No policy tests found in %s", dir)
+	}
+
+	engine := policy.NewEngine(policies, policy.Enforce)
+	for _, f := range engine.RunTests(cases) {
+		t.Errorf("%s", f)
+	}
+}
+
+func TestDenyOverridesAllow(t *testing.T) {
+	engine := policy.NewEngine([]policy.Policy{
+		{ID: "allow-all", Effect: policy.Allow, Resources: []string{"*"}, Actions: []string{"*"}},
+		{ID: "no-delete", Effect: policy.Deny, Resources: []string{"users"}, Actions: []string{"delete"}},
+	}, policy.Enforce)
+
+	res := engine.Evaluate(policy.Request{Resource: "users", Action: "delete"})
+	if res.Effect != policy.Deny || res.PolicyID != "no-delete" {
+		t.Errorf("Expected deny by no-delete, got %s by %s", res.Effect, res.PolicyID)
+	}
+	if len(res.Trace) != 2 {
+		t.Errorf("Expected a trace entry per policy, got %d", len(res.Trace))
+	}
+
+	res = engine.Evaluate(policy.Request{Resource: "users", Action: "read"})
+	if res.Effect != policy.Allow {
+		t.Errorf("Expected allow, got %q", res.Effect)
+	}
+}
+
+func TestMissingAttributeDoesNotApply(t *testing.T) {
+	engine := policy.NewEngine([]policy.Policy{{
+		ID: "tenant", Effect: policy.Allow, Resources: []string{"users"}, Actions: []string{"read"},
+		Conditions: []policy.Condition{{Attr: "resource.tenant_id", Op: "eq", ValueAttr: "subject.tenant_id"}},
+	}}, policy.Enforce)
+
+	res := engine.Evaluate(policy.Request{
+		Subject:  policy.Subject{ID: 1, Attributes: map[string]string{"tenant_id": "acme"}},
+		Resource: "users", Action: "read",
+	})
+	if res.Effect != "" {
+		t.Errorf("Policy should not apply without resource.tenant_id, got %q", res.Effect)
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	invalid := []policy.Policy{
+		{Effect: policy.Allow, Resources: []string{"a"}, Actions: []string{"b"}},
+		{ID: "x", Effect: "maybe", Resources: []string{"a"}, Actions: []string{"b"}},
+		{ID: "x", Effect: policy.Allow, Actions: []string{"b"}},
+		{ID: "x", Effect: policy.Allow, Resources: []string{"a"}, Actions: []string{"b"},
+			Conditions: []policy.Condition{{Attr: "subject.id", Op: "like"}}},
+	}
+	for i, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Policy %d should be invalid", i)
+		}
+	}
+}
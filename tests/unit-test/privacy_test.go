@@ -0,0 +1,101 @@
+package unittest
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPrivacyExportAndErase(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserStore()
+	events := repository.NewMemoryEventLog()
+	authService := auth.NewService(users)
+	authService.RecordEvents(events)
+	privacy := access.NewPrivacy(users, nil, events, authService)
+
+	alice, err := repository.NewUser("alice", "alice@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if err := users.CreateUser(ctx, alice); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	admin := 99
+	events.RecordEvent(ctx, &repository.UserEvent{UserID: alice.ID, ActorID: &admin, Event: "admin_user_roles_set"})
+	authService.Login(ctx, "alice", "wrong", false)
+	if _, err := authService.Login(ctx, "alice", "secret123", false); err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	export, err := privacy.Export(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if export.Profile.Email != "alice@example.com" || len(export.Sessions) != 1 {
+		t.Errorf("Expected the profile and one session, got %+v", export)
+	}
+	if names := eventNames(export.LoginHistory); len(names) != 2 || names[0] != "login_failed" || names[1] != "login" {
+		t.Errorf("Expected login_failed and login in the login history, got %v", names)
+	}
+	if names := eventNames(export.Audit); len(names) != 1 || names[0] != "admin_user_roles_set" {
+		t.Errorf("Expected the admin event in the audit trail, got %v", names)
+	}
+	data, _ := json.Marshal(export)
+	if bytes.Contains(data, []byte(alice.Password)) {
+		t.Errorf("The export must not contain the password hash")
+	}
+
+	var buf bytes.Buffer
+	if err := export.WriteZip(&buf); err != nil {
+		t.Fatalf("Failed to write the ZIP: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Invalid ZIP: %v", err)
+	}
+	var files []string
+	for _, f := range zr.File {
+		files = append(files, f.Name)
+	}
+	sort.Strings(files)
+	want := []string{"audit.json", "export.json", "login_history.json", "profile.json", "sessions.json"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Expected %v, got %v", want, files)
+	}
+
+	if err := privacy.Erase(ctx, alice.ID); err != nil {
+		t.Fatalf("Failed to erase: %v", err)
+	}
+	if sessions := authService.UserSessions(alice.ID); len(sessions) != 0 {
+		t.Errorf("Expected the sessions closed, got %d", len(sessions))
+	}
+	if _, err := privacy.Export(ctx, alice.ID); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound after the erasure, got %v", err)
+	}
+	left, _ := events.UserEvents(ctx, alice.ID)
+	for _, e := range left {
+		if len(e.Details) != 0 {
+			t.Errorf("Expected no details left in %s, got %v", e.Event, e.Details)
+		}
+	}
+	if err := privacy.Erase(ctx, alice.ID); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for the second erasure, got %v", err)
+	}
+}
+
+func eventNames(events []repository.UserEvent) []string {
+	names := []string{}
+	for _, e := range events {
+		names = append(names, e.Event)
+	}
+	return names
+}
@@ -0,0 +1,108 @@
+package unittest
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"AuthDB/utils"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPurgerCallsOnPurged(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserStore()
+	for _, name := range []string{"alice", "bobby"} {
+		u := &repository.User{Username: name, Email: name + "@example.com"}
+		if err := users.CreateUser(ctx, u); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+		if err := users.RequestDeletion(ctx, u.ID, u.ID); err != nil {
+			t.Fatalf("Failed to request the deletion: %v", err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if ids, _ := access.NewPurger(users, nil, time.Hour, false).Purge(ctx); len(ids) != 0 {
+		t.Errorf("Expected nothing purged within the grace period, got %v", ids)
+	}
+
+	purger := access.NewPurger(users, nil, 0, true)
+	var purged []int
+	purger.OnPurged = func(userID int, anonymized bool) {
+		if !anonymized {
+			t.Errorf("Expected user %d anonymized", userID)
+		}
+		purged = append(purged, userID)
+	}
+	ids, err := purger.Purge(ctx)
+	if err != nil || !reflect.DeepEqual(ids, []int{1, 2}) || !reflect.DeepEqual(purged, ids) {
+		t.Errorf("Expected users 1 and 2 purged, got %v and %v, %v", ids, purged, err)
+	}
+}
+
+func TestLoginCancelsDeletion(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserStore()
+	hash, err := utils.GenerateHash("secret123")
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	u := &repository.User{Username: "alice", Email: "alice@example.com", Password: hash}
+	if err := users.CreateUser(ctx, u); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	if err := users.RequestDeletion(ctx, u.ID, u.ID); err != nil {
+		t.Fatalf("Failed to request the deletion: %v", err)
+	}
+
+	session, err := auth.NewService(users).Login(ctx, "alice", "secret123", false)
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+	if session.User.Status != repository.StatusActive {
+		t.Errorf("Expected the session user active, got %s", session.User.Status)
+	}
+	got, _ := users.GetUser(ctx, u.ID)
+	if got.Status != repository.StatusActive || got.DeletionRequestedAt != nil {
+		t.Errorf("Expected the deletion cancelled, got %s", got.Status)
+	}
+}
+
+func TestLoginKeepsOtherDeletions(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserStore()
+	hash, err := utils.GenerateHash("secret123")
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	alice := &repository.User{Username: "alice", Email: "alice@example.com", Password: hash}
+	bob := &repository.User{Username: "bobby", Email: "bob@example.com", Password: hash}
+	for _, u := range []*repository.User{alice, bob} {
+		if err := users.CreateUser(ctx, u); err != nil {
+			t.Fatalf("Failed to create: %v", err)
+		}
+	}
+	// deleted by an admin, only RestoreUser keeps it
+	if err := users.RequestDeletion(ctx, alice.ID, 1000); err != nil {
+		t.Fatalf("Failed to request the deletion: %v", err)
+	}
+	// asked for by the user, the grace period is over but the purge didn't run yet
+	if err := users.RequestDeletion(ctx, bob.ID, bob.ID); err != nil {
+		t.Fatalf("Failed to request the deletion: %v", err)
+	}
+
+	authService := auth.NewService(users)
+	authService.SetDeletionGrace(0)
+	for _, u := range []*repository.User{alice, bob} {
+		if _, err := authService.Login(ctx, u.Username, "secret123", false); !errors.Is(err, auth.ErrAccountDeleted) {
+			t.Errorf("%s: expected ErrAccountDeleted, got %v", u.Username, err)
+		}
+		if got, _ := users.GetUser(ctx, u.ID); got.Status != repository.StatusPendingDeletion {
+			t.Errorf("%s: expected the deletion kept, got %s", u.Username, got.Status)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package unittest
+
+import (
+	"AuthDB/internal/access"
+	"testing"
+)
+
+// Proxy routes and session tokens
+
+func TestRoutesMatch(t *testing.T) {
+	routes := access.Routes{
+		{Prefix: "/"},
+		{Prefix: "/public/", Public: true},
+		{Path: "/api/users", Resource: "users", Action: "read"},
+		{Prefix: "/api/users/", Methods: []string{"DELETE"}, Resource: "users", Action: "delete"},
+		{Prefix: "/admin/", Resource: "admin", Action: "read"},
+		{Prefix: "/docs"},
+	}
+
+	tests := []struct {
+		method, path string
+		want         access.Route
+	}{
+		{"GET", "/anything", routes[0]},
+		{"GET", "/public/logo.png", routes[1]},
+		{"GET", "/api/users", routes[2]},
+		{"delete", "/api/users/7", routes[3]},
+		{"GET", "/api/users/7", routes[0]},
+		// a prefix ending in / covers the path without it
+		{"GET", "/admin", routes[4]},
+		{"GET", "/admin/", routes[4]},
+		{"GET", "/admin/users", routes[4]},
+		// and only whole segments
+		{"GET", "/administrator", routes[0]},
+		{"GET", "/docs", routes[5]},
+		{"GET", "/docs/api", routes[5]},
+		{"GET", "/docsearch", routes[0]},
+	}
+	for _, tt := range tests {
+		got, ok := routes.Match(tt.method, tt.path)
+		if !ok || got.Prefix != tt.want.Prefix || got.Path != tt.want.Path || got.Action != tt.want.Action {
+			t.Errorf("Match(%s %s) = %+v, want %+v", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	if _, ok := (access.Routes{{Path: "/only"}}).Match("GET", "/other"); ok {
+		t.Errorf("Expected no route for /other")
+	}
+}
+
+func TestSessionToken(t *testing.T) {
+	if got := access.SessionToken("Bearer abc", "token=cookie"); got != "abc" {
+		t.Errorf("Expected the bearer token, got %q", got)
+	}
+	if got := access.SessionToken("", "theme=dark; token=cookie"); got != "cookie" {
+		t.Errorf("Expected the cookie token, got %q", got)
+	}
+	if got := access.SessionToken("Basic xyz", ""); got != "" {
+		t.Errorf("Expected no token, got %q", got)
+	}
+}
@@ -0,0 +1,158 @@
+package unittest
+
+import (
+	"AuthDB/cmd/app/repository"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// fakeTx records what happened to it, the embedded nil pgx.Tx panics on anything else
+type fakeTx struct {
+	pgx.Tx
+	parent     *fakeTx
+	savepoints []*fakeTx
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *fakeTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	sp := &fakeTx{parent: tx}
+	tx.savepoints = append(tx.savepoints, sp)
+	return sp, nil
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	if !tx.committed {
+		tx.rolledBack = true
+	}
+	return nil
+}
+
+// fakeDB starts a fakeTx for every transaction
+type fakeDB struct {
+	txs []*fakeTx
+}
+
+func (db *fakeDB) Begin(ctx context.Context) (pgx.Tx, error) {
+	tx := &fakeTx{}
+	db.txs = append(db.txs, tx)
+	return tx, nil
+}
+
+func TestWithTxCommitsAndRollsBack(t *testing.T) {
+	db := &fakeDB{}
+	ctx := context.Background()
+	if repository.TxFromContext(ctx) != nil {
+		t.Fatalf("No transaction expected outside of WithTx")
+	}
+
+	err := repository.WithTx(ctx, db, func(ctx context.Context) error {
+		if repository.TxFromContext(ctx) != db.txs[0] {
+			t.Errorf("Expected the transaction in ctx")
+		}
+		return nil
+	})
+	if err != nil || !db.txs[0].committed {
+		t.Errorf("Expected a commit, got %v", err)
+	}
+
+	failed := errors.New("failed")
+	err = repository.WithTx(ctx, db, func(ctx context.Context) error { return failed })
+	if !errors.Is(err, failed) || !db.txs[1].rolledBack || db.txs[1].committed {
+		t.Errorf("Expected a rollback and the error of fn, got %v", err)
+	}
+}
+
+func TestWithTxRollsBackOnPanic(t *testing.T) {
+	db := &fakeDB{}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected the panic to go on")
+		}
+		if !db.txs[0].rolledBack {
+			t.Errorf("Expected a rollback")
+		}
+	}()
+	repository.WithTx(context.Background(), db, func(ctx context.Context) error { panic("boom") })
+}
+
+func TestWithTxRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		retries  []repository.TxOption
+		failures int
+		runs     int
+		success  bool
+	}{
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, nil, 2, 3, true},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, nil, 1, 2, true},
+		{"gives up", &pgconn.PgError{Code: "40001"}, nil, 10, repository.DefaultTxRetries + 1, false},
+		{"own limit", &pgconn.PgError{Code: "40001"}, []repository.TxOption{repository.Retries(1)}, 10, 2, false},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, nil, 10, 1, false},
+		{"other error", errors.New("failed"), nil, 10, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{}
+			runs := 0
+			err := repository.WithTx(context.Background(), db, func(ctx context.Context) error {
+				runs++
+				if runs <= tt.failures {
+					return tt.err
+				}
+				return nil
+			}, tt.retries...)
+			if runs != tt.runs || (err == nil) != tt.success {
+				t.Errorf("Expected %d runs and success %v, got %d runs and %v", tt.runs, tt.success, runs, err)
+			}
+			if len(db.txs) != runs {
+				t.Errorf("Expected a transaction per run, got %d", len(db.txs))
+			}
+		})
+	}
+}
+
+func TestWithTxNestsInSavepoints(t *testing.T) {
+	db := &fakeDB{}
+	failed := errors.New("failed")
+	err := repository.WithTx(context.Background(), db, func(ctx context.Context) error {
+		outer := db.txs[0]
+		err := repository.WithTx(ctx, db, func(ctx context.Context) error {
+			if repository.TxFromContext(ctx) != outer.savepoints[0] {
+				t.Errorf("Expected the savepoint in ctx")
+			}
+			return &pgconn.PgError{Code: "40001"}
+		})
+		// only the outermost call retries
+		if len(outer.savepoints) != 1 || !outer.savepoints[0].rolledBack {
+			t.Errorf("Expected one rolled back savepoint, got %d", len(outer.savepoints))
+		}
+		if err == nil {
+			t.Errorf("Expected the error of the savepoint")
+		}
+
+		if err := repository.WithTx(ctx, db, func(ctx context.Context) error { return nil }); err != nil {
+			t.Errorf("Failed to release the savepoint: %v", err)
+		}
+		if !outer.savepoints[1].committed {
+			t.Errorf("Expected the second savepoint to be released")
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("Expected the error of fn, got %v", err)
+	}
+	if len(db.txs) != 1 || !db.txs[0].rolledBack {
+		t.Errorf("Expected the only transaction to be rolled back")
+	}
+}
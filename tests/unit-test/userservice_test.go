@@ -0,0 +1,152 @@
+package unittest
+
+import (
+	"AuthDB/cmd/app/auth"
+	"AuthDB/cmd/app/repository"
+	"AuthDB/internal/access"
+	"AuthDB/internal/api/user"
+	pb "AuthDB/pkg/user_v1"
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUserServiceUpdateUser(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserStore()
+	repo := repository.NewRepository(nil)
+	authService := auth.NewService(users)
+	checker := access.NewChecker(repo, nil)
+	roleAdmin := access.NewRoleAdmin(repo, checker, authService)
+	service := user.NewUserService(access.NewUserAdmin(users, roleAdmin))
+
+	for _, name := range []string{"alice", "bobby"} {
+		if err := users.CreateUser(ctx, &repository.User{Username: name, Email: name + "@example.com"}); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	str := func(s string) *string { return &s }
+
+	got, err := service.UpdateUser(ctx, &pb.UpdateUserRequest{UserId: 1, Username: str("alicia"), Version: 1})
+	if err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if got.Username != "alicia" || got.Email != "alice@example.com" || got.Version != 2 {
+		t.Errorf("Expected the new username and version 2, got %v", got)
+	}
+
+	tests := []struct {
+		name string
+		req  *pb.UpdateUserRequest
+		code codes.Code
+	}{
+		{"stale version", &pb.UpdateUserRequest{UserId: 1, Email: str("new@example.com"), Version: 1}, codes.Aborted},
+		{"username taken", &pb.UpdateUserRequest{UserId: 1, Username: str("bobby")}, codes.AlreadyExists},
+		{"short username", &pb.UpdateUserRequest{UserId: 1, Username: str("al")}, codes.InvalidArgument},
+		{"empty email", &pb.UpdateUserRequest{UserId: 1, Email: str(" ")}, codes.InvalidArgument},
+		{"invalid email", &pb.UpdateUserRequest{UserId: 1, Email: str("alice@")}, codes.InvalidArgument},
+		{"weak password", &pb.UpdateUserRequest{UserId: 1, Password: str("12345678")}, codes.InvalidArgument},
+		{"missing user", &pb.UpdateUserRequest{UserId: 99, Username: str("ghost")}, codes.NotFound},
+	}
+	for _, tt := range tests {
+		if _, err := service.UpdateUser(ctx, tt.req); status.Code(err) != tt.code {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.code, err)
+		}
+	}
+
+	got, err = service.GetUser(ctx, &pb.GetUserRequest{UserId: 1})
+	if err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	if got.Username != "alicia" || got.Version != 2 {
+		t.Errorf("The failed updates must not change anything, got %v", got)
+	}
+}
+
+func TestUserServiceListUsers(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserStore()
+	repo := repository.NewRepository(nil)
+	authService := auth.NewService(users)
+	checker := access.NewChecker(repo, nil)
+	service := user.NewUserService(access.NewUserAdmin(users, access.NewRoleAdmin(repo, checker, authService)))
+
+	for _, name := range []string{"carol", "alice", "bobby", "dave", "erin"} {
+		if err := users.CreateUser(ctx, &repository.User{Username: name, Email: name + "@example.com"}); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	var names []string
+	req := &pb.ListUsersRequest{Sort: "-username", Limit: 2, WithTotal: true}
+	for {
+		resp, err := service.ListUsers(ctx, req)
+		if err != nil {
+			t.Fatalf("Failed to list: %v", err)
+		}
+		if resp.Total == nil || *resp.Total != 5 {
+			t.Errorf("Expected a total of 5, got %v", resp.Total)
+		}
+		for _, u := range resp.Users {
+			if u.CreatedAt == nil || u.Status != string(repository.StatusActive) {
+				t.Errorf("Expected the creation time and the status, got %v", u)
+			}
+			names = append(names, u.Username)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		req.Cursor = resp.NextCursor
+	}
+	if want := []string{"erin", "dave", "carol", "bobby", "alice"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected %v, got %v", want, names)
+	}
+
+	for name, req := range map[string]*pb.ListUsersRequest{
+		"unknown sort":  {Sort: "password"},
+		"bad cursor":    {Cursor: "nope"},
+		"limit too big": {Limit: access.MaxUserPageSize + 1},
+		"other order":   {Sort: "email", Cursor: req.Cursor},
+	} {
+		if _, err := service.ListUsers(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: expected InvalidArgument, got %v", name, err)
+		}
+	}
+}
+
+func TestUserServiceSearchUsers(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserStore()
+	repo := repository.NewRepository(nil)
+	checker := access.NewChecker(repo, nil)
+	service := user.NewUserService(access.NewUserAdmin(users, access.NewRoleAdmin(repo, checker, auth.NewService(users))))
+
+	for _, name := range []string{"jonathan", "jo", "bob"} {
+		if err := users.CreateUser(ctx, &repository.User{Username: name, Email: name + "@example.com"}); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	resp, err := service.SearchUsers(ctx, &pb.SearchUsersRequest{Query: " Jo "})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(resp.Matches) != 2 || resp.Matches[0].User.Username != "jo" || resp.Matches[1].User.Username != "jonathan" {
+		t.Fatalf("Expected jo and jonathan, got %v", resp.Matches)
+	}
+	if h := resp.Matches[1].Highlights; len(h) != 2 || h[0].Field != "username" || h[0].Start != 0 || h[0].End != 2 {
+		t.Errorf("Expected jo highlighted in the username and the email, got %v", h)
+	}
+
+	for name, req := range map[string]*pb.SearchUsersRequest{
+		"empty query":   {Query: "  "},
+		"limit too big": {Query: "jo", Limit: access.MaxSearchLimit + 1},
+	} {
+		if _, err := service.SearchUsers(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: expected InvalidArgument, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package unittest
+
+import (
+	"AuthDB/cmd/app/repository"
+	"AuthDB/tests/storetest"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestMemoryUserStore(t *testing.T) {
+	storetest.TestUserStore(t, func(t *testing.T, fn func(store repository.UserStore)) {
+		fn(repository.NewMemoryUserStore())
+	})
+}
+
+func TestMemoryEventLog(t *testing.T) {
+	storetest.TestEventLog(t, func(t *testing.T, fn func(users repository.UserStore, events repository.EventLog)) {
+		fn(repository.NewMemoryUserStore(), repository.NewMemoryEventLog())
+	})
+}
+
+func TestMemoryUserStoreReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryUserStore()
+	u := &repository.User{Username: "alice", Email: "alice@example.com", Password: "hash"}
+	if err := store.CreateUser(ctx, u); err != nil {
+		t.Fatalf("Failed to create: %v", err)
+	}
+	u.Username = "changed"
+	got, _ := store.GetUser(ctx, u.ID)
+	got.Roles[0] = "changed"
+
+	got, _ = store.GetUser(ctx, u.ID)
+	if got.Username != "alice" || got.Roles[0] != repository.DefaultRole {
+		t.Errorf("The stored user changed: %+v", got)
+	}
+}
+
+func TestMemoryUserStoreConcurrentCreates(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryUserStore()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// every name twice, only one of them can win
+			name := fmt.Sprintf("user%d", i/2)
+			store.CreateUser(ctx, &repository.User{Username: name, Email: name + "@example.com"})
+			store.ListUsers(ctx, repository.UserFilter{})
+		}(i)
+	}
+	wg.Wait()
+
+	page, err := store.ListUsers(ctx, repository.UserFilter{CountTotal: true})
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	if *page.Total != 25 || len(page.Users) != 25 {
+		t.Errorf("Expected 25 users, got %d of %d", len(page.Users), *page.Total)
+	}
+}